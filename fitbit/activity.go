@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// lateExerciseWindow is how close to bed an exercise has to end to be
+	// called late.
+	lateExerciseWindow = 3 * time.Hour
+	// lowActivitySteps is the step count under which a day counts as lazy.
+	lowActivitySteps = 5000
+)
+
+// ActivityData is what the user did the day before a night.
+type ActivityData struct {
+	Date      string
+	Steps     int
+	StepsGoal int
+
+	ActiveZoneMinutes    int
+	VeryActiveMinutes    int
+	FairlyActiveMinutes  int
+	LightlyActiveMinutes int
+	SedentaryMinutes     int
+
+	Exercises   []Exercise
+	LowActivity bool
+}
+
+// Exercise is an exercise logged or detected during the day.
+type Exercise struct {
+	Name      string
+	StartTime string // wall clock, "2006-01-02T15:04:05.000"
+	EndTime   string
+	Minutes   int
+	Calories  int
+
+	// HoursBeforeBed is how long before the main sleep it ended.
+	HoursBeforeBed float64
+	Late           bool
+}
+
+type fitbitActivityResponse struct {
+	Activities []struct {
+		Name      string `json:"name"`
+		StartDate string `json:"startDate"`
+		StartTime string `json:"startTime"`
+		Duration  int64  `json:"duration"` // milliseconds
+		Calories  int    `json:"calories"`
+	} `json:"activities"`
+
+	Goals struct {
+		Steps int `json:"steps"`
+	} `json:"goals"`
+
+	Summary struct {
+		Steps                int `json:"steps"`
+		VeryActiveMinutes    int `json:"veryActiveMinutes"`
+		FairlyActiveMinutes  int `json:"fairlyActiveMinutes"`
+		LightlyActiveMinutes int `json:"lightlyActiveMinutes"`
+		SedentaryMinutes     int `json:"sedentaryMinutes"`
+	} `json:"summary"`
+}
+
+type fitbitAZMResponse struct {
+	AZM []struct {
+		DateTime string `json:"dateTime"`
+		Value    struct {
+			ActiveZoneMinutes int `json:"activeZoneMinutes"`
+		} `json:"value"`
+	} `json:"activities-active-zone-minutes"`
+}
+
+func getActivitySummary(client *FitbitClient, date string) (*fitbitActivityResponse, error) {
+	body, err := fitbitGet(client, "/1/user/"+client.UserID+"/activities/date/"+date+".json")
+	if err != nil {
+		return nil, fmt.Errorf("fitbit activity endpoint: %w", err)
+	}
+
+	var activityResp fitbitActivityResponse
+	if err := json.Unmarshal(body, &activityResp); err != nil {
+		return nil, err
+	}
+	return &activityResp, nil
+}
+
+func getActiveZoneMinutes(client *FitbitClient, date string) (*fitbitAZMResponse, error) {
+	body, err := fitbitGet(client, "/1/user/"+client.UserID+"/activities/active-zone-minutes/date/"+date+"/1d.json")
+	if err != nil {
+		return nil, fmt.Errorf("fitbit active zone minutes endpoint: %w", err)
+	}
+
+	var azmResp fitbitAZMResponse
+	if err := json.Unmarshal(body, &azmResp); err != nil {
+		return nil, err
+	}
+	return &azmResp, nil
+}
+
+// getActivityData gathers the activity of the day before night. Like
+// getHeartData, the error lists what failed and the data is nil only if
+// everything did.
+func getActivityData(client *FitbitClient, night *Night) (*ActivityData, error) {
+	day, err := time.Parse(dateLayout, night.Date)
+	if err != nil {
+		return nil, err
+	}
+	date := day.AddDate(0, 0, -1).Format(dateLayout)
+
+	summary, summaryErr := getActivitySummary(client, date)
+	azm, azmErr := getActiveZoneMinutes(client, date)
+	if summaryErr != nil && azmErr != nil {
+		return nil, errors.Join(summaryErr, azmErr)
+	}
+
+	activity := &ActivityData{Date: date}
+
+	if azm != nil && len(azm.AZM) > 0 {
+		activity.ActiveZoneMinutes = azm.AZM[0].Value.ActiveZoneMinutes
+	}
+
+	if summary != nil {
+		activity.Steps = summary.Summary.Steps
+		activity.StepsGoal = summary.Goals.Steps
+		activity.VeryActiveMinutes = summary.Summary.VeryActiveMinutes
+		activity.FairlyActiveMinutes = summary.Summary.FairlyActiveMinutes
+		activity.LightlyActiveMinutes = summary.Summary.LightlyActiveMinutes
+		activity.SedentaryMinutes = summary.Summary.SedentaryMinutes
+		activity.LowActivity = activity.Steps < lowActivitySteps
+
+		bedtime := night.Main.Start()
+		for _, a := range summary.Activities {
+			start, err := time.Parse("2006-01-02 15:04", a.StartDate+" "+a.StartTime)
+			if err != nil {
+				continue
+			}
+			end := start.Add(time.Duration(a.Duration) * time.Millisecond)
+			beforeBed := bedtime.Sub(end)
+
+			activity.Exercises = append(activity.Exercises, Exercise{
+				Name:           a.Name,
+				StartTime:      start.Format(fitbitTimeLayout),
+				EndTime:        end.Format(fitbitTimeLayout),
+				Minutes:        int(a.Duration / 60000),
+				Calories:       a.Calories,
+				HoursBeforeBed: beforeBed.Hours(),
+				Late:           beforeBed >= 0 && beforeBed < lateExerciseWindow,
+			})
+		}
+	}
+
+	return activity, errors.Join(summaryErr, azmErr)
+}
+
+// Summary is the compact line for the Slack message.
+func (a *ActivityData) Summary() string {
+	steps := fmt.Sprintf("%d steps", a.Steps)
+	if a.LowActivity {
+		steps += " (lazy day)"
+	}
+	parts := []string{steps, fmt.Sprintf("%d active zone minutes", a.ActiveZoneMinutes)}
+
+	for _, e := range a.Exercises {
+		if e.Late {
+			end, _ := time.Parse(fitbitTimeLayout, e.EndTime)
+			parts = append(parts, fmt.Sprintf("%s until %s, %.1fh before bed", strings.ToLower(e.Name), end.Format("3:04 PM"), e.HoursBeforeBed))
+		}
+	}
+
+	return "Day before: " + strings.Join(parts, ", ")
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"fitbit-workflow/fakefitbit"
+)
+
+func TestGetActivityData(t *testing.T) {
+	_, srv := fakefitbit.NewServer()
+	defer srv.Close()
+
+	cfg := testConfig(t, srv.URL)
+	c := fakeClient(t, cfg)
+
+	// the day before is 2026-10-13, a busy day with a run at 18:00
+	activity, err := getActivityData(c, fakeNight(t, c, testNightDate))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ActivityData{
+		Date:                 "2026-10-13",
+		Steps:                10700,
+		StepsGoal:            10000,
+		ActiveZoneMinutes:    38,
+		VeryActiveMinutes:    32,
+		FairlyActiveMinutes:  14,
+		LightlyActiveMinutes: 180,
+		SedentaryMinutes:     690,
+	}
+	exercises := activity.Exercises
+	activity.Exercises = nil
+	if !reflect.DeepEqual(*activity, want) {
+		t.Errorf("getActivityData() = %+v, want %+v", *activity, want)
+	}
+	if len(exercises) != 1 {
+		t.Fatalf("exercises = %+v, want the run", exercises)
+	}
+	run := exercises[0]
+	if run.Name != "Run" || run.StartTime != "2026-10-13T18:00:00.000" || run.EndTime != "2026-10-13T18:45:00.000" || run.Minutes != 45 || run.Calories != 420 {
+		t.Errorf("run = %+v", run)
+	}
+	// ended at 18:45, asleep at 23:10
+	if run.Late || run.HoursBeforeBed < 4.41 || run.HoursBeforeBed > 4.42 {
+		t.Errorf("run ended %.2fh before bed, late %t, want 4.42h and not late", run.HoursBeforeBed, run.Late)
+	}
+
+	// the day before 2026-10-15 has 3500 steps and a run until 22:25
+	activity, err = getActivityData(c, fakeNight(t, c, "2026-10-15"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if activity.Steps != 3500 || !activity.LowActivity {
+		t.Errorf("%d steps, low activity %t, want 3500 and low", activity.Steps, activity.LowActivity)
+	}
+	if len(activity.Exercises) != 1 || !activity.Exercises[0].Late || activity.Exercises[0].HoursBeforeBed != 0.75 {
+		t.Errorf("exercises = %+v, want a late run 0.75h before bed", activity.Exercises)
+	}
+}
+
+func TestGetActivityDataErrors(t *testing.T) {
+	fake, srv := fakefitbit.NewServer()
+	defer srv.Close()
+
+	cfg := testConfig(t, srv.URL)
+	c := fakeClient(t, cfg)
+	night := fakeNight(t, c, testNightDate)
+
+	fake.RevokeScope("activity")
+	activity, err := getActivityData(c, night)
+	if err == nil || activity != nil {
+		t.Errorf("getActivityData() without activity = %+v, %v, want nil and an error", activity, err)
+	}
+}
+
+func TestActivitySummary(t *testing.T) {
+	run := func(end string, hours float64, late bool) Exercise {
+		return Exercise{Name: "Run", EndTime: "2026-10-13T" + end + ":00.000", HoursBeforeBed: hours, Late: late}
+	}
+
+	tests := []struct {
+		name     string
+		activity ActivityData
+		want     string
+	}{
+		{"busy day", ActivityData{Steps: 10700, ActiveZoneMinutes: 38, Exercises: []Exercise{run("18:45", 4.4, false)}}, "Day before: 10700 steps, 38 active zone minutes"},
+		{"lazy day", ActivityData{Steps: 3500, LowActivity: true}, "Day before: 3500 steps (lazy day), 0 active zone minutes"},
+		{"late run", ActivityData{Steps: 8000, ActiveZoneMinutes: 50, Exercises: []Exercise{run("22:25", 0.75, true)}}, "Day before: 8000 steps, 50 active zone minutes, run until 10:25 PM, 0.8h before bed"},
+		{"two late", ActivityData{Steps: 8000, Exercises: []Exercise{
+			run("21:00", 2.2, true),
+			{Name: "Weights", EndTime: "2026-10-13T22:00:00.000", HoursBeforeBed: 1.2, Late: true},
+		}}, "Day before: 8000 steps, 0 active zone minutes, run until 9:00 PM, 2.2h before bed, weights until 10:00 PM, 1.2h before bed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.activity.Summary(); got != tt.want {
+				t.Errorf("Summary() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatSleepLogActivity(t *testing.T) {
+	_, srv := fakefitbit.NewServer()
+	defer srv.Close()
+
+	cfg := testConfig(t, srv.URL)
+	c := fakeClient(t, cfg)
+	night := fakeNight(t, c, "2026-10-15")
+
+	data := MakeSleepLogData(night, sleepLogs("2026-10-15", 3), 7)
+	text, err := FormatSleepLog(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(text, "ACTIVITY THE DAY BEFORE") {
+		t.Errorf("sleep log without activity = %q", text)
+	}
+
+	if data.Activity, err = getActivityData(c, night); err != nil {
+		t.Fatal(err)
+	}
+	if text, err = FormatSleepLog(data); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"ACTIVITY THE DAY BEFORE (2026-10-14):",
+		"- STEPS: 3500 (GOAL: 10000) LOW\n",
+		"- ACTIVE_ZONE_MINUTES: 38\n",
+		"- ACTIVE_MINUTES: 32 very, 14 fairly, 180 lightly\n",
+		"- SEDENTARY_MINUTES: 690\n",
+		"- EXERCISE: Run, 2026-10-14T21:40:00.000 -> 2026-10-14T22:25:00.000 (45 minutes, 420 calories), ended 0.8h before bed LATE\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("sleep log = %q, want %q", text, want)
+		}
+	}
+}
+
+func TestReportActivityLine(t *testing.T) {
+	_, srv := fakefitbit.NewServer()
+	defer srv.Close()
+
+	cfg := testConfig(t, srv.URL)
+	cfg.Fitbit.Features = []Feature{FeatureActivity}
+	if text := reportText(t, cfg, "2026-10-15"); !strings.Contains(text, "\nDay before: 3500 steps (lazy day), 38 active zone minutes, run until 10:25 PM, 0.8h before bed") {
+		t.Errorf("report = %q, want the activity line", text)
+	}
+
+	cfg = testConfig(t, srv.URL)
+	if text := reportText(t, cfg, "2026-10-15"); strings.Contains(text, "Day before") {
+		t.Errorf("report without the activity feature = %q", text)
+	}
+}
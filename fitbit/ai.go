@@ -2,13 +2,18 @@ package main
 
 import (
 	"bytes"
+	"context"
 	_ "embed"
 	"encoding/json"
 	"fmt"
-	"net/http"
-	"os"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
 	"text/template"
 	"time"
+
+	"fitbit-workflow/metrics"
 )
 
 //go:embed template.tmpl
@@ -29,8 +34,22 @@ type AiMessage struct {
 }
 
 type AiRequest struct {
-	Messages []AiMessage `json:"messages"`
-	Model    string      `json:"model"`
+	Messages       []AiMessage     `json:"messages"`
+	Model          string          `json:"model"`
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+}
+
+// ResponseFormat asks an OpenAI compatible API for JSON: any JSON object
+// with "json_object", or one following JSONSchema with "json_schema".
+type ResponseFormat struct {
+	Type       string      `json:"type"`
+	JSONSchema *JSONSchema `json:"json_schema,omitempty"`
+}
+
+type JSONSchema struct {
+	Name   string          `json:"name"`
+	Strict bool            `json:"strict"`
+	Schema json.RawMessage `json:"schema"`
 }
 
 func (m *AiResponse) GetContent() string {
@@ -40,39 +59,6 @@ func (m *AiResponse) GetContent() string {
 	return ""
 }
 
-func Complete(messages []AiMessage, model, aiBaseUrl string) (string, error) {
-
-	request := AiRequest{
-		Model:    model,
-		Messages: messages,
-	}
-
-	body, err := json.Marshal(request)
-	if err != nil {
-		return "", err
-	}
-
-	req, err := http.NewRequest("POST", aiBaseUrl, bytes.NewReader(body))
-	if err != nil {
-		return "", err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+os.Getenv("AI_API_KEY"))
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	var response AiResponse
-	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return "", err
-	}
-
-	return response.GetContent(), nil
-}
-
 // system prompt helper
 
 type SleepLogData struct {
@@ -88,7 +74,33 @@ type SleepLogData struct {
 	TimeInBed           int
 	Stages              SleepStages
 	StageDetails        []StageDetail
-	History             []HistoryItem
+
+	// every session of the date, naps included
+	Sessions      []SessionSummary
+	NapCount      int
+	TotalDuration string
+	TotalAsleep   int
+	WindowStart   string
+	WindowEnd     string
+
+	// nil when the feature is off or Fitbit had nothing
+	Heart    *HeartData
+	Vitals   []Vital
+	Activity *ActivityData
+
+	// sleep debt and consistency over the last few weeks
+	Metrics *metrics.Summary
+
+	History []HistoryItem
+}
+
+type SessionSummary struct {
+	IsMain        bool
+	StartTime     string
+	EndTime       string
+	Duration      string
+	MinutesAsleep int
+	Efficiency    int
 }
 
 type SleepStages struct {
@@ -127,15 +139,71 @@ func FormatSleepLog(data SleepLogData) (string, error) {
 	return buf.String(), nil
 }
 
-func GetSystemPrompt() string {
-	return systemPromptString
+// writeRoast has persona roast the night, as JSON unless the config asks
+// for text. A reply that isn't the JSON asked for is used as free text.
+// Without an answer from the AI, or when nothing of it passes the
+// guardrails, it's the canned roast.
+func writeRoast(cfg *Config, llm LLM, guardrails *Guardrails, persona *Persona, data SleepLogData, goalHours float64, logger *log.Logger) *Roast {
+	canned := &Roast{Roast: cannedRoast(data, goalHours)}
+
+	sleepLogDataMessage, err := FormatSleepLog(data)
+	if err != nil {
+		logger.Println("Error formatting sleep log data:", err)
+		return canned
+	}
+	logger.Println("Sleep log message:", sleepLogDataMessage)
+
+	promptMessages := sleepPrompt(persona, cfg.Report.HistoryDays, sleepLogDataMessage)
+	format := roastResponseFormat(cfg)
+	requestMessages := promptMessages
+	if format != nil {
+		// the format goes with the persona, some APIs only take one system prompt
+		requestMessages = append([]AiMessage(nil), promptMessages...)
+		requestMessages[0].Content += "\n\n" + roastFormatString
+	}
+
+	aiResponse, err := llm.Complete(context.Background(), requestMessages, format)
+	if err != nil {
+		logger.Println("Error generating AI message, using the canned roast:", err)
+		return canned
+	}
+	logger.Println("AI response:", aiResponse)
+
+	roast := &Roast{Roast: aiResponse}
+	if format != nil {
+		parsed, err := parseRoast(aiResponse)
+		if err != nil {
+			logger.Println("AI response isn't a valid roast, using it as free text:", err)
+			roast = &Roast{Roast: freeTextRoast(aiResponse, parsed)}
+		} else {
+			roast = parsed
+		}
+	}
+
+	// rewrites are asked for in text, about the roast alone
+	if roast.Roast != "" {
+		roast.Roast = enforceGuardrails(guardrails, llm, persona, promptMessages, roast.Roast, logger)
+	}
+	if roast.Roast == "" {
+		logger.Println("Nothing left of the AI message, using the canned roast")
+		return canned
+	}
+	roast.Headline = sanitizeWithGuardrails(guardrails, persona, roast.Headline, logger)
+	roast.PositiveNote = sanitizeWithGuardrails(guardrails, persona, roast.PositiveNote, logger)
+	return roast
+}
+
+// GetSystemPrompt is the part of the prompt every persona shares, for a
+// history of up to historyDays nights.
+func GetSystemPrompt(historyDays int) string {
+	return strings.ReplaceAll(systemPromptString, "HISTORY_DAYS", strconv.Itoa(historyDays))
 }
 
-func MakeSleepLogData(sleepData *FitbitSleepResponse, rangeData *FitbitSleepResponse) SleepLogData {
+func MakeSleepLogData(night *Night, rangeData *FitbitSleepResponse, historyDays int) SleepLogData {
+	mainSleep := night.Main
 
 	var stageDetails []StageDetail
-	const fitbitTimeLayout = "2006-01-02T15:04:05.000"
-	for _, s := range sleepData.Sleep[0].Levels.Data {
+	for _, s := range mainSleep.Levels.Data {
 		startTime, _ := time.Parse(fitbitTimeLayout, s.DateTime)
 		endTime := startTime.Add(time.Duration(s.Seconds) * time.Second)
 		stageDetails = append(stageDetails, StageDetail{
@@ -146,51 +214,60 @@ func MakeSleepLogData(sleepData *FitbitSleepResponse, rangeData *FitbitSleepResp
 		})
 	}
 
-	// Build history from range data: deduplicate by dateOfSleep, preferring isMainSleep
-	seen := make(map[string]bool)
+	var sessions []SessionSummary
+	for _, s := range night.Sessions() {
+		sessions = append(sessions, SessionSummary{
+			IsMain:        s.LogID == mainSleep.LogID,
+			StartTime:     s.StartTime,
+			EndTime:       s.EndTime,
+			Duration:      fmt.Sprintf("%d minutes", s.Duration/60000),
+			MinutesAsleep: s.MinutesAsleep,
+			Efficiency:    s.Efficiency,
+		})
+	}
+
+	// one line per earlier night, built from its main sleep
 	var history []HistoryItem
-	if rangeData != nil {
-		// Sort by dateOfSleep ascending so we can take the last 5 unique days
-		for _, s := range rangeData.Sleep {
-			if seen[s.DateOfSleep] {
-				continue
-			}
-			// Only include isMainSleep entries for cleaner history
-			// but fall back if there's no main sleep for that date
-			if !s.IsMainSleep {
-				continue
-			}
-			seen[s.DateOfSleep] = true
-			history = append(history, HistoryItem{
-				Date:       s.DateOfSleep,
-				Duration:   fmt.Sprintf("%d minutes", s.Duration/60000),
-				Efficiency: s.Efficiency,
-			})
-		}
-		// Take last 5
-		if len(history) > 5 {
-			history = history[len(history)-5:]
+	for _, n := range nightsByDate(rangeData) {
+		if n.Date == night.Date {
+			continue
 		}
+		history = append(history, HistoryItem{
+			Date:       n.Date,
+			Duration:   fmt.Sprintf("%d minutes", n.Main.Duration/60000),
+			Efficiency: n.Main.Efficiency,
+		})
+	}
+	sort.Slice(history, func(i, j int) bool { return history[i].Date > history[j].Date })
+	// keep the most recent, report.history_days of them
+	if len(history) > historyDays {
+		history = history[:historyDays]
 	}
 
 	return SleepLogData{
-		Date:                sleepData.Sleep[0].DateOfSleep,
-		Duration:            fmt.Sprintf("%d minutes", sleepData.Sleep[0].Duration/60000),
-		Efficiency:          fmt.Sprintf("%d%%", sleepData.Sleep[0].Efficiency),
-		StartTime:           sleepData.Sleep[0].StartTime,
-		EndTime:             sleepData.Sleep[0].EndTime,
-		MinutesAfterWakeup:  sleepData.Sleep[0].MinutesAfterWakeup,
-		MinutesAwake:        sleepData.Sleep[0].MinutesAwake,
-		MinutesAsleep:       sleepData.Sleep[0].MinutesAsleep,
-		MinutesToFallAsleep: sleepData.Sleep[0].MinutesToFallAsleep,
-		TimeInBed:           sleepData.Sleep[0].TimeInBed,
+		Date:                night.Date,
+		Duration:            fmt.Sprintf("%d minutes", mainSleep.Duration/60000),
+		Efficiency:          fmt.Sprintf("%d%%", mainSleep.Efficiency),
+		StartTime:           mainSleep.StartTime,
+		EndTime:             mainSleep.EndTime,
+		MinutesAfterWakeup:  mainSleep.MinutesAfterWakeup,
+		MinutesAwake:        mainSleep.MinutesAwake,
+		MinutesAsleep:       mainSleep.MinutesAsleep,
+		MinutesToFallAsleep: mainSleep.MinutesToFallAsleep,
+		TimeInBed:           mainSleep.TimeInBed,
 		Stages: SleepStages{
-			Deep:  sleepData.Sleep[0].Levels.Summary.Deep.Minutes,
-			Light: sleepData.Sleep[0].Levels.Summary.Light.Minutes,
-			Rem:   sleepData.Sleep[0].Levels.Summary.Rem.Minutes,
-			Wake:  sleepData.Sleep[0].Levels.Summary.Wake.Minutes,
+			Deep:  mainSleep.Levels.Summary.Deep.Minutes,
+			Light: mainSleep.Levels.Summary.Light.Minutes,
+			Rem:   mainSleep.Levels.Summary.Rem.Minutes,
+			Wake:  mainSleep.Levels.Summary.Wake.Minutes,
 		},
-		StageDetails: stageDetails,
-		History:      history,
+		StageDetails:  stageDetails,
+		Sessions:      sessions,
+		NapCount:      len(night.Naps),
+		TotalDuration: fmt.Sprintf("%d minutes", night.TotalMillis/60000),
+		TotalAsleep:   night.MinutesAsleep,
+		WindowStart:   night.Start.Format(fitbitTimeLayout),
+		WindowEnd:     night.End.Format(fitbitTimeLayout),
+		History:       history,
 	}
 }
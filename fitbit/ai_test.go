@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// sleepLogs is a main sleep of 7 hours on each of days dates up to end.
+func sleepLogs(end string, days int) *FitbitSleepResponse {
+	day, _ := time.Parse(dateLayout, end)
+
+	resp := &FitbitSleepResponse{}
+	for i := range days {
+		date := day.AddDate(0, 0, -i)
+		resp.Sleep = append(resp.Sleep, SleepLog{
+			LogID:         date.Unix(),
+			DateOfSleep:   date.Format(dateLayout),
+			StartTime:     date.Add(-time.Hour).Format(fitbitTimeLayout),
+			EndTime:       date.Add(6 * time.Hour).Format(fitbitTimeLayout),
+			Duration:      (7 * time.Hour).Milliseconds(),
+			Efficiency:    90,
+			IsMainSleep:   true,
+			MinutesAsleep: 400,
+		})
+	}
+	return resp
+}
+
+func TestMakeSleepLogDataHistory(t *testing.T) {
+	night := newNight("2026-10-14", sleepLogs("2026-10-14", 1).Sleep)
+	rangeData := sleepLogs("2026-10-14", 12)
+
+	for _, historyDays := range []int{1, 5, 7, 11, 30} {
+		data := MakeSleepLogData(night, rangeData, historyDays)
+
+		// tonight isn't part of its own history
+		want := min(historyDays, 11)
+		if len(data.History) != want {
+			t.Errorf("history_days %d: %d nights of history, want %d", historyDays, len(data.History), want)
+			continue
+		}
+		if data.History[0].Date != "2026-10-13" {
+			t.Errorf("history_days %d: history starts on %s, want the night before", historyDays, data.History[0].Date)
+		}
+		for i := 1; i < len(data.History); i++ {
+			if data.History[i].Date >= data.History[i-1].Date {
+				t.Errorf("history_days %d: %s after %s, want newest first", historyDays, data.History[i].Date, data.History[i-1].Date)
+			}
+		}
+	}
+}
+
+func TestSystemPromptHistoryDays(t *testing.T) {
+	prompt := GetSystemPrompt(14)
+	if !strings.Contains(prompt, "history of the last 14 sleep logs") {
+		t.Error("the system prompt doesn't say how many nights of history there are")
+	}
+	if strings.Contains(prompt, "HISTORY_DAYS") {
+		t.Error("the system prompt still has the HISTORY_DAYS placeholder")
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// anthropicVersion is the messages API version the requests are written
+// against.
+const anthropicVersion = "2023-06-01"
+
+// anthropicLLM talks to a messages API, Anthropic's or a compatible one.
+type anthropicLLM struct {
+	api       llmAPI
+	model     string
+	maxTokens int
+}
+
+type anthropicRequest struct {
+	Model     string      `json:"model"`
+	MaxTokens int         `json:"max_tokens"`
+	System    string      `json:"system,omitempty"`
+	Messages  []AiMessage `json:"messages"`
+}
+
+type anthropicResponse struct {
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content"`
+	StopReason string `json:"stop_reason"`
+}
+
+// The messages API has no response format, a JSON reply is down to the
+// prompt asking for one.
+func (a *anthropicLLM) Complete(ctx context.Context, messages []AiMessage, format *ResponseFormat) (string, error) {
+	header := http.Header{}
+	header.Set("anthropic-version", anthropicVersion)
+	if a.api.apiKey != "" {
+		header.Set("x-api-key", a.api.apiKey)
+	}
+
+	system, rest := anthropicMessages(messages)
+
+	var response anthropicResponse
+	err := a.api.post(ctx, header, anthropicRequest{
+		Model:     a.model,
+		MaxTokens: a.maxTokens,
+		System:    system,
+		Messages:  rest,
+	}, &response)
+	if err != nil {
+		return "", err
+	}
+
+	var text strings.Builder
+	for _, block := range response.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	return completion(a.api.provider, text.String())
+}
+
+// anthropicMessages splits messages for the messages API, which takes a
+// single system prompt and then alternating user and assistant turns. The
+// first system message is the prompt; the later ones, like the sleep stats,
+// are sent as user turns.
+func anthropicMessages(messages []AiMessage) (system string, rest []AiMessage) {
+	for i, m := range messages {
+		if m.Role == "system" && i == 0 {
+			system = m.Content
+			continue
+		}
+
+		role := m.Role
+		if role == "system" {
+			role = "user"
+		}
+		// merge turns in a row from the same side
+		if n := len(rest); n > 0 && rest[n-1].Role == role {
+			rest[n-1].Content += "\n\n" + m.Content
+			continue
+		}
+		rest = append(rest, AiMessage{Role: role, Content: m.Content})
+	}
+	return system, rest
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// maxSleepRangeDays is the longest range the sleep range endpoint serves.
+const maxSleepRangeDays = 100
+
+// maxRateLimitWaits is how many times a request waits out the hourly rate
+// limit before giving up.
+const maxRateLimitWaits = 3
+
+// backfillCommand imports the sleep of every linked user, or just --user,
+// from --from to --to into the history database.
+func backfillCommand(cfg *Config, args []string) error {
+	flags := flag.NewFlagSet("backfill", flag.ContinueOnError)
+	from := flags.String("from", "", "first date to import, YYYY-MM-DD")
+	to := flags.String("to", time.Now().Format(dateLayout), "last date to import, YYYY-MM-DD")
+	userID := flags.String("user", "", "only import this Fitbit user ID")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || flags.NArg() > 0 {
+		return errors.New("usage: backfill --from YYYY-MM-DD [--to YYYY-MM-DD] [--user ID]")
+	}
+
+	start, err := time.Parse(dateLayout, *from)
+	if err != nil {
+		return fmt.Errorf("--from: %w", err)
+	}
+	end, err := time.Parse(dateLayout, *to)
+	if err != nil {
+		return fmt.Errorf("--to: %w", err)
+	}
+	if end.Before(start) {
+		return fmt.Errorf("--to %s is before --from %s", *to, *from)
+	}
+
+	registry, err := openRegistry(cfg)
+	if err != nil {
+		return err
+	}
+
+	history, err := openHistory(cfg)
+	if err != nil {
+		return err
+	}
+	defer history.Close()
+
+	secret := *newSecretClient(cfg)
+
+	found := false
+	var errs []error
+	for _, u := range registry.Users() {
+		if *userID != "" && u.ID != *userID {
+			continue
+		}
+		found = true
+
+		client, err := registry.Client(u, secret)
+		if err == nil {
+			err = backfillUser(client, history, u.ID, start, end)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", u.ID, err))
+		}
+	}
+	if *userID != "" && !found {
+		return fmt.Errorf("no linked user %s", *userID)
+	}
+
+	return errors.Join(errs...)
+}
+
+// backfillUser imports a user's sleep from start to end, oldest first, one
+// range request per 100 days.
+func backfillUser(client *FitbitClient, history *History, userID string, start, end time.Time) error {
+	for pageStart := start; !pageStart.After(end); pageStart = pageStart.AddDate(0, 0, maxSleepRangeDays) {
+		pageEnd := pageStart.AddDate(0, 0, maxSleepRangeDays-1)
+		if pageEnd.After(end) {
+			pageEnd = end
+		}
+		from, to := pageStart.Format(dateLayout), pageEnd.Format(dateLayout)
+
+		resp, err := getSleepRangeRateLimited(client, userID, from, to)
+		if err != nil {
+			return fmt.Errorf("%s to %s: %w", from, to, err)
+		}
+		if err := history.SaveSleep(userID, from, to, resp); err != nil {
+			return fmt.Errorf("saving %s to %s: %w", from, to, err)
+		}
+
+		log.Printf("[%s] Imported %d sleep logs from %s to %s", userID, len(resp.Sleep), from, to)
+	}
+
+	return nil
+}
+
+// getSleepRangeRateLimited is getSleepRange, but it waits for Fitbit's
+// hourly rate limit to reset instead of failing right away.
+func getSleepRangeRateLimited(client *FitbitClient, userID, startDate, endDate string) (*FitbitSleepResponse, error) {
+	for waits := 0; ; waits++ {
+		resp, err := getSleepRange(client, startDate, endDate)
+
+		var apiErr *FitbitAPIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests || waits == maxRateLimitWaits {
+			return resp, err
+		}
+
+		wait := apiErr.RetryAfter
+		if wait == 0 {
+			wait = time.Minute
+		}
+		// the reset is in whole seconds, don't knock right before it
+		wait += time.Second
+
+		log.Printf("[%s] Rate limited by Fitbit, waiting %s", userID, wait)
+		time.Sleep(wait)
+	}
+}
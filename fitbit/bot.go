@@ -0,0 +1,257 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/espcaa/random-workflows-that-actually-are-bots/slack"
+)
+
+// Bot runs one report loop per registered user and keeps track of what each
+// loop last did, for the status page.
+type Bot struct {
+	cfg         *Config
+	registry    *Registry
+	ledger      *Ledger
+	history     *History
+	llm         LLM
+	guardrails  *Guardrails
+	secret      SecretClient
+	slackClient *slack.Client
+	clock       Clock
+	runTest     bool
+
+	mu      sync.Mutex
+	running map[string]*FitbitClient
+	status  map[string]*UserStatus
+	notify  map[string]chan string
+	wg      sync.WaitGroup
+}
+
+// StatusInfo is what a user's loop last did.
+type StatusInfo struct {
+	LastFetch    time.Time
+	LastFetchErr string
+	LastPost     time.Time
+	LastPostErr  string
+	NextRun      time.Time
+}
+
+// UserStatus is the StatusInfo of a running loop, updated as it goes.
+type UserStatus struct {
+	mu   sync.Mutex
+	info StatusInfo
+}
+
+func (s *UserStatus) fetched(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.info.LastFetch = time.Now()
+	s.info.LastFetchErr = errString(err)
+}
+
+func (s *UserStatus) posted(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.info.LastPost = time.Now()
+	s.info.LastPostErr = errString(err)
+}
+
+func (s *UserStatus) scheduled(next time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.info.NextRun = next
+}
+
+func (s *UserStatus) snapshot() StatusInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.info
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func newBot(cfg *Config, registry *Registry, ledger *Ledger, history *History, llm LLM, guardrails *Guardrails, secret SecretClient, clock Clock, runTest bool) *Bot {
+	slackClient := slack.New(cfg.Slack.Token)
+	slackClient.BaseURL = strings.TrimSuffix(cfg.Slack.APIURL, "/")
+
+	return &Bot{
+		cfg:         cfg,
+		registry:    registry,
+		ledger:      ledger,
+		history:     history,
+		llm:         llm,
+		guardrails:  guardrails,
+		secret:      secret,
+		slackClient: slackClient,
+		clock:       clock,
+		runTest:     runTest,
+		running:     make(map[string]*FitbitClient),
+		status:      make(map[string]*UserStatus),
+		notify:      make(map[string]chan string),
+	}
+}
+
+// StartAll starts the loop of every registered user. It only fails when
+// there are users but none of them could start, with each one's reason.
+func (b *Bot) StartAll() error {
+	users := b.registry.Users()
+	var errs []error
+	for _, u := range users {
+		if err := b.Start(u); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", u.ID, err))
+		}
+	}
+	if len(users) > 0 && len(errs) == len(users) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// Start starts a user's loop, unless it is already running, in which case
+// the running client just picks up the user's latest stored token. A loop
+// that returned, e.g. after a crash or access being revoked, is started
+// again. The error says why a loop couldn't start, it is logged already.
+func (b *Bot) Start(u *User) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if c, ok := b.running[u.ID]; ok {
+		if err := reloadToken(c); err != nil {
+			log.Printf("[%s] Error reloading Fitbit token: %v", u.ID, err)
+		}
+		return nil
+	}
+
+	c, err := b.registry.Client(u, b.secret)
+	if err != nil {
+		log.Printf("[%s] Error loading Fitbit token, skipping user: %v", u.ID, err)
+		return err
+	}
+
+	// warn about what the account didn't grant rather than hit 403s later
+	for _, f := range b.cfg.EnabledFeatures() {
+		if err := checkFeature(c, f); err != nil {
+			log.Printf("[%s] Feature disabled for this user, link the account again to grant it: %v", u.ID, err)
+			if f == FeatureSleep {
+				return err
+			}
+		}
+	}
+
+	status := &UserStatus{}
+	notify := make(chan string, 1)
+	stop := make(chan struct{})
+	b.running[u.ID] = c
+	b.status[u.ID] = status
+	b.notify[u.ID] = notify
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		// the digests stop with the report loop, the next Start runs both
+		defer close(stop)
+		defer b.stopped(u.ID)
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("[%s] Bot loop crashed: %v", u.ID, r)
+			}
+		}()
+
+		runUserBot(b.cfg, u, c, b.slackClient, b.ledger, b.history, b.llm, b.guardrails, status, notify, b.clock, b.runTest)
+	}()
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("[%s] Digest loop crashed: %v", u.ID, r)
+			}
+		}()
+
+		runUserDigests(b.cfg, u, c, b.slackClient, b.history, b.llm, b.guardrails, b.clock, stop)
+	}()
+
+	return nil
+}
+
+// stopped forgets the loop of a user once it returned, so that the status
+// page doesn't show it as running and Start runs it again.
+func (b *Bot) stopped(userID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.running, userID)
+	delete(b.status, userID)
+	delete(b.notify, userID)
+}
+
+// Notify tells a user's loop that Fitbit has new sleep for it on date, so
+// it doesn't have to wait for the next poll or the next window.
+func (b *Bot) Notify(userID, date string) {
+	b.mu.Lock()
+	notify, ok := b.notify[userID]
+	b.mu.Unlock()
+
+	if !ok {
+		log.Printf("[%s] Notification for a user that isn't running, ignoring it", userID)
+		return
+	}
+
+	// one pending notification is enough to wake the loop, a sync usually
+	// comes with several for the same date
+	select {
+	case notify <- date:
+	default:
+	}
+}
+
+// Wait blocks until every loop has returned, which is never in practice.
+func (b *Bot) Wait() {
+	b.wg.Wait()
+}
+
+// UserView is a user's row on the status page.
+type UserView struct {
+	User        *User
+	Status      StatusInfo
+	TokenExpiry time.Time
+	Running     bool
+}
+
+func (b *Bot) Snapshot() []UserView {
+	var views []UserView
+	for _, u := range b.registry.Users() {
+		view := UserView{User: u}
+
+		b.mu.Lock()
+		c, running := b.running[u.ID]
+		status := b.status[u.ID]
+		b.mu.Unlock()
+
+		if running {
+			view.Running = true
+			view.Status = status.snapshot()
+			c.mu.Lock()
+			view.TokenExpiry = c.ExpiresAt
+			c.mu.Unlock()
+		}
+
+		views = append(views, view)
+	}
+	return views
+}
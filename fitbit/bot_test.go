@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"fitbit-workflow/fakefitbit"
+)
+
+// testBot is a bot in test mode, reporting right away, against a Fitbit at
+// fitbitURL and the returned Slack.
+func testBot(t *testing.T, fitbitURL string, llm LLM) (*Bot, *fakeSlack) {
+	t.Helper()
+
+	slackAPI := &fakeSlack{}
+	slackSrv := httptest.NewServer(slackAPI)
+	t.Cleanup(slackSrv.Close)
+
+	cfg := testConfig(t, fitbitURL)
+	cfg.Slack.Token = "xoxb-test"
+	cfg.Slack.APIURL = slackSrv.URL
+	cfg.Slack.DefaultChannel = "C123"
+	cfg.Schedule.WeeklyDigest = ""
+	cfg.Schedule.MonthlyDigest = ""
+
+	registry, err := LoadRegistry(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ledger, err := openLedger(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err := openHistory(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { history.Close() })
+
+	return newBot(cfg, registry, ledger, history, llm, testGuardrails(t), *newSecretClient(cfg), realClock{}, true), slackAPI
+}
+
+// registerFakeUser links the account of fakefitbit/fixtures/tokens.json.
+func registerFakeUser(t *testing.T, b *Bot) *User {
+	t.Helper()
+
+	var token FitbitTokenResponse
+	if err := json.Unmarshal(fakefitbit.TokensJSON, &token); err != nil {
+		t.Fatal(err)
+	}
+	token.ExpiresAt = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+
+	u, err := b.registry.Register(&token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// waitFor fails the test if cond doesn't hold within a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func running(b *Bot, userID string) bool {
+	for _, view := range b.Snapshot() {
+		if view.User.ID == userID {
+			return view.Running
+		}
+	}
+	return false
+}
+
+func posted(slackAPI *fakeSlack) bool {
+	for _, method := range slackAPI.calls() {
+		if method == "chat.postMessage" {
+			return true
+		}
+	}
+	return false
+}
+
+// TestStartAfterCrash checks a loop that crashed shows as stopped and runs
+// again on the next Start.
+func TestStartAfterCrash(t *testing.T) {
+	_, srv := fakefitbit.NewServer()
+	defer srv.Close()
+
+	crashed := make(chan struct{})
+	llm := &FakeLLM{}
+	b, slackAPI := testBot(t, srv.URL, llmFunc(func(ctx context.Context, messages []AiMessage, format *ResponseFormat) (string, error) {
+		select {
+		case <-crashed:
+			return llm.Complete(ctx, messages, format)
+		default:
+			close(crashed)
+			panic("the model broke")
+		}
+	}))
+	u := registerFakeUser(t, b)
+
+	b.Start(u)
+	<-crashed
+	waitFor(t, "the crashed loop to stop", func() bool { return !running(b, u.ID) })
+	if posted(slackAPI) {
+		t.Fatal("the crashed loop posted a report")
+	}
+
+	// e.g. the user linking the account again
+	b.Start(u)
+	waitFor(t, "the report", func() bool { return posted(slackAPI) })
+	if !running(b, u.ID) {
+		t.Error("the restarted loop doesn't show as running")
+	}
+}
+
+// TestRelinkAfterRevoke checks the reports stop when the user takes sleep
+// access back and resume once the account is linked again.
+func TestRelinkAfterRevoke(t *testing.T) {
+	fake, srv := fakefitbit.NewServer()
+	defer srv.Close()
+
+	b, slackAPI := testBot(t, srv.URL, &FakeLLM{})
+	u := registerFakeUser(t, b)
+
+	app := httptest.NewServer(newServer(b.cfg, b.registry, b.secret, b).routes())
+	defer app.Close()
+
+	fake.RevokeScope("sleep")
+	b.Start(u)
+	waitFor(t, "the loop to stop", func() bool { return !running(b, u.ID) })
+	if posted(slackAPI) {
+		t.Fatal("posted a report without sleep access")
+	}
+
+	resp, err := http.Get(app.URL + "/callback?" + url.Values{"code": {"fake-code"}, "state": {loginState(t, app)}}.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("/callback answered %d, want 200", resp.StatusCode)
+	}
+
+	waitFor(t, "the report after linking again", func() bool { return posted(slackAPI) })
+	if !running(b, u.ID) {
+		t.Error("the loop doesn't show as running after linking again")
+	}
+}
+
+// TestStartAllCorrupted checks startup fails with the corrupted token
+// message when no user can start, and goes on when one can.
+func TestStartAllCorrupted(t *testing.T) {
+	_, srv := fakefitbit.NewServer()
+	defer srv.Close()
+
+	b, _ := testBot(t, srv.URL, &FakeLLM{})
+	u := registerFakeUser(t, b)
+	if err := os.WriteFile(b.registry.Store(u).Path, []byte(`{"access_tok`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := b.StartAll()
+	if err == nil || !strings.Contains(err.Error(), "is corrupted") {
+		t.Fatalf("StartAll() error = %v, want the corrupted token", err)
+	}
+	if running(b, u.ID) {
+		t.Error("the user with a corrupted token shows as running")
+	}
+
+	// another user that starts fine
+	other, err := b.registry.Register(&FitbitTokenResponse{AccessToken: "a", RefreshToken: "r", UserID: "OTHER", Scope: "sleep", ExpiresAt: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.StartAll(); err != nil {
+		t.Errorf("StartAll() with one good user, error = %v", err)
+	}
+	if !running(b, other.ID) {
+		t.Error("the good user isn't running")
+	}
+}
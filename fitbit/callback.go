@@ -1,14 +1,11 @@
 package main
 
 import (
-	"bytes"
-	"encoding/base64"
-	"encoding/json"
-	"fmt"
-	"io"
+	"html/template"
+	"log"
 	"net/http"
 	"net/url"
-	"os"
+	"time"
 )
 
 type FitbitTokenResponse struct {
@@ -17,26 +14,45 @@ type FitbitTokenResponse struct {
 	ExpiresIn    int64  `json:"expires_in"`
 	TokenType    string `json:"token_type"`
 	UserID       string `json:"user_id"`
+	Scope        string `json:"scope"` // space separated, as granted by the user
+
+	// ExpiresAt isn't sent by Fitbit, it's computed from ExpiresIn when the
+	// token is received so tokens.json knows when it goes stale.
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
 }
 
-func HandleFitbitCallback(w http.ResponseWriter, r *http.Request, c *SecretClient) {
+// HandleFitbitCallback finishes a linking attempt and registers the account
+// under its Fitbit user_id. It returns the linked user, or nil if linking
+// failed and an error page was written.
+func HandleFitbitCallback(w http.ResponseWriter, r *http.Request, c *FitbitClient, registry *Registry) *User {
 	code := r.URL.Query().Get("code")
 	if code == "" {
-		http.Error(w, "Missing code", http.StatusBadRequest)
-		return
+		writeErrorPage(w, http.StatusBadRequest, "The callback is missing its code, start again from /login.")
+		return nil
 	}
 
-	// Exchange the authorization code for an access token
-	err := exchangeCodeForToken(code, c)
+	// Exchange the authorization code for an access token. Fitbit's error
+	// only goes to the log, it's not for whoever is on the page.
+	token, err := exchangeCodeForToken(code, c)
 	if err != nil {
-		http.Error(w, "Failed to exchange code for token: "+err.Error(), http.StatusInternalServerError)
-		return
+		log.Println("Error exchanging code for token:", err)
+		writeErrorPage(w, http.StatusBadGateway, "Fitbit didn't accept the login, start again from /login.")
+		return nil
 	}
 
+	user, err := registry.Register(token)
+	if err != nil {
+		log.Println("Error saving token:", err)
+		writeErrorPage(w, http.StatusInternalServerError, "The account was authorized but couldn't be saved, check the logs.")
+		return nil
+	}
+
+	log.Printf("Linked Fitbit user %s, posting to channel %s", user.ID, user.Channel)
+
 	// return 200 + a html page saying success
 
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
-	w.Header().Set("Content-Type", "text/html")
 
 	var html = `
 	<!DOCTYPE html>
@@ -53,65 +69,46 @@ func HandleFitbitCallback(w http.ResponseWriter, r *http.Request, c *SecretClien
 	`
 
 	w.Write([]byte(html))
+
+	return user
+}
+
+var errorPageTemplate = template.Must(template.New("error").Parse(`
+	<!DOCTYPE html>
+	<html lang="en">
+	<head>
+	    <meta charset="UTF-8">
+	    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+	    <title>oops</title>
+	</head>
+	<body>
+	    <h1>setup failed &gt;_&lt;</h1>
+	    <p>{{.}}</p>
+	</body>
+	</html>
+	`))
+
+func writeErrorPage(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	errorPageTemplate.Execute(w, message)
 }
 
-func exchangeCodeForToken(code string, c *SecretClient) error {
+func exchangeCodeForToken(code string, c *FitbitClient) (*FitbitTokenResponse, error) {
 	data := url.Values{}
-	data.Set("client_id", c.ClientID)
+	data.Set("client_id", c.SecretClient.ClientID)
 	data.Set("code", code)
-	data.Set("code_verifier", c.CodeVerifier)
+	data.Set("code_verifier", c.SecretClient.CodeVerifier)
 	data.Set("grant_type", "authorization_code")
-	data.Set("redirect_uri", c.CallbackURL)
-	data.Set("callback_uri", c.CallbackURL)
+	data.Set("redirect_uri", c.SecretClient.CallbackURL)
+	data.Set("callback_uri", c.SecretClient.CallbackURL)
 
-	req, err := http.NewRequest("POST", "https://api.fitbit.com/oauth2/token", bytes.NewBufferString(data.Encode()))
+	tokenResp, err := postToken(c, data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// print the request body
-
-	fmt.Println("Request body:", data.Encode())
-
-	// Basic auth header
-	authString := c.ClientID + ":" + c.Secret
-	base64Auth := base64.StdEncoding.EncodeToString([]byte(authString))
-	req.Header.Set("Authorization", "Basic "+base64Auth)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("fitbit token endpoint returned %d: %s", resp.StatusCode, string(body))
-	}
-
-	var tokenResp FitbitTokenResponse
-	if err := json.Unmarshal(body, &tokenResp); err != nil {
-		return err
-	}
-
-	// store the token in a json file
-	tokenFile, err := json.MarshalIndent(tokenResp, "", "  ")
-	if err != nil {
-		return err
-	}
-
-	err = writeFile("tokens.json", tokenFile)
-	if err != nil {
-		return err
-	}
-
-	fmt.Println("Fitbit token saved to fitbit_token.json")
-
-	return nil
-}
+	tokenResp.ExpiresAt = time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
 
-func writeFile(filename string, data []byte) error {
-	return os.WriteFile(filename, data, 0600)
+	return tokenResp, nil
 }
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"fitbit-workflow/fakefitbit"
+)
+
+// linkServer is the account linking server of cfg, without the bot.
+func linkServer(t *testing.T, cfg *Config) (*Registry, *httptest.Server) {
+	t.Helper()
+
+	registry, err := LoadRegistry(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newServer(cfg, registry, *newSecretClient(cfg), nil)
+	return registry, httptest.NewServer(s.routes())
+}
+
+// noRedirects is a client that stops at the first redirect, since the
+// authorize URL is Fitbit's real one.
+func noRedirects() *http.Client {
+	return &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+}
+
+// loginState starts a linking attempt and returns its state.
+func loginState(t *testing.T, app *httptest.Server) string {
+	t.Helper()
+
+	resp, err := noRedirects().Get(app.URL + "/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("/login answered %d, want a redirect", resp.StatusCode)
+	}
+
+	authorize, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := authorize.Query().Get("state")
+	if state == "" || authorize.Query().Get("code_challenge") == "" {
+		t.Fatalf("authorize URL %s has no state or code challenge", authorize)
+	}
+	return state
+}
+
+func TestCallback(t *testing.T) {
+	fake, srv := fakefitbit.NewServer()
+	defer srv.Close()
+
+	// the user unticked everything but sleep
+	fake.SetGrantedScope("sleep")
+
+	cfg := testConfig(t, srv.URL)
+	registry, app := linkServer(t, cfg)
+	defer app.Close()
+
+	state := loginState(t, app)
+	resp, err := http.Get(app.URL + "/callback?" + url.Values{"code": {"fake-code"}, "state": {state}}.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("/callback answered %d, want 200", resp.StatusCode)
+	}
+
+	u, ok := registry.Get(fakefitbit.UserID)
+	if !ok {
+		t.Fatalf("user %s isn't registered", fakefitbit.UserID)
+	}
+	if u.Channel != cfg.Slack.DefaultChannel || u.GoalHours != cfg.Report.GoalHours {
+		t.Errorf("user = %+v, want the configured defaults", u)
+	}
+
+	c, err := registry.Client(u, *newSecretClient(cfg))
+	if err != nil {
+		t.Fatalf("loading the saved token: %v", err)
+	}
+	if c.AccessToken == "" || c.RefreshToken == "" || c.ExpiresAt.IsZero() {
+		t.Errorf("saved token = %s/%s expiring %s, want a full token", c.AccessToken, c.RefreshToken, c.ExpiresAt)
+	}
+	if err := checkFeature(c, FeatureSleep); err != nil {
+		t.Errorf("checkFeature(sleep) = %v, want nil", err)
+	}
+	if err := checkFeature(c, FeatureHeartRate); !errors.Is(err, ErrScopeNotGranted) {
+		t.Errorf("checkFeature(heartrate) = %v, want ErrScopeNotGranted", err)
+	}
+
+	// a state is only good once
+	resp, err = http.Get(app.URL + "/callback?" + url.Values{"code": {"fake-code"}, "state": {state}}.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("/callback with a used state answered %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestCallbackRejected(t *testing.T) {
+	_, srv := fakefitbit.NewServer()
+	defer srv.Close()
+
+	cfg := testConfig(t, srv.URL)
+	registry, app := linkServer(t, cfg)
+	defer app.Close()
+
+	tests := []struct {
+		name  string
+		query func(state string) url.Values
+		want  int
+	}{
+		{
+			name:  "declined",
+			query: func(state string) url.Values { return url.Values{"error": {"access_denied"}, "state": {state}} },
+			want:  http.StatusBadRequest,
+		},
+		{
+			name:  "unknown state",
+			query: func(string) url.Values { return url.Values{"code": {"fake-code"}, "state": {"nope"}} },
+			want:  http.StatusBadRequest,
+		},
+		{
+			name:  "no code",
+			query: func(state string) url.Values { return url.Values{"state": {state}} },
+			want:  http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Get(app.URL + "/callback?" + tt.query(loginState(t, app)).Encode())
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("/callback answered %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+
+	if users := registry.Users(); len(users) > 0 {
+		t.Errorf("registered %d users, want none", len(users))
+	}
+}
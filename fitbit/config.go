@@ -0,0 +1,559 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/espcaa/random-workflows-that-actually-are-bots/slack"
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is everything the fitbit bot can be tuned with. It is read from a
+// YAML file (config.yaml, or the file in FITBIT_CONFIG) and then overridden
+// by environment variables, so existing .env setups keep working.
+type Config struct {
+	Fitbit   FitbitConfig   `yaml:"fitbit"`
+	Slack    SlackConfig    `yaml:"slack"`
+	AI       AIConfig       `yaml:"ai"`
+	Schedule ScheduleConfig `yaml:"schedule"`
+	Report   ReportConfig   `yaml:"report"`
+	Storage  StorageConfig  `yaml:"storage"`
+	Server   ServerConfig   `yaml:"server"`
+}
+
+type FitbitConfig struct {
+	ClientID     string `yaml:"client_id"`     // FITBIT_CLIENT_ID
+	ClientSecret string `yaml:"client_secret"` // FITBIT_CLIENT_SECRET
+	CallbackURL  string `yaml:"callback_url"`  // FITBIT_CALLBACK_URL
+	APIURL       string `yaml:"api_url"`       // FITBIT_API_URL
+
+	// SubscriberID and VerificationCode are those of the subscriber
+	// endpoint (/webhook) in the Fitbit dev console. Without a verification
+	// code the webhook stays off and the bot only polls.
+	SubscriberID     string `yaml:"subscriber_id"`     // FITBIT_SUBSCRIBER_ID
+	VerificationCode string `yaml:"verification_code"` // FITBIT_VERIFICATION_CODE
+
+	// Features are the optional report parts to enable, sleep is always on.
+	Features []Feature `yaml:"features"` // FITBIT_FEATURES, comma separated
+}
+
+type SlackConfig struct {
+	Token          string `yaml:"token"`           // SLACK_BOT_TOKEN
+	APIURL         string `yaml:"api_url"`         // SLACK_API_URL
+	DefaultChannel string `yaml:"default_channel"` // SLACK_CHANNEL_ID, for newly linked users
+}
+
+type AIConfig struct {
+	// Provider is the kind of API: openai for any OpenAI compatible chat
+	// completions endpoint, anthropic for a messages API, ollama, or fake
+	// for a canned reply without any network.
+	Provider string `yaml:"provider"` // AI_PROVIDER
+	// BaseURL is the full endpoint URL, the provider's usual one if empty.
+	BaseURL   string        `yaml:"base_url"`   // AI_BASE_URL
+	Model     string        `yaml:"model"`      // AI_MODEL
+	APIKey    string        `yaml:"api_key"`    // AI_API_KEY
+	Timeout   time.Duration `yaml:"timeout"`    // AI_TIMEOUT, per attempt
+	MaxTokens int           `yaml:"max_tokens"` // AI_MAX_TOKENS, the messages API requires one
+
+	// ResponseFormat is how the roast is asked for: json_schema, json_object
+	// for APIs without schemas like DeepSeek's, or text. A reply that isn't
+	// the JSON asked for is used as text.
+	ResponseFormat string `yaml:"response_format"` // AI_RESPONSE_FORMAT
+
+	// Retries is how many more times a backend is tried after a 429 or a
+	// 5xx, waiting RetryBackoff, then twice as long, and so on.
+	Retries      int           `yaml:"retries"`       // AI_RETRIES
+	RetryBackoff time.Duration `yaml:"retry_backoff"` // AI_RETRY_BACKOFF
+
+	// Fallbacks are tried in order once the main backend gave up. When
+	// they all fail the report gets a canned roast instead.
+	Fallbacks []AIBackend `yaml:"fallbacks"`
+}
+
+// AIBackend is a fallback model. Empty fields are those of the main one when
+// the provider is the same, so a bare model is another model on the same API.
+type AIBackend struct {
+	Provider string `yaml:"provider"`
+	BaseURL  string `yaml:"base_url"`
+	Model    string `yaml:"model"`
+	APIKey   string `yaml:"api_key"`
+}
+
+// backends returns the main backend and then the fallbacks, with the gaps
+// filled in.
+func (c AIConfig) backends() []AIBackend {
+	main := AIBackend{Provider: c.Provider, BaseURL: c.BaseURL, Model: c.Model, APIKey: c.APIKey}
+	backends := []AIBackend{main}
+	for _, b := range c.Fallbacks {
+		if b.Provider == "" {
+			b.Provider = main.Provider
+		}
+		// the URL, key and model of another provider would be no use
+		if b.Provider == main.Provider {
+			if b.BaseURL == "" {
+				b.BaseURL = main.BaseURL
+			}
+			if b.APIKey == "" {
+				b.APIKey = main.APIKey
+			}
+			if b.Model == "" {
+				b.Model = main.Model
+			}
+		}
+		backends = append(backends, b)
+	}
+	return backends
+}
+
+type ScheduleConfig struct {
+	// Cron is when the bot starts looking for last night's sleep, in each
+	// user's timezone.
+	Cron string `yaml:"cron"` // REPORT_CRON
+	// Window is how long after that it keeps polling before giving up on
+	// the day.
+	Window time.Duration `yaml:"window"` // REPORT_WINDOW
+	// PollInterval is the wait between polls while Fitbit has no sleep yet.
+	PollInterval time.Duration `yaml:"poll_interval"` // POLL_INTERVAL
+	// BackoffMin and BackoffMax bound the wait after an error, which
+	// doubles with each failure in a row.
+	BackoffMin time.Duration `yaml:"backoff_min"` // BACKOFF_MIN
+	BackoffMax time.Duration `yaml:"backoff_max"` // BACKOFF_MAX
+
+	// WeeklyDigest and MonthlyDigest are when the digests of the week and
+	// of the month before go out, empty to not send them.
+	WeeklyDigest  string `yaml:"weekly_digest"`  // WEEKLY_DIGEST_CRON
+	MonthlyDigest string `yaml:"monthly_digest"` // MONTHLY_DIGEST_CRON
+}
+
+type ReportConfig struct {
+	GoalHours   float64 `yaml:"goal_hours"`   // GOAL_HOURS, default for new users
+	HistoryDays int     `yaml:"history_days"` // HISTORY_DAYS, nights of context for the AI
+	MetricsDays int     `yaml:"metrics_days"` // METRICS_DAYS, nights behind the sleep debt and consistency
+	DigestAI    bool    `yaml:"digest_ai"`    // DIGEST_AI, add an AI summary to the digests
+
+	// Persona roasts the nights of users without one of their own, unless
+	// PersonaDays has one for the weekday. Either can be "random" for a
+	// different persona every day.
+	Persona     string            `yaml:"persona"`      // PERSONA
+	PersonaDays map[string]string `yaml:"persona_days"` // monday, tuesday... to a persona
+}
+
+type StorageConfig struct {
+	UsersFile      string `yaml:"users_file"`      // FITBIT_USERS_FILE
+	TokensDir      string `yaml:"tokens_dir"`      // FITBIT_TOKENS_DIR
+	TokensFile     string `yaml:"tokens_file"`     // FITBIT_TOKENS_FILE, the single-user file from before users.json
+	LedgerFile     string `yaml:"ledger_file"`     // FITBIT_LEDGER_FILE, which reports were already posted
+	HistoryFile    string `yaml:"history_file"`    // FITBIT_HISTORY_FILE, the SQLite copy of every night
+	PersonasDir    string `yaml:"personas_dir"`    // PERSONAS_DIR, the built-in personas if empty
+	GuardrailsFile string `yaml:"guardrails_file"` // GUARDRAILS_FILE, the built-in guardrails.yaml if empty
+}
+
+type ServerConfig struct {
+	Port string `yaml:"port"` // PORT
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Fitbit: FitbitConfig{
+			CallbackURL: "https://fitbit.hackclub.cc/callback",
+			APIURL:      defaultFitbitAPIURL,
+		},
+		Slack: SlackConfig{
+			APIURL: slack.DefaultBaseURL,
+		},
+		AI: AIConfig{
+			Provider:  "openai",
+			Model:     "deepseek-v4-flash",
+			Timeout:   time.Minute,
+			MaxTokens: 1024,
+			// DeepSeek doesn't take a schema
+			ResponseFormat: "json_object",
+			Retries:        2,
+			RetryBackoff:   2 * time.Second,
+		},
+		Schedule: ScheduleConfig{
+			Cron: "0 5 * * *",
+			// the bot used to keep polling while the hour was <= 22
+			Window:       18 * time.Hour,
+			PollInterval: time.Hour,
+			BackoffMin:   time.Minute,
+			BackoffMax:   time.Hour,
+			// Monday morning, the monthly one is opt-in
+			WeeklyDigest: "0 9 * * 1",
+		},
+		Report: ReportConfig{
+			GoalHours:   8.0,
+			HistoryDays: 5,
+			MetricsDays: 14,
+			DigestAI:    true,
+			Persona:     defaultPersona,
+		},
+		Storage: StorageConfig{
+			UsersFile:   "users.json",
+			TokensDir:   "tokens",
+			TokensFile:  "tokens.json",
+			LedgerFile:  "sent_reports.json",
+			HistoryFile: "history.db",
+		},
+		Server: ServerConfig{
+			Port: "8080",
+		},
+	}
+}
+
+// configPath is FITBIT_CONFIG, or config.yaml in the working directory.
+// Only an explicitly set file has to exist.
+func configPath() (string, bool) {
+	if path := os.Getenv("FITBIT_CONFIG"); path != "" {
+		return path, true
+	}
+	return "config.yaml", false
+}
+
+// LoadConfig reads the config file if there is one and applies the
+// environment on top of it. It doesn't validate, see Validate.
+func LoadConfig() (*Config, error) {
+	cfg := defaultConfig()
+
+	path, required := configPath()
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist) && !required:
+		// env and defaults only
+	case err != nil:
+		return nil, err
+	default:
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (c *Config) applyEnv() error {
+	setString := func(name string, dst *string) {
+		if v := os.Getenv(name); v != "" {
+			*dst = v
+		}
+	}
+
+	setString("FITBIT_CLIENT_ID", &c.Fitbit.ClientID)
+	setString("FITBIT_CLIENT_SECRET", &c.Fitbit.ClientSecret)
+	setString("FITBIT_CALLBACK_URL", &c.Fitbit.CallbackURL)
+	setString("FITBIT_API_URL", &c.Fitbit.APIURL)
+	setString("FITBIT_SUBSCRIBER_ID", &c.Fitbit.SubscriberID)
+	setString("FITBIT_VERIFICATION_CODE", &c.Fitbit.VerificationCode)
+	setString("SLACK_BOT_TOKEN", &c.Slack.Token)
+	setString("SLACK_API_URL", &c.Slack.APIURL)
+	setString("SLACK_CHANNEL_ID", &c.Slack.DefaultChannel)
+	setString("AI_PROVIDER", &c.AI.Provider)
+	setString("AI_BASE_URL", &c.AI.BaseURL)
+	setString("AI_MODEL", &c.AI.Model)
+	setString("AI_API_KEY", &c.AI.APIKey)
+	setString("AI_RESPONSE_FORMAT", &c.AI.ResponseFormat)
+	setString("REPORT_CRON", &c.Schedule.Cron)
+	setString("WEEKLY_DIGEST_CRON", &c.Schedule.WeeklyDigest)
+	setString("MONTHLY_DIGEST_CRON", &c.Schedule.MonthlyDigest)
+	setString("FITBIT_USERS_FILE", &c.Storage.UsersFile)
+	setString("FITBIT_TOKENS_DIR", &c.Storage.TokensDir)
+	setString("FITBIT_TOKENS_FILE", &c.Storage.TokensFile)
+	setString("FITBIT_LEDGER_FILE", &c.Storage.LedgerFile)
+	setString("FITBIT_HISTORY_FILE", &c.Storage.HistoryFile)
+	setString("PERSONAS_DIR", &c.Storage.PersonasDir)
+	setString("GUARDRAILS_FILE", &c.Storage.GuardrailsFile)
+	setString("PERSONA", &c.Report.Persona)
+	setString("PORT", &c.Server.Port)
+
+	if v := os.Getenv("FITBIT_FEATURES"); v != "" {
+		c.Fitbit.Features = nil
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				c.Fitbit.Features = append(c.Fitbit.Features, Feature(name))
+			}
+		}
+	}
+
+	var errs []error
+	setDuration := func(name string, dst *time.Duration) {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			*dst = d
+			errs = append(errs, envError(name, err))
+		}
+	}
+	setDuration("REPORT_WINDOW", &c.Schedule.Window)
+	setDuration("POLL_INTERVAL", &c.Schedule.PollInterval)
+	setDuration("BACKOFF_MIN", &c.Schedule.BackoffMin)
+	setDuration("BACKOFF_MAX", &c.Schedule.BackoffMax)
+	setDuration("AI_TIMEOUT", &c.AI.Timeout)
+	setDuration("AI_RETRY_BACKOFF", &c.AI.RetryBackoff)
+	if v := os.Getenv("AI_RETRIES"); v != "" {
+		n, err := strconv.Atoi(v)
+		c.AI.Retries = n
+		errs = append(errs, envError("AI_RETRIES", err))
+	}
+	if v := os.Getenv("AI_MAX_TOKENS"); v != "" {
+		n, err := strconv.Atoi(v)
+		c.AI.MaxTokens = n
+		errs = append(errs, envError("AI_MAX_TOKENS", err))
+	}
+	if v := os.Getenv("GOAL_HOURS"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		c.Report.GoalHours = f
+		errs = append(errs, envError("GOAL_HOURS", err))
+	}
+	if v := os.Getenv("HISTORY_DAYS"); v != "" {
+		n, err := strconv.Atoi(v)
+		c.Report.HistoryDays = n
+		errs = append(errs, envError("HISTORY_DAYS", err))
+	}
+	if v := os.Getenv("METRICS_DAYS"); v != "" {
+		n, err := strconv.Atoi(v)
+		c.Report.MetricsDays = n
+		errs = append(errs, envError("METRICS_DAYS", err))
+	}
+	if v := os.Getenv("DIGEST_AI"); v != "" {
+		b, err := strconv.ParseBool(v)
+		c.Report.DigestAI = b
+		errs = append(errs, envError("DIGEST_AI", err))
+	}
+
+	return errors.Join(errs...)
+}
+
+func envError(name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("$%s: %w", name, err)
+}
+
+// Validate reports every problem with the config at once.
+func (c *Config) Validate() error {
+	var errs []error
+	problem := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.Fitbit.ClientID == "" {
+		problem("fitbit.client_id is required")
+	}
+	if c.Fitbit.ClientSecret == "" {
+		problem("fitbit.client_secret is required")
+	}
+	if u, err := url.Parse(c.Fitbit.CallbackURL); err != nil || u.Scheme == "" || u.Host == "" {
+		problem("fitbit.callback_url must be an absolute URL, got %q", c.Fitbit.CallbackURL)
+	}
+	if u, err := url.Parse(c.Fitbit.APIURL); err != nil || u.Scheme == "" || u.Host == "" {
+		problem("fitbit.api_url must be an absolute URL, got %q", c.Fitbit.APIURL)
+	}
+	for _, f := range c.Fitbit.Features {
+		if _, ok := featureScopes[f]; !ok {
+			problem("fitbit.features: unknown feature %q", f)
+		}
+	}
+
+	for i, b := range c.AI.backends() {
+		prefix := "ai"
+		if i > 0 {
+			prefix = fmt.Sprintf("ai.fallbacks[%d]", i-1)
+		}
+		if _, ok := defaultLLMURLs[b.Provider]; !ok {
+			problem("%s.provider: unknown provider %q", prefix, b.Provider)
+		}
+		if b.Model == "" && b.Provider != "fake" {
+			problem("%s.model is required", prefix)
+		}
+		if b.BaseURL != "" {
+			if u, err := url.Parse(b.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+				problem("%s.base_url must be an absolute URL, got %q", prefix, b.BaseURL)
+			}
+		}
+	}
+	if c.AI.Timeout <= 0 {
+		problem("ai.timeout must be positive, got %s", c.AI.Timeout)
+	}
+	if c.AI.MaxTokens <= 0 {
+		problem("ai.max_tokens must be positive, got %d", c.AI.MaxTokens)
+	}
+	switch c.AI.ResponseFormat {
+	case "json_schema", "json_object", "text":
+	default:
+		problem("ai.response_format must be json_schema, json_object or text, got %q", c.AI.ResponseFormat)
+	}
+	if c.AI.Retries < 0 {
+		problem("ai.retries can't be negative, got %d", c.AI.Retries)
+	}
+	if c.AI.Retries > 0 && c.AI.RetryBackoff <= 0 {
+		problem("ai.retry_backoff must be positive, got %s", c.AI.RetryBackoff)
+	}
+
+	if _, err := cron.ParseStandard(c.Schedule.Cron); err != nil {
+		problem("schedule.cron: %v", err)
+	}
+	if c.Schedule.WeeklyDigest != "" {
+		if _, err := cron.ParseStandard(c.Schedule.WeeklyDigest); err != nil {
+			problem("schedule.weekly_digest: %v", err)
+		}
+	}
+	if c.Schedule.MonthlyDigest != "" {
+		if _, err := cron.ParseStandard(c.Schedule.MonthlyDigest); err != nil {
+			problem("schedule.monthly_digest: %v", err)
+		}
+	}
+	if c.Schedule.Window <= 0 || c.Schedule.Window > 24*time.Hour {
+		problem("schedule.window must be between 0 and 24h, got %s", c.Schedule.Window)
+	}
+	if c.Schedule.PollInterval < time.Minute {
+		problem("schedule.poll_interval must be at least 1m, got %s", c.Schedule.PollInterval)
+	}
+	if c.Schedule.BackoffMin < time.Second || c.Schedule.BackoffMax < c.Schedule.BackoffMin {
+		problem("schedule.backoff_min must be at least 1s and no more than backoff_max, got %s and %s", c.Schedule.BackoffMin, c.Schedule.BackoffMax)
+	}
+
+	if c.Report.GoalHours <= 0 || c.Report.GoalHours > 24 {
+		problem("report.goal_hours must be between 0 and 24, got %v", c.Report.GoalHours)
+	}
+	// the sleep range endpoint is limited to 100 days
+	if c.Report.HistoryDays < 1 || c.Report.HistoryDays > 100 {
+		problem("report.history_days must be between 1 and 100, got %d", c.Report.HistoryDays)
+	}
+	if c.Report.MetricsDays < 1 || c.Report.MetricsDays > 100 {
+		problem("report.metrics_days must be between 1 and 100, got %d", c.Report.MetricsDays)
+	}
+	if personas, err := loadPersonas(c); err != nil {
+		problem("storage.personas_dir: %v", err)
+	} else {
+		known := func(id string) bool {
+			_, ok := findPersona(personas, id)
+			return ok || id == randomPersona
+		}
+		if !known(c.Report.Persona) {
+			problem("report.persona: unknown persona %q", c.Report.Persona)
+		}
+		for day := range c.Report.PersonaDays {
+			if !slices.Contains(personaWeekdays, day) {
+				problem("report.persona_days: %q isn't a weekday, use monday, tuesday...", day)
+			}
+		}
+		for _, day := range personaWeekdays {
+			if id, ok := c.Report.PersonaDays[day]; ok && !known(id) {
+				problem("report.persona_days.%s: unknown persona %q", day, id)
+			}
+		}
+	}
+
+	if _, err := loadGuardrails(c); err != nil {
+		problem("storage.guardrails_file: %v", err)
+	}
+
+	if c.Storage.UsersFile == "" || c.Storage.TokensDir == "" || c.Storage.LedgerFile == "" || c.Storage.HistoryFile == "" {
+		problem("storage.users_file, storage.tokens_dir, storage.ledger_file and storage.history_file are required")
+	}
+	if c.Server.Port == "" {
+		problem("server.port is required")
+	}
+
+	return errors.Join(errs...)
+}
+
+// EnabledFeatures is sleep plus the configured features.
+func (c *Config) EnabledFeatures() []Feature {
+	features := []Feature{FeatureSleep}
+	for _, f := range c.Fitbit.Features {
+		if f != FeatureSleep {
+			features = append(features, f)
+		}
+	}
+	return features
+}
+
+const redacted = "[redacted]"
+
+// Redacted returns a copy of the config that is safe to print.
+func (c *Config) Redacted() *Config {
+	out := *c
+	out.Fitbit.Features = append([]Feature(nil), c.Fitbit.Features...)
+	if out.Fitbit.ClientSecret != "" {
+		out.Fitbit.ClientSecret = redacted
+	}
+	if out.Fitbit.VerificationCode != "" {
+		out.Fitbit.VerificationCode = redacted
+	}
+	if out.Slack.Token != "" {
+		out.Slack.Token = redacted
+	}
+	if out.AI.APIKey != "" {
+		out.AI.APIKey = redacted
+	}
+	out.AI.Fallbacks = append([]AIBackend(nil), c.AI.Fallbacks...)
+	for i := range out.AI.Fallbacks {
+		if out.AI.Fallbacks[i].APIKey != "" {
+			out.AI.Fallbacks[i].APIKey = redacted
+		}
+	}
+	return &out
+}
+
+// loadConfigOrDie loads and validates the config, exiting with every
+// problem listed if it isn't usable.
+func loadConfigOrDie() *Config {
+	cfg, err := LoadConfig()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error loading config:", err)
+		os.Exit(1)
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid config:")
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	return cfg
+}
+
+// configCommand implements `config check`.
+func configCommand(args []string) error {
+	if len(args) == 0 || args[0] != "check" {
+		return errors.New("usage: config check")
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+
+	path, _ := configPath()
+	if _, err := os.Stat(path); err == nil {
+		fmt.Println("# loaded from", path, "plus environment overrides")
+	} else {
+		fmt.Println("# no config file, defaults plus environment overrides")
+	}
+
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(cfg.Redacted()); err != nil {
+		return err
+	}
+	encoder.Close()
+
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("config is invalid:\n%w", err)
+	}
+	fmt.Println("# config is valid")
+	return nil
+}
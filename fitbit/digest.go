@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"fitbit-workflow/metrics"
+
+	"github.com/espcaa/random-workflows-that-actually-are-bots/slack"
+	"github.com/robfig/cron/v3"
+)
+
+//go:embed digest.tmpl
+var digestTemplateString string
+
+//go:embed digest_prompt.txt
+var digestPromptString string
+
+const (
+	WeeklyDigest  = "weekly"
+	MonthlyDigest = "monthly"
+)
+
+// Digest sums up the nights of a week or a month.
+type Digest struct {
+	Period string // WeeklyDigest or MonthlyDigest
+	From   string
+	To     string
+	Days   int
+	Nights int // days with sleep
+
+	AvgHours         float64 // every session, like the daily bar
+	AvgMinutesAsleep float64
+	AvgEfficiency    float64 // of the main sleep
+
+	// Bedtime and WakeTime are the average start and end of the main sleep,
+	// the spreads their standard deviation in minutes.
+	Bedtime        string
+	BedtimeSpread  float64
+	WakeTime       string
+	WakeTimeSpread float64
+
+	Best  DigestNight
+	Worst DigestNight
+
+	// Stages are the average minutes, over the nights tracked with stages.
+	Stages       SleepStages
+	StagesNights int
+
+	GoalHours float64
+	GoalHits  int
+}
+
+type DigestNight struct {
+	Date       string
+	Hours      float64
+	Efficiency int
+}
+
+// digestRange is the dates a digest sent at covers: the 7 days before for
+// the weekly one, the calendar month before for the monthly one.
+func digestRange(period string, at time.Time) (from, to time.Time) {
+	day := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)
+	if period == MonthlyDigest {
+		from = time.Date(at.Year(), at.Month()-1, 1, 0, 0, 0, 0, time.UTC)
+		return from, from.AddDate(0, 1, -1)
+	}
+	return day.AddDate(0, 0, -7), day.AddDate(0, 0, -1)
+}
+
+// makeDigest sums up nights between from and to. It returns nil if there
+// is no night to speak of.
+func makeDigest(period string, from, to time.Time, nights map[string]*Night, goalHours float64) *Digest {
+	var dates []string
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		if nights[d.Format(dateLayout)] != nil {
+			dates = append(dates, d.Format(dateLayout))
+		}
+	}
+	if len(dates) == 0 {
+		return nil
+	}
+
+	digest := &Digest{
+		Period:    period,
+		From:      from.Format(dateLayout),
+		To:        to.Format(dateLayout),
+		Days:      int(to.Sub(from).Hours()/24) + 1,
+		Nights:    len(dates),
+		GoalHours: goalHours,
+	}
+
+	var hours, asleep, efficiency []float64
+	var stages SleepStages
+	var tracked []metrics.Night
+	for _, date := range dates {
+		night := nights[date]
+		mainSleep := night.Main
+
+		hours = append(hours, night.TotalHours())
+		asleep = append(asleep, float64(night.MinutesAsleep))
+		efficiency = append(efficiency, float64(mainSleep.Efficiency))
+		tracked = append(tracked, night.Metrics())
+
+		if night.TotalHours() >= goalHours {
+			digest.GoalHits++
+		}
+
+		if mainSleep.Type == "stages" {
+			stages.Deep += mainSleep.Levels.Summary.Deep.Minutes
+			stages.Light += mainSleep.Levels.Summary.Light.Minutes
+			stages.Rem += mainSleep.Levels.Summary.Rem.Minutes
+			stages.Wake += mainSleep.Levels.Summary.Wake.Minutes
+			digest.StagesNights++
+		}
+
+		n := DigestNight{Date: date, Hours: night.TotalHours(), Efficiency: mainSleep.Efficiency}
+		if digest.Best.Date == "" || n.Hours > digest.Best.Hours {
+			digest.Best = n
+		}
+		if digest.Worst.Date == "" || n.Hours < digest.Worst.Hours {
+			digest.Worst = n
+		}
+	}
+
+	digest.AvgHours = mean(hours)
+	digest.AvgMinutesAsleep = mean(asleep)
+	digest.AvgEfficiency = mean(efficiency)
+	bedtime, wakeTime := metrics.Bedtimes(tracked), metrics.WakeTimes(tracked)
+	digest.Bedtime = bedtime.Clock()
+	digest.BedtimeSpread = bedtime.StdDev.Minutes()
+	digest.WakeTime = wakeTime.Clock()
+	digest.WakeTimeSpread = wakeTime.StdDev.Minutes()
+
+	if digest.StagesNights > 0 {
+		digest.Stages = SleepStages{
+			Deep:  stages.Deep / digest.StagesNights,
+			Light: stages.Light / digest.StagesNights,
+			Rem:   stages.Rem / digest.StagesNights,
+			Wake:  stages.Wake / digest.StagesNights,
+		}
+	}
+
+	return digest
+}
+
+// Summary is the digest as posted to Slack.
+func (d *Digest) Summary() string {
+	from, _ := time.Parse(dateLayout, d.From)
+	to, _ := time.Parse(dateLayout, d.To)
+
+	title := "Weekly"
+	if d.Period == MonthlyDigest {
+		title = "Monthly"
+	}
+
+	text := fmt.Sprintf("*%s sleep digest* (%s -> %s, %d of %d nights tracked)", title, from.Format("Jan 2"), to.Format("Jan 2"), d.Nights, d.Days)
+	text += fmt.Sprintf("\nAverage: %.1f hours a night, %.0f%% efficiency", d.AvgHours, d.AvgEfficiency)
+	text += fmt.Sprintf("\nGoal of %.1fh hit on %d of %d nights", d.GoalHours, d.GoalHits, d.Nights)
+	text += fmt.Sprintf("\nBedtime %s ± %.0f min, wake-up %s ± %.0f min", d.Bedtime, d.BedtimeSpread, d.WakeTime, d.WakeTimeSpread)
+	text += fmt.Sprintf("\nBest night %s (%.1fh), worst night %s (%.1fh)", digestDay(d.Best.Date), d.Best.Hours, digestDay(d.Worst.Date), d.Worst.Hours)
+	if d.StagesNights > 0 {
+		text += fmt.Sprintf("\nStages: %d min deep, %d light, %d REM, %d awake", d.Stages.Deep, d.Stages.Light, d.Stages.Rem, d.Stages.Wake)
+	}
+	return text
+}
+
+func digestDay(date string) string {
+	t, _ := time.Parse(dateLayout, date)
+	return t.Format("Mon Jan 2")
+}
+
+func FormatDigest(d Digest) (string, error) {
+	tmpl, err := template.New("digest").Parse(digestTemplateString)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, d); err != nil {
+		return "", fmt.Errorf("failed to execute template: %w", err)
+	}
+
+	return buf.String(), nil
+}
+
+// GetDigestPrompt is the part of the digest prompt every persona shares.
+func GetDigestPrompt() string {
+	return digestPromptString
+}
+
+// digestSchedules are the digests turned on in the config.
+func digestSchedules(cfg *Config) (map[string]cron.Schedule, error) {
+	schedules := make(map[string]cron.Schedule)
+	for period, spec := range map[string]string{
+		WeeklyDigest:  cfg.Schedule.WeeklyDigest,
+		MonthlyDigest: cfg.Schedule.MonthlyDigest,
+	} {
+		if spec == "" {
+			continue
+		}
+		schedule, err := cron.ParseStandard(spec)
+		if err != nil {
+			return nil, fmt.Errorf("%s digest: %w", period, err)
+		}
+		schedules[period] = schedule
+	}
+	return schedules, nil
+}
+
+// digestCommand posts the weekly or monthly digest of every user now.
+func digestCommand(cfg *Config, args []string) error {
+	if len(args) != 1 || (args[0] != WeeklyDigest && args[0] != MonthlyDigest) {
+		return errors.New("usage: digest weekly|monthly")
+	}
+	period := args[0]
+
+	registry, err := openRegistry(cfg)
+	if err != nil {
+		return err
+	}
+
+	history, err := openHistory(cfg)
+	if err != nil {
+		return err
+	}
+	defer history.Close()
+
+	llm, err := newLLM(cfg)
+	if err != nil {
+		return err
+	}
+	guardrails, err := loadGuardrails(cfg)
+	if err != nil {
+		return err
+	}
+
+	slackClient := slack.New(cfg.Slack.Token)
+	slackClient.BaseURL = strings.TrimSuffix(cfg.Slack.APIURL, "/")
+
+	secret := *newSecretClient(cfg)
+
+	var errs []error
+	for _, u := range registry.Users() {
+		client, err := registry.Client(u, secret)
+		if err == nil {
+			logger := log.New(log.Writer(), "["+u.ID+"] ", log.Flags())
+			err = sendDigest(cfg, u, client, slackClient, history, llm, guardrails, logger, period, time.Now().In(u.Location()))
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", u.ID, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// runUserDigests posts a user's digests as they come due, in the user's
+// timezone, until stop is closed. Missed ones, e.g. while the bot was down,
+// are skipped.
+func runUserDigests(cfg *Config, u *User, c *FitbitClient, slackClient *slack.Client, history *History, llm LLM, guardrails *Guardrails, clock Clock, stop <-chan struct{}) {
+	logger := log.New(log.Writer(), "["+u.ID+"] ", log.Flags())
+
+	schedules, err := digestSchedules(cfg)
+	if err != nil {
+		logger.Println("Error parsing the digest schedule:", err)
+		return
+	}
+	if len(schedules) == 0 {
+		return
+	}
+
+	loc := u.Location()
+	next := make(map[string]time.Time)
+	for period, schedule := range schedules {
+		next[period] = schedule.Next(clock.Now().In(loc))
+	}
+
+	for {
+		// the digest due first, the weekly one if both are due together
+		var periods []string
+		for period := range next {
+			periods = append(periods, period)
+		}
+		sort.Slice(periods, func(i, j int) bool {
+			if next[periods[i]].Equal(next[periods[j]]) {
+				return periods[i] > periods[j]
+			}
+			return next[periods[i]].Before(next[periods[j]])
+		})
+		period := periods[0]
+		at := next[period]
+
+		logger.Println("Next", period, "digest at", at)
+		if wait := at.Sub(clock.Now()); wait > 0 {
+			select {
+			case <-clock.After(wait):
+			case <-stop:
+				return
+			}
+		}
+
+		if err := sendDigest(cfg, u, c, slackClient, history, llm, guardrails, logger, period, at); err != nil {
+			logger.Printf("Error sending the %s digest: %v", period, err)
+		}
+		next[period] = schedules[period].Next(at)
+	}
+}
+
+// sendDigest posts the digest of period as of at, if there was any sleep.
+func sendDigest(cfg *Config, u *User, c *FitbitClient, slackClient *slack.Client, history *History, llm LLM, guardrails *Guardrails, logger *log.Logger, period string, at time.Time) error {
+	from, to := digestRange(period, at)
+
+	resp, err := loadSleepHistory(history, c, u.ID, from.Format(dateLayout), to.Format(dateLayout))
+	if err != nil {
+		return fmt.Errorf("getting sleep history: %w", err)
+	}
+
+	digest := makeDigest(period, from, to, nightsByDate(resp), c.GoalHours)
+	if digest == nil {
+		logger.Printf("No sleep from %s to %s, skipping the %s digest", from.Format(dateLayout), to.Format(dateLayout), period)
+		return nil
+	}
+
+	msg := slack.Message{
+		Channel:  u.Channel,
+		ThreadTS: u.ThreadTS,
+		Text:     digest.Summary(),
+	}
+
+	if cfg.Report.DigestAI {
+		digestMessage, err := FormatDigest(*digest)
+		if err != nil {
+			logger.Println("Error formatting the digest:", err)
+		} else {
+			// whoever roasts the nights that day
+			persona := reportPersona(cfg, u, at.Format(dateLayout), logger)
+			logger.Println("Generating the digest summary as", persona.Name+"...")
+			promptMessages := []AiMessage{
+				{Role: "system", Content: persona.DigestPrompt()},
+				{Role: "system", Content: digestMessage},
+			}
+			aiResponse, err := llm.Complete(context.Background(), promptMessages, nil)
+			if err != nil {
+				logger.Println("Error generating AI message:", err)
+			} else if aiResponse = enforceGuardrails(guardrails, llm, persona, promptMessages, aiResponse, logger); aiResponse != "" {
+				msg.Text += "\n\n" + aiResponse
+			}
+		}
+	}
+
+	if _, err := slackClient.PostMessage(context.Background(), msg); err != nil {
+		return fmt.Errorf("sending Slack message: %w", err)
+	}
+	logger.Println("Slack message sent:", msg.Text)
+
+	return nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"fitbit-workflow/fakefitbit"
+
+	"github.com/espcaa/random-workflows-that-actually-are-bots/slack"
+)
+
+func TestDigestRange(t *testing.T) {
+	auckland, err := time.LoadLocation("Pacific/Auckland")
+	if err != nil {
+		t.Fatal(err)
+	}
+	honolulu, err := time.LoadLocation("Pacific/Honolulu")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		period   string
+		at       time.Time
+		from, to string
+	}{
+		{"weekly", WeeklyDigest, time.Date(2025, 6, 9, 9, 0, 0, 0, time.UTC), "2025-06-02", "2025-06-08"},
+		{"weekly over a month", WeeklyDigest, time.Date(2025, 7, 2, 9, 0, 0, 0, time.UTC), "2025-06-25", "2025-07-01"},
+		{"weekly over a year", WeeklyDigest, time.Date(2026, 1, 3, 9, 0, 0, 0, time.UTC), "2025-12-27", "2026-01-02"},
+		// still sunday in UTC, already monday for the user
+		{"weekly ahead of UTC", WeeklyDigest, time.Date(2025, 6, 9, 0, 30, 0, 0, auckland), "2025-06-02", "2025-06-08"},
+		// already tuesday in UTC, still monday for the user
+		{"weekly behind UTC", WeeklyDigest, time.Date(2025, 6, 9, 23, 30, 0, 0, honolulu), "2025-06-02", "2025-06-08"},
+
+		{"monthly", MonthlyDigest, time.Date(2025, 7, 1, 9, 0, 0, 0, time.UTC), "2025-06-01", "2025-06-30"},
+		{"monthly late", MonthlyDigest, time.Date(2025, 7, 20, 9, 0, 0, 0, time.UTC), "2025-06-01", "2025-06-30"},
+		{"monthly february", MonthlyDigest, time.Date(2025, 3, 1, 9, 0, 0, 0, time.UTC), "2025-02-01", "2025-02-28"},
+		{"monthly leap year", MonthlyDigest, time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC), "2024-02-01", "2024-02-29"},
+		{"monthly over a year", MonthlyDigest, time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC), "2025-12-01", "2025-12-31"},
+		// the 1st in Auckland is still the 31st of the month before in UTC
+		{"monthly ahead of UTC", MonthlyDigest, time.Date(2025, 3, 1, 0, 5, 0, 0, auckland), "2025-02-01", "2025-02-28"},
+		// the 1st in UTC is still the last day of the month for the user
+		{"monthly behind UTC", MonthlyDigest, time.Date(2025, 2, 28, 23, 0, 0, 0, honolulu), "2025-01-01", "2025-01-31"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to := digestRange(tt.period, tt.at)
+			if got, want := from.Format(dateLayout)+" -> "+to.Format(dateLayout), tt.from+" -> "+tt.to; got != want {
+				t.Errorf("digestRange(%s, %s) = %s, want %s", tt.period, tt.at, got, want)
+			}
+		})
+	}
+}
+
+// digestNight is a main sleep on date from bedtime, hours long.
+func digestNight(date, bedtime string, hours float64, efficiency int) SleepLog {
+	start, _ := time.Parse(dateLayout+" 15:04", date+" "+bedtime)
+	if start.Hour() >= 12 {
+		start = start.AddDate(0, 0, -1)
+	}
+	duration := time.Duration(hours * float64(time.Hour))
+	return SleepLog{
+		LogID:         start.Unix(),
+		DateOfSleep:   date,
+		StartTime:     start.Format(fitbitTimeLayout),
+		EndTime:       start.Add(duration).Format(fitbitTimeLayout),
+		Duration:      duration.Milliseconds(),
+		MinutesAsleep: int(duration.Minutes()) - 20,
+		Efficiency:    efficiency,
+		IsMainSleep:   true,
+	}
+}
+
+func TestMakeDigest(t *testing.T) {
+	from, to := digestRange(WeeklyDigest, time.Date(2025, 6, 9, 9, 0, 0, 0, time.UTC))
+
+	resp := &FitbitSleepResponse{Sleep: []SleepLog{
+		digestNight("2025-06-02", "23:00", 8, 90),
+		digestNight("2025-06-03", "23:00", 6, 80),
+		digestNight("2025-06-04", "01:00", 5, 70),
+		digestNight("2025-06-06", "23:00", 9, 95),
+		// outside the week
+		digestNight("2025-06-01", "22:00", 12, 99),
+		digestNight("2025-06-09", "22:00", 2, 10),
+	}}
+	// a nap counts toward the night, but not as its main sleep
+	nap := digestNight("2025-06-03", "15:00", 1, 100)
+	nap.IsMainSleep = false
+	resp.Sleep = append(resp.Sleep, nap)
+
+	d := makeDigest(WeeklyDigest, from, to, nightsByDate(resp), 7)
+	if d == nil {
+		t.Fatal("makeDigest() = nil")
+	}
+
+	if d.From != "2025-06-02" || d.To != "2025-06-08" || d.Days != 7 || d.Nights != 4 {
+		t.Errorf("digest of %s -> %s, %d nights of %d days, want 2025-06-02 -> 2025-06-08, 4 of 7", d.From, d.To, d.Nights, d.Days)
+	}
+	if d.AvgHours != 7.25 {
+		t.Errorf("AvgHours = %v, want 7.25 with the nap", d.AvgHours)
+	}
+	if d.AvgEfficiency != 83.75 {
+		t.Errorf("AvgEfficiency = %v, want 83.75, of the main sleeps", d.AvgEfficiency)
+	}
+	if d.GoalHits != 3 {
+		t.Errorf("GoalHits = %d, want 3, the nap makes the 3rd", d.GoalHits)
+	}
+	if d.Best.Date != "2025-06-06" || d.Worst.Date != "2025-06-04" {
+		t.Errorf("best %s, worst %s, want 2025-06-06 and 2025-06-04", d.Best.Date, d.Worst.Date)
+	}
+	// around 11:30 PM, the 1am night pulls it past midnight
+	if !strings.HasPrefix(d.Bedtime, "11:") || !strings.HasSuffix(d.Bedtime, "PM") || d.BedtimeSpread < 30 {
+		t.Errorf("bedtime %s ± %.0f, want around 11:30 PM and a big spread", d.Bedtime, d.BedtimeSpread)
+	}
+	if d.StagesNights != 0 {
+		t.Errorf("StagesNights = %d, want 0 for classic logs", d.StagesNights)
+	}
+
+	for _, want := range []string{"Jun 2 -> Jun 8, 4 of 7 nights", "7.2 hours", "hit on 3 of 4", "Best night Fri Jun 6", "worst night Wed Jun 4"} {
+		if !strings.Contains(d.Summary(), want) {
+			t.Errorf("Summary() doesn't say %q:\n%s", want, d.Summary())
+		}
+	}
+
+	// a week without a night
+	if d := makeDigest(WeeklyDigest, to.AddDate(0, 0, 7), to.AddDate(0, 0, 14), nightsByDate(resp), 7); d != nil {
+		t.Errorf("makeDigest() of an empty week = %+v, want nil", d)
+	}
+}
+
+func TestMakeDigestMonthly(t *testing.T) {
+	from, to := digestRange(MonthlyDigest, time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC))
+
+	resp := &FitbitSleepResponse{}
+	for d := from.AddDate(0, 0, -3); d.Before(to.AddDate(0, 0, 3)); d = d.AddDate(0, 0, 1) {
+		resp.Sleep = append(resp.Sleep, digestNight(d.Format(dateLayout), "23:00", 7, 90))
+	}
+
+	d := makeDigest(MonthlyDigest, from, to, nightsByDate(resp), 8)
+	if d.Days != 29 || d.Nights != 29 || d.GoalHits != 0 {
+		t.Errorf("february 2024 = %d nights of %d days, %d goals hit, want 29 of 29 and none", d.Nights, d.Days, d.GoalHits)
+	}
+	if !strings.HasPrefix(d.Summary(), "*Monthly sleep digest* (Feb 1 -> Feb 29") {
+		t.Errorf("Summary() = %q", d.Summary())
+	}
+}
+
+func TestSendDigest(t *testing.T) {
+	_, srv := fakefitbit.NewServer()
+	defer srv.Close()
+
+	slackAPI := &fakeSlack{}
+	slackSrv := httptest.NewServer(slackAPI)
+	defer slackSrv.Close()
+
+	cfg := testConfig(t, srv.URL)
+	cfg.Report.Persona = "coach"
+	c := fakeClient(t, cfg)
+
+	history, err := openHistory(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer history.Close()
+
+	slackClient := slack.New("xoxb-test")
+	slackClient.BaseURL = slackSrv.URL
+
+	u := &User{ID: fakefitbit.UserID, Name: "Bob", Timezone: "UTC", Channel: "C123"}
+	logger := log.New(log.Writer(), "["+u.ID+"] ", log.Flags())
+	at := time.Date(2026, 10, 12, 9, 0, 0, 0, time.UTC)
+
+	t.Run("without AI", func(t *testing.T) {
+		cfg.Report.DigestAI = false
+		llm := &FakeLLM{}
+
+		if err := sendDigest(cfg, u, c, slackClient, history, llm, testGuardrails(t), logger, WeeklyDigest, at); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(llm.Requests()); n != 0 {
+			t.Errorf("%d AI requests with digest_ai off, want none", n)
+		}
+		posts := slackAPI.posts()
+		if len(posts) != 1 {
+			t.Fatalf("%d messages posted, want 1", len(posts))
+		}
+		if text := posts[0].Text; !strings.HasPrefix(text, "*Weekly sleep digest* (Oct 5 -> Oct 11") || strings.Contains(text, "\n\n") {
+			t.Errorf("digest = %q, want only the stats of Oct 5 -> Oct 11", text)
+		}
+	})
+
+	t.Run("with AI", func(t *testing.T) {
+		cfg.Report.DigestAI = true
+		// coach's emoji are allowed, others aren't
+		llm := &FakeLLM{Reply: "Bob lost the week 📣🔥."}
+
+		if err := sendDigest(cfg, u, c, slackClient, history, llm, testGuardrails(t), logger, WeeklyDigest, at); err != nil {
+			t.Fatal(err)
+		}
+		requests := llm.Requests()
+		if len(requests) == 0 {
+			t.Fatal("no AI request with digest_ai on")
+		}
+		prompt := requests[0][0].Content
+		for _, want := range []string{"You are Coach Dee.", "Bob's sleep", "whole week or month", "📣"} {
+			if !strings.Contains(prompt, want) {
+				t.Errorf("digest prompt doesn't have %q:\n%s", want, prompt)
+			}
+		}
+		for _, unwanted := range []string{"Alcide", "Alice", userNamePlaceholder} {
+			if strings.Contains(prompt, unwanted) {
+				t.Errorf("digest prompt has %q:\n%s", unwanted, prompt)
+			}
+		}
+
+		posts := slackAPI.posts()
+		text := posts[len(posts)-1].Text
+		if !strings.HasSuffix(text, "\n\nBob lost the week 📣.") {
+			t.Errorf("digest = %q, want the summary without the emoji coach doesn't use", text)
+		}
+	})
+}
@@ -0,0 +1,68 @@
+package fakefitbit
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// handleActivity serves a day with a run, late in the evening every other
+// day, and fewer steps on some days.
+func (f *Fake) handleActivity(w http.ResponseWriter, r *http.Request) {
+	date, ok := parseDate(w, chi.URLParam(r, "date"))
+	if !ok {
+		return
+	}
+
+	steps := 3500 + date.YearDay()%7*1200
+	startTime := "18:00"
+	if date.YearDay()%2 == 1 {
+		startTime = "21:40"
+	}
+
+	writeJSON(w, map[string]any{
+		"activities": []map[string]any{
+			{
+				"activityId":       90009,
+				"activityParentId": 90009,
+				"name":             "Run",
+				"startDate":        date.Format("2006-01-02"),
+				"startTime":        startTime,
+				"duration":         45 * 60 * 1000,
+				"calories":         420,
+				"hasStartTime":     true,
+				"isFavorite":       false,
+				"logId":            "6" + date.Format("20060102"),
+			},
+		},
+		"goals": map[string]any{"steps": 10000, "activeMinutes": 30},
+		"summary": map[string]any{
+			"steps":                steps,
+			"veryActiveMinutes":    32,
+			"fairlyActiveMinutes":  14,
+			"lightlyActiveMinutes": 180,
+			"sedentaryMinutes":     690,
+			"caloriesOut":          2450,
+		},
+	})
+}
+
+func (f *Fake) handleActiveZoneMinutes(w http.ResponseWriter, r *http.Request) {
+	date, ok := parseDate(w, chi.URLParam(r, "date"))
+	if !ok {
+		return
+	}
+
+	writeJSON(w, map[string]any{
+		"activities-active-zone-minutes": []map[string]any{
+			{
+				"dateTime": date.Format("2006-01-02"),
+				"value": map[string]any{
+					"activeZoneMinutes":        38,
+					"fatBurnActiveZoneMinutes": 16,
+					"cardioActiveZoneMinutes":  22,
+				},
+			},
+		},
+	})
+}
@@ -0,0 +1,476 @@
+// Package fakefitbit is a small stand-in for the Fitbit Web API. It serves
+// canned sleep logs, made up health data and a working OAuth token endpoint
+// so the bot can be run end to end without the network, either in-process
+// via httptest or as a standalone server (`go run . fake-fitbit`).
+package fakefitbit
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+//go:embed fixtures/sleep.json.tmpl
+var sleepTemplate string
+
+//go:embed fixtures/nap.json.tmpl
+var napTemplate string
+
+// TokensJSON is a tokens.json the fake accepts out of the box.
+//
+//go:embed fixtures/tokens.json
+var TokensJSON []byte
+
+const (
+	UserID = "FAKEUSER"
+
+	initialAccessToken  = "fake-access-token"
+	initialRefreshToken = "fake-refresh-token"
+
+	// defaultScope is every scope the bot can make use of.
+	defaultScope = "activity heartrate oxygen_saturation respiratory_rate sleep temperature"
+)
+
+type Fake struct {
+	mu           sync.Mutex
+	accessToken  string
+	refreshToken string
+	expired      map[string]bool
+	issued       int
+	noSleep      bool
+	nap          bool
+	scope        string
+	// revoked are the scopes the user took back since the last link
+	revoked map[string]bool
+	// notWorn are the dates the tracker spent on the nightstand
+	notWorn map[string]bool
+	// vitals overrides the nightly vitals of a date
+	vitals map[string]Vitals
+	// subscriptions maps subscription IDs to their collection
+	subscriptions map[string]string
+
+	// rateLimit is how many API calls are served per rateWindow, 0 for no
+	// limit
+	rateLimit   int
+	rateWindow  time.Duration
+	windowStart time.Time
+	requests    int
+}
+
+func New() *Fake {
+	return &Fake{
+		accessToken:  initialAccessToken,
+		refreshToken: initialRefreshToken,
+		expired:      make(map[string]bool),
+		scope:        defaultScope,
+		revoked:      make(map[string]bool),
+		notWorn:      make(map[string]bool),
+		vitals:       make(map[string]Vitals),
+
+		subscriptions: make(map[string]string),
+	}
+}
+
+// NewServer starts a fake on a local port. Callers must Close the server.
+func NewServer() (*Fake, *httptest.Server) {
+	f := New()
+	return f, httptest.NewServer(f.Handler())
+}
+
+// NewHandler returns the handler of a fresh fake.
+func NewHandler() http.Handler {
+	return New().Handler()
+}
+
+func (f *Fake) Handler() http.Handler {
+	r := chi.NewRouter()
+
+	r.Post("/oauth2/token", f.handleToken)
+
+	r.Group(func(r chi.Router) {
+		r.Use(f.requireToken)
+		r.With(f.requireScope("sleep")).Get("/1.2/user/{user}/sleep/date/{date}.json", f.handleSleep)
+		r.With(f.requireScope("sleep")).Get("/1.2/user/{user}/sleep/date/{start}/{end}.json", f.handleSleepRange)
+		r.Post("/1/user/{user}/{collection}/apiSubscriptions/{id}.json", f.handleSubscribe)
+		r.With(f.requireScope("heartrate")).Get("/1/user/{user}/activities/heart/date/{date}/{period}.json", f.handleHeartRate)
+		r.With(f.requireScope("heartrate")).Get("/1/user/{user}/activities/heart/date/{date}/1d/1min/time/{start}/{end}.json", f.handleHeartRateIntraday)
+		r.With(f.requireScope("activity")).Get("/1/user/{user}/activities/date/{date}.json", f.handleActivity)
+		r.With(f.requireScope("activity")).Get("/1/user/{user}/activities/active-zone-minutes/date/{date}/{period}.json", f.handleActiveZoneMinutes)
+		r.With(f.requireScope("heartrate")).Get("/1/user/{user}/hrv/date/{start}.json", f.handleHRV)
+		r.With(f.requireScope("heartrate")).Get("/1/user/{user}/hrv/date/{start}/{end}.json", f.handleHRV)
+		r.With(f.requireScope("oxygen_saturation")).Get("/1/user/{user}/spo2/date/{start}/{end}.json", f.handleSpO2)
+		r.With(f.requireScope("temperature")).Get("/1/user/{user}/temp/skin/date/{start}/{end}.json", f.handleSkinTemp)
+		r.With(f.requireScope("respiratory_rate")).Get("/1/user/{user}/br/date/{start}/{end}.json", f.handleBreathingRate)
+	})
+
+	return r
+}
+
+// ExpireAccessToken makes the current access token fail with expired_token
+// until the client refreshes.
+func (f *Fake) ExpireAccessToken() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.expired[f.accessToken] = true
+	f.accessToken = ""
+}
+
+// SetGrantedScope changes the scope granted to newly issued tokens, to see
+// how the bot copes with a user who unticked some permissions.
+func (f *Fake) SetGrantedScope(scope string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.scope = scope
+}
+
+// RevokeScope takes a scope back from the tokens already issued, like a
+// user unticking a permission in their Fitbit settings. Its endpoints answer
+// 403 insufficient_scope until the account is linked again.
+func (f *Fake) RevokeScope(scope string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.revoked[scope] = true
+}
+
+// Issued reports how many token pairs the token endpoint has handed out.
+func (f *Fake) Issued() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.issued
+}
+
+func (f *Fake) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		if r.PostForm.Get("code") == "" {
+			writeError(w, http.StatusBadRequest, "invalid_request", "Missing parameters: code")
+			return
+		}
+		// linking again grants the scopes again
+		clear(f.revoked)
+	case "refresh_token":
+		// refresh tokens are single-use, like the real API
+		if r.PostForm.Get("refresh_token") != f.refreshToken {
+			writeError(w, http.StatusBadRequest, "invalid_grant", "Refresh token invalid: "+r.PostForm.Get("refresh_token"))
+			return
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type", "Unsupported grant_type")
+		return
+	}
+
+	if f.accessToken != "" {
+		f.expired[f.accessToken] = true
+	}
+	f.issued++
+	f.accessToken = fmt.Sprintf("%s-%d", initialAccessToken, f.issued)
+	f.refreshToken = fmt.Sprintf("%s-%d", initialRefreshToken, f.issued)
+
+	writeJSON(w, map[string]any{
+		"access_token":  f.accessToken,
+		"refresh_token": f.refreshToken,
+		"expires_in":    28800,
+		"token_type":    "Bearer",
+		"user_id":       UserID,
+		"scope":         f.scope,
+	})
+}
+
+func (f *Fake) requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		f.mu.Lock()
+		valid, expired := token != "" && token == f.accessToken, f.expired[token]
+		f.mu.Unlock()
+
+		switch {
+		case valid:
+			if f.limited(w) {
+				writeError(w, http.StatusTooManyRequests, "system", "Too Many Requests")
+				return
+			}
+			next.ServeHTTP(w, r)
+		case expired:
+			writeError(w, http.StatusUnauthorized, "expired_token", "Access token expired: "+token)
+		default:
+			writeError(w, http.StatusUnauthorized, "invalid_token", "Access token invalid: "+token)
+		}
+	})
+}
+
+// requireScope answers 403 insufficient_scope when scope was revoked.
+func (f *Fake) requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			f.mu.Lock()
+			revoked := f.revoked[scope]
+			f.mu.Unlock()
+
+			if revoked {
+				writeError(w, http.StatusForbidden, "insufficient_scope", "This application does not have permission to access "+scope+" data")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// SetRateLimit serves only requests API calls per window, answering 429
+// with Fitbit's rate limit headers past that. Fitbit allows 150 per hour.
+func (f *Fake) SetRateLimit(requests int, window time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.rateLimit = requests
+	f.rateWindow = window
+	f.windowStart = time.Now()
+	f.requests = 0
+}
+
+// limited counts a request against the rate limit, sets the rate limit
+// headers and reports whether the request is over the limit.
+func (f *Fake) limited(w http.ResponseWriter) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.rateLimit == 0 {
+		return false
+	}
+
+	now := time.Now()
+	if now.Sub(f.windowStart) >= f.rateWindow {
+		f.windowStart = now
+		f.requests = 0
+	}
+	f.requests++
+
+	reset := int(f.windowStart.Add(f.rateWindow).Sub(now).Seconds() + 0.5)
+	w.Header().Set("Fitbit-Rate-Limit-Limit", fmt.Sprint(f.rateLimit))
+	w.Header().Set("Fitbit-Rate-Limit-Remaining", fmt.Sprint(max(f.rateLimit-f.requests, 0)))
+	w.Header().Set("Fitbit-Rate-Limit-Reset", fmt.Sprint(reset))
+
+	if f.requests > f.rateLimit {
+		w.Header().Set("Retry-After", fmt.Sprint(reset))
+		return true
+	}
+	return false
+}
+
+func (f *Fake) handleSleep(w http.ResponseWriter, r *http.Request) {
+	date, err := time.Parse("2006-01-02", chi.URLParam(r, "date"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "validation", "Invalid date: "+chi.URLParam(r, "date"))
+		return
+	}
+
+	if f.hasNoSleep() {
+		writeJSON(w, map[string]any{"sleep": []any{}})
+		return
+	}
+
+	if !f.hasNap() {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(renderNight(date)))
+		return
+	}
+
+	var day struct {
+		Sleep   []json.RawMessage `json:"sleep"`
+		Summary struct {
+			Stages             map[string]int `json:"stages"`
+			TotalMinutesAsleep int            `json:"totalMinutesAsleep"`
+			TotalSleepRecords  int            `json:"totalSleepRecords"`
+			TotalTimeInBed     int            `json:"totalTimeInBed"`
+		} `json:"summary"`
+	}
+	if err := json.Unmarshal([]byte(renderNight(date)), &day); err != nil {
+		writeError(w, http.StatusInternalServerError, "system", err.Error())
+		return
+	}
+	day.Sleep = append(day.Sleep, json.RawMessage(renderNap(date)))
+	day.Summary.TotalMinutesAsleep += 22
+	day.Summary.TotalSleepRecords++
+	day.Summary.TotalTimeInBed += 25
+
+	writeJSON(w, day)
+}
+
+func (f *Fake) handleSleepRange(w http.ResponseWriter, r *http.Request) {
+	start, err := time.Parse("2006-01-02", chi.URLParam(r, "start"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "validation", "Invalid date: "+chi.URLParam(r, "start"))
+		return
+	}
+	end, err := time.Parse("2006-01-02", chi.URLParam(r, "end"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "validation", "Invalid date: "+chi.URLParam(r, "end"))
+		return
+	}
+	if end.Before(start) || end.Sub(start) >= 100*24*time.Hour {
+		writeError(w, http.StatusBadRequest, "validation", "The time range must be between 1 and 100 days")
+		return
+	}
+
+	sleep := []json.RawMessage{}
+	if !f.hasNoSleep() {
+		// the range endpoint lists the newest night first
+		for d := end; !d.Before(start); d = d.AddDate(0, 0, -1) {
+			var night struct {
+				Sleep []json.RawMessage `json:"sleep"`
+			}
+			if err := json.Unmarshal([]byte(renderNight(d)), &night); err != nil {
+				writeError(w, http.StatusInternalServerError, "system", err.Error())
+				return
+			}
+			sleep = append(sleep, night.Sleep...)
+			if f.hasNap() {
+				sleep = append(sleep, json.RawMessage(renderNap(d)))
+			}
+		}
+	}
+
+	writeJSON(w, map[string]any{"sleep": sleep})
+}
+
+// handleSubscribe answers 201 for a new subscription and 200 for one that
+// already exists, like Fitbit.
+func (f *Fake) handleSubscribe(w http.ResponseWriter, r *http.Request) {
+	id, collection := chi.URLParam(r, "id"), chi.URLParam(r, "collection")
+
+	f.mu.Lock()
+	_, exists := f.subscriptions[id]
+	f.subscriptions[id] = collection
+	f.mu.Unlock()
+
+	status := http.StatusCreated
+	if exists {
+		status = http.StatusOK
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"collectionType": collection,
+		"ownerId":        UserID,
+		"ownerType":      "user",
+		"subscriberId":   r.Header.Get("X-Fitbit-Subscriber-Id"),
+		"subscriptionId": id,
+	})
+}
+
+// Subscriptions returns the collection of each subscription made so far,
+// by subscription ID.
+func (f *Fake) Subscriptions() map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	subscriptions := make(map[string]string, len(f.subscriptions))
+	for id, collection := range f.subscriptions {
+		subscriptions[id] = collection
+	}
+	return subscriptions
+}
+
+// SetNoSleep makes every sleep endpoint return an empty log, like Fitbit
+// does before the tracker has synced.
+func (f *Fake) SetNoSleep(noSleep bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.noSleep = noSleep
+}
+
+func (f *Fake) hasNoSleep() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.noSleep
+}
+
+// SetNotWorn makes the days of dates come back without resting heart rate,
+// HRV, intraday heart rate or nightly vitals, like Fitbit does when the
+// tracker wasn't worn. It replaces the dates of an earlier call, none to wear it again.
+func (f *Fake) SetNotWorn(dates ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	clear(f.notWorn)
+	for _, date := range dates {
+		f.notWorn[date] = true
+	}
+}
+
+func (f *Fake) worn(date time.Time) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return !f.notWorn[date.Format("2006-01-02")]
+}
+
+// SetNap adds a second, 25 minute session to every day after the main
+// sleep, like going back to bed for a bit.
+func (f *Fake) SetNap(nap bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.nap = nap
+}
+
+func (f *Fake) hasNap() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.nap
+}
+
+func renderNap(date time.Time) string {
+	return strings.NewReplacer(
+		"DATE", date.Format("2006-01-02"),
+		"LOG_ID", "5"+date.Format("20060102"),
+	).Replace(napTemplate)
+}
+
+func renderNight(date time.Time) string {
+	return strings.NewReplacer(
+		"PREV_DATE", date.AddDate(0, 0, -1).Format("2006-01-02"),
+		"DATE", date.Format("2006-01-02"),
+		"LOG_ID", "4"+date.Format("20060102"),
+	).Replace(sleepTemplate)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError mimics the error envelope of the Fitbit API.
+func writeError(w http.ResponseWriter, status int, errorType, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"errors": []map[string]string{
+			{"errorType": errorType, "message": message},
+		},
+		"success": false,
+	})
+}
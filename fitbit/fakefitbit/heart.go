@@ -0,0 +1,132 @@
+package fakefitbit
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// restingHeartRate varies a little from day to day so averages mean
+// something.
+func restingHeartRate(date time.Time) int {
+	return 56 + date.YearDay()%6
+}
+
+func hrv(date time.Time) (daily, deep float64) {
+	daily = 38 + float64(date.YearDay()%7)
+	return daily, daily - 3.5
+}
+
+func (f *Fake) handleHeartRate(w http.ResponseWriter, r *http.Request) {
+	end, ok := parseDate(w, chi.URLParam(r, "date"))
+	if !ok {
+		return
+	}
+
+	days := 1
+	switch period := chi.URLParam(r, "period"); period {
+	case "1d":
+	case "7d":
+		days = 7
+	case "30d":
+		days = 30
+	default:
+		writeError(w, http.StatusBadRequest, "validation", "Invalid period: "+period)
+		return
+	}
+
+	heart := []map[string]any{}
+	for d := end.AddDate(0, 0, 1-days); !d.After(end); d = d.AddDate(0, 0, 1) {
+		value := map[string]any{
+			"customHeartRateZones": []any{},
+			"heartRateZones":       []any{},
+		}
+		// the day is still listed, without a resting heart rate
+		if f.worn(d) {
+			value["restingHeartRate"] = restingHeartRate(d)
+		}
+		heart = append(heart, map[string]any{
+			"dateTime": d.Format("2006-01-02"),
+			"value":    value,
+		})
+	}
+
+	writeJSON(w, map[string]any{"activities-heart": heart})
+}
+
+// handleHeartRateIntraday serves a per minute series that dips in the
+// middle of the night.
+func (f *Fake) handleHeartRateIntraday(w http.ResponseWriter, r *http.Request) {
+	date, ok := parseDate(w, chi.URLParam(r, "date"))
+	if !ok {
+		return
+	}
+	from, err := time.Parse("15:04", chi.URLParam(r, "start"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "validation", "Invalid time: "+chi.URLParam(r, "start"))
+		return
+	}
+	to, err := time.Parse("15:04", chi.URLParam(r, "end"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "validation", "Invalid time: "+chi.URLParam(r, "end"))
+		return
+	}
+
+	dataset := []map[string]any{}
+	for t := from; f.worn(date) && !t.After(to); t = t.Add(time.Minute) {
+		value := 52 + (t.Hour()*60+t.Minute())%9
+		if t.Hour() >= 6 && t.Hour() < 22 {
+			value += 15
+		}
+		dataset = append(dataset, map[string]any{"time": t.Format("15:04:05"), "value": value})
+	}
+
+	writeJSON(w, map[string]any{
+		"activities-heart": []map[string]any{
+			{"dateTime": date.Format("2006-01-02"), "value": fmt.Sprint(restingHeartRate(date))},
+		},
+		"activities-heart-intraday": map[string]any{
+			"dataset":         dataset,
+			"datasetInterval": 1,
+			"datasetType":     "minute",
+		},
+	})
+}
+
+func (f *Fake) handleHRV(w http.ResponseWriter, r *http.Request) {
+	start, ok := parseDate(w, chi.URLParam(r, "start"))
+	if !ok {
+		return
+	}
+	end := start
+	if chi.URLParam(r, "end") != "" {
+		if end, ok = parseDate(w, chi.URLParam(r, "end")); !ok {
+			return
+		}
+	}
+
+	values := []map[string]any{}
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		if !f.worn(d) {
+			continue
+		}
+		daily, deep := hrv(d)
+		values = append(values, map[string]any{
+			"dateTime": d.Format("2006-01-02"),
+			"value":    map[string]any{"dailyRmssd": daily, "deepRmssd": deep},
+		})
+	}
+
+	writeJSON(w, map[string]any{"hrv": values})
+}
+
+func parseDate(w http.ResponseWriter, value string) (time.Time, bool) {
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "validation", "Invalid date: "+value)
+		return time.Time{}, false
+	}
+	return date, true
+}
@@ -0,0 +1,112 @@
+package fakefitbit
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// the nightly vitals wobble a little around a steady baseline
+
+func spo2(date time.Time) float64 {
+	return 96.2 + float64(date.YearDay()%5)*0.2
+}
+
+func skinTemp(date time.Time) float64 {
+	return float64(date.YearDay()%7-3) * 0.1
+}
+
+func breathingRate(date time.Time) float64 {
+	return 14.6 + float64(date.YearDay()%4)*0.3
+}
+
+// Vitals are the nightly vitals of a date.
+type Vitals struct {
+	SpO2          float64 // average %
+	SkinTemp      float64 // °C off the usual
+	BreathingRate float64 // breaths per minute
+}
+
+// SetVitals replaces the vitals of date, to see an unusual night flagged.
+func (f *Fake) SetVitals(date string, v Vitals) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.vitals[date] = v
+}
+
+// nightVitals are the vitals of date, and false if the tracker wasn't worn.
+func (f *Fake) nightVitals(date time.Time) (Vitals, bool) {
+	if !f.worn(date) {
+		return Vitals{}, false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if v, ok := f.vitals[date.Format("2006-01-02")]; ok {
+		return v, true
+	}
+	return Vitals{SpO2: spo2(date), SkinTemp: skinTemp(date), BreathingRate: breathingRate(date)}, true
+}
+
+// vitalsRange parses the start and end of a vitals range request and calls
+// fn for every date in it the tracker was worn.
+func (f *Fake) vitalsRange(w http.ResponseWriter, r *http.Request, fn func(date time.Time, v Vitals)) bool {
+	start, ok := parseDate(w, chi.URLParam(r, "start"))
+	if !ok {
+		return false
+	}
+	end, ok := parseDate(w, chi.URLParam(r, "end"))
+	if !ok {
+		return false
+	}
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		if v, ok := f.nightVitals(d); ok {
+			fn(d, v)
+		}
+	}
+	return true
+}
+
+func (f *Fake) handleSpO2(w http.ResponseWriter, r *http.Request) {
+	days := []map[string]any{}
+	ok := f.vitalsRange(w, r, func(d time.Time, v Vitals) {
+		avg := v.SpO2
+		days = append(days, map[string]any{
+			"dateTime": d.Format("2006-01-02"),
+			"value":    map[string]any{"avg": avg, "min": avg - 2.5, "max": 100.0},
+		})
+	})
+	if ok {
+		writeJSON(w, days)
+	}
+}
+
+func (f *Fake) handleSkinTemp(w http.ResponseWriter, r *http.Request) {
+	days := []map[string]any{}
+	ok := f.vitalsRange(w, r, func(d time.Time, v Vitals) {
+		days = append(days, map[string]any{
+			"dateTime": d.Format("2006-01-02"),
+			"value":    map[string]any{"nightlyRelative": v.SkinTemp},
+			"logType":  "dedicated_temp_sensor",
+		})
+	})
+	if ok {
+		writeJSON(w, map[string]any{"tempSkin": days})
+	}
+}
+
+func (f *Fake) handleBreathingRate(w http.ResponseWriter, r *http.Request) {
+	days := []map[string]any{}
+	ok := f.vitalsRange(w, r, func(d time.Time, v Vitals) {
+		days = append(days, map[string]any{
+			"dateTime": d.Format("2006-01-02"),
+			"value":    map[string]any{"breathingRate": v.BreathingRate},
+		})
+	})
+	if ok {
+		writeJSON(w, map[string]any{"br": days})
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// FakeLLM is an in-process backend for running the bot without an API key,
+// e.g. next to the fake Fitbit. It answers every request with Reply, or with
+// a canned line naming what it was asked about, in a JSON roast if asked
+// for one, and keeps the requests.
+type FakeLLM struct {
+	Reply string
+	Err   error
+	// Errs are returned by the first requests, one each, before Reply or
+	// Err apply, to script a flaky backend.
+	Errs []error
+
+	mu       sync.Mutex
+	requests [][]AiMessage
+}
+
+func (f *FakeLLM) Complete(ctx context.Context, messages []AiMessage, format *ResponseFormat) (string, error) {
+	f.mu.Lock()
+	f.requests = append(f.requests, append([]AiMessage(nil), messages...))
+	var scripted error
+	if len(f.Errs) > 0 {
+		scripted, f.Errs = f.Errs[0], f.Errs[1:]
+	}
+	f.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if scripted != nil {
+		return "", scripted
+	}
+	if f.Err != nil {
+		return "", f.Err
+	}
+	if f.Reply != "" {
+		return f.Reply, nil
+	}
+
+	// the first line of the data, like "SLEEP DATA FOR 2024-01-02"
+	var subject string
+	if len(messages) > 0 {
+		subject, _, _ = strings.Cut(strings.TrimSpace(messages[len(messages)-1].Content), "\n")
+	}
+	roast := fmt.Sprintf("(fake AI) Thoughts on %q: somebody slept, sort of.", subject)
+	if format != nil {
+		score := 50
+		reply, err := json.Marshal(Roast{
+			Headline:         "A night happened",
+			Roast:            roast,
+			PositiveNote:     "The tracker stayed on all night.",
+			Score:            &score,
+			SuggestedBedtime: "22:30",
+		})
+		return string(reply), err
+	}
+	return roast, nil
+}
+
+// Requests returns the messages of every request so far.
+func (f *FakeLLM) Requests() [][]AiMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([][]AiMessage(nil), f.requests...)
+}
@@ -4,86 +4,95 @@ import (
 	"bytes"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 )
 
 type FitbitSleepResponse struct {
-	Sleep []struct {
-		DateOfSleep         string `json:"dateOfSleep"`
-		Duration            int64  `json:"duration"`
-		Efficiency          int    `json:"efficiency"`
-		StartTime           string `json:"startTime"`
-		EndTime             string `json:"endTime"`
-		InfoCode            int    `json:"infoCode"`
-		IsMainSleep         bool   `json:"isMainSleep"`
-		MinutesAfterWakeup  int    `json:"minutesAfterWakeup"`
-		MinutesAwake        int    `json:"minutesAwake"`
-		MinutesAsleep       int    `json:"minutesAsleep"`
-		MinutesToFallAsleep int    `json:"minutesToFallAsleep"`
-		LogType             string `json:"logType"`
-		TimeInBed           int    `json:"timeInBed"`
-		Type                string `json:"type"`
-
-		Levels struct {
-			Data []struct {
-				DateTime string `json:"dateTime"`
-				Level    string `json:"level"`
-				Seconds  int    `json:"seconds"`
-			} `json:"data"`
-			ShortData []struct {
-				DateTime string `json:"dateTime"`
-				Level    string `json:"level"`
-				Seconds  int    `json:"seconds"`
-			} `json:"shortData"`
-			Summary struct {
-				Deep  struct{ Count, Minutes, ThirtyDayAvgMinutes int } `json:"deep"`
-				Light struct{ Count, Minutes, ThirtyDayAvgMinutes int } `json:"light"`
-				Rem   struct{ Count, Minutes, ThirtyDayAvgMinutes int } `json:"rem"`
-				Wake  struct{ Count, Minutes, ThirtyDayAvgMinutes int } `json:"wake"`
-			} `json:"summary"`
-		} `json:"levels"`
+	Sleep []SleepLog `json:"sleep"`
+
+	// Summary covers every session of the day, only the single date
+	// endpoint has it.
+	Summary struct {
+		TotalMinutesAsleep int `json:"totalMinutesAsleep"`
+		TotalSleepRecords  int `json:"totalSleepRecords"`
+		TotalTimeInBed     int `json:"totalTimeInBed"`
+		Stages             struct {
+			Deep  int `json:"deep"`
+			Light int `json:"light"`
+			Rem   int `json:"rem"`
+			Wake  int `json:"wake"`
+		} `json:"stages"`
+	} `json:"summary"`
+}
 
+// SleepLog is one sleep session, the night's main sleep or a nap.
+type SleepLog struct {
+	DateOfSleep         string `json:"dateOfSleep"`
+	Duration            int64  `json:"duration"`
+	Efficiency          int    `json:"efficiency"`
+	StartTime           string `json:"startTime"`
+	EndTime             string `json:"endTime"`
+	InfoCode            int    `json:"infoCode"`
+	IsMainSleep         bool   `json:"isMainSleep"`
+	MinutesAfterWakeup  int    `json:"minutesAfterWakeup"`
+	MinutesAwake        int    `json:"minutesAwake"`
+	MinutesAsleep       int    `json:"minutesAsleep"`
+	MinutesToFallAsleep int    `json:"minutesToFallAsleep"`
+	LogType             string `json:"logType"`
+	TimeInBed           int    `json:"timeInBed"`
+	Type                string `json:"type"`
+
+	Levels struct {
+		Data []struct {
+			DateTime string `json:"dateTime"`
+			Level    string `json:"level"`
+			Seconds  int    `json:"seconds"`
+		} `json:"data"`
+		ShortData []struct {
+			DateTime string `json:"dateTime"`
+			Level    string `json:"level"`
+			Seconds  int    `json:"seconds"`
+		} `json:"shortData"`
 		Summary struct {
-			TotalMinutesAsleep int `json:"totalMinutesAsleep"`
-			TotalSleepRecords  int `json:"totalSleepRecords"`
-			TotalTimeInBed     int `json:"totalTimeInBed"`
-			Stages             struct {
-				Deep  int `json:"deep"`
-				Light int `json:"light"`
-				Rem   int `json:"rem"`
-				Wake  int `json:"wake"`
-			} `json:"stages"`
+			Deep  struct{ Count, Minutes, ThirtyDayAvgMinutes int } `json:"deep"`
+			Light struct{ Count, Minutes, ThirtyDayAvgMinutes int } `json:"light"`
+			Rem   struct{ Count, Minutes, ThirtyDayAvgMinutes int } `json:"rem"`
+			Wake  struct{ Count, Minutes, ThirtyDayAvgMinutes int } `json:"wake"`
 		} `json:"summary"`
+	} `json:"levels"`
 
-		LogID int64 `json:"logId"`
-	} `json:"sleep"`
+	LogID int64 `json:"logId"`
 }
 
+const defaultFitbitAPIURL = "https://api.fitbit.com"
+
 func getSleep(client *FitbitClient, date string) (*FitbitSleepResponse, error) {
-	req, err := http.NewRequest("GET", "https://api.fitbit.com/1.2/user/"+client.UserID+"/sleep/date/"+date+".json", nil)
+	body, err := fitbitGet(client, "/1.2/user/"+client.UserID+"/sleep/date/"+date+".json")
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("fitbit sleep endpoint: %w", err)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+client.AccessToken)
-
-	httpClient := &http.Client{}
-	resp, err := httpClient.Do(req)
-	if err != nil {
+	var sleepResp FitbitSleepResponse
+	if err := json.Unmarshal(body, &sleepResp); err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	body, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("fitbit sleep endpoint returned %d: %s", resp.StatusCode, string(body))
-	}
+	return &sleepResp, nil
 
-	// print the full json response
+}
 
-	fmt.Println("Fitbit sleep response:", string(body))
+func getSleepRange(client *FitbitClient, startDate, endDate string) (*FitbitSleepResponse, error) {
+	body, err := fitbitGet(client, "/1.2/user/"+client.UserID+"/sleep/date/"+startDate+"/"+endDate+".json")
+	if err != nil {
+		return nil, fmt.Errorf("fitbit sleep range endpoint: %w", err)
+	}
 
 	var sleepResp FitbitSleepResponse
 	if err := json.Unmarshal(body, &sleepResp); err != nil {
@@ -91,45 +100,118 @@ func getSleep(client *FitbitClient, date string) (*FitbitSleepResponse, error) {
 	}
 
 	return &sleepResp, nil
+}
 
+// FitbitAPIError is a non-200 reply from the Fitbit API. ErrorType is taken
+// from the first entry of Fitbit's error envelope, e.g. "expired_token".
+type FitbitAPIError struct {
+	StatusCode int
+	ErrorType  string
+	Body       string
+
+	// RetryAfter is how long Fitbit asks to wait after a 429, until the
+	// hourly rate limit resets.
+	RetryAfter time.Duration
 }
 
-func getSleepRange(client *FitbitClient, startDate, endDate string) (*FitbitSleepResponse, error) {
-	req, err := http.NewRequest("GET", "https://api.fitbit.com/1.2/user/"+client.UserID+"/sleep/date/"+startDate+"/"+endDate+".json", nil)
+func (e *FitbitAPIError) Error() string {
+	return fmt.Sprintf("returned %d: %s", e.StatusCode, e.Body)
+}
+
+func (e *FitbitAPIError) Unwrap() error {
+	if e.ErrorType == "insufficient_scope" || e.ErrorType == "insufficient_permissions" {
+		return ErrScopeNotGranted
+	}
+	return nil
+}
+
+func newFitbitAPIError(statusCode int, body []byte) *FitbitAPIError {
+	var envelope struct {
+		Errors []struct {
+			ErrorType string `json:"errorType"`
+		} `json:"errors"`
+	}
+	apiErr := &FitbitAPIError{StatusCode: statusCode, Body: string(body)}
+	if json.Unmarshal(body, &envelope) == nil && len(envelope.Errors) > 0 {
+		apiErr.ErrorType = envelope.Errors[0].ErrorType
+	}
+	return apiErr
+}
+
+// fitbitGet does an authenticated GET against the client's API base URL and
+// returns the body of a 200 response. The access token is refreshed before
+// it expires, and once more if Fitbit still answers 401 expired_token.
+func fitbitGet(client *FitbitClient, path string) ([]byte, error) {
+	return fitbitRequest(client, "GET", path, nil)
+}
+
+// fitbitRequest is fitbitGet for any method, with extra headers. Any 2xx
+// counts as success.
+func fitbitRequest(client *FitbitClient, method, path string, header http.Header) ([]byte, error) {
+	if err := ensureFreshToken(client); err != nil {
+		return nil, fmt.Errorf("refreshing token: %w", err)
+	}
+
+	token := client.accessToken()
+	body, err := doFitbitRequest(client, method, path, token, header)
+
+	var apiErr *FitbitAPIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized && apiErr.ErrorType == "expired_token" {
+		log.Println("Fitbit access token expired, refreshing and retrying")
+		if err := refreshExpiredToken(client, token); err != nil {
+			return nil, fmt.Errorf("refreshing expired token: %w", err)
+		}
+		return doFitbitRequest(client, method, path, client.accessToken(), header)
+	}
+
+	return body, err
+}
+
+func doFitbitRequest(client *FitbitClient, method, path, token string, header http.Header) ([]byte, error) {
+	req, err := http.NewRequest(method, client.BaseURL+path, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+client.AccessToken)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
 
-	httpClient := &http.Client{}
-	resp, err := httpClient.Do(req)
+	resp, err := client.HTTPClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	body, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("fitbit sleep range endpoint returned %d: %s", resp.StatusCode, string(body))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := newFitbitAPIError(resp.StatusCode, body)
+		if resp.StatusCode == http.StatusTooManyRequests {
+			apiErr.RetryAfter = retryAfter(resp.Header)
+		}
+		return nil, apiErr
 	}
 
-	fmt.Println("Fitbit sleep range response:", string(body))
+	return body, nil
+}
 
-	var sleepResp FitbitSleepResponse
-	if err := json.Unmarshal(body, &sleepResp); err != nil {
-		return nil, err
+// retryAfter reads how long a rate limited client has to wait, from
+// Retry-After or Fitbit's own reset header, both in seconds.
+func retryAfter(header http.Header) time.Duration {
+	for _, name := range []string{"Retry-After", "Fitbit-Rate-Limit-Reset"} {
+		if seconds, err := strconv.Atoi(header.Get(name)); err == nil && seconds >= 0 {
+			return time.Duration(seconds) * time.Second
+		}
 	}
-
-	return &sleepResp, nil
+	return 0
 }
 
-func refreshToken(client *FitbitClient) error {
-
-	var data = fmt.Sprintf("client_id=%s&grant_type=refresh_token&refresh_token=%s", client.SecretClient.ClientID, client.RefreshToken)
-	req, err := http.NewRequest("POST", "https://api.fitbit.com/oauth2/token", bytes.NewBufferString(data))
+// postToken calls the OAuth token endpoint with the app's basic auth.
+func postToken(client *FitbitClient, data url.Values) (*FitbitTokenResponse, error) {
+	req, err := http.NewRequest("POST", client.BaseURL+"/oauth2/token", bytes.NewBufferString(data.Encode()))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Basic auth header
@@ -138,41 +220,122 @@ func refreshToken(client *FitbitClient) error {
 	req.Header.Set("Authorization", "Basic "+base64Auth)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	httpClient := &http.Client{}
-	resp, err := httpClient.Do(req)
+	resp, err := client.HTTPClient.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	body, _ := io.ReadAll(resp.Body)
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("fitbit token endpoint returned %d: %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("fitbit token endpoint returned %d: %s", resp.StatusCode, string(body))
 	}
 
 	var tokenResp FitbitTokenResponse
 	if err := json.Unmarshal(body, &tokenResp); err != nil {
-		return err
+		return nil, err
+	}
+
+	return &tokenResp, nil
+}
+
+// tokenRefreshMargin is how long before ExpiresAt a token gets refreshed.
+const tokenRefreshMargin = 10 * time.Minute
+
+func (c *FitbitClient) accessToken() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.AccessToken
+}
+
+// ensureFreshToken refreshes the token if it expires within
+// tokenRefreshMargin, or if its expiry is unknown.
+func ensureFreshToken(client *FitbitClient) error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if !client.ExpiresAt.IsZero() && time.Until(client.ExpiresAt) > tokenRefreshMargin {
+		return nil
+	}
+
+	return refreshTokenLocked(client)
+}
+
+// refreshExpiredToken refreshes after a 401, unless another caller already
+// replaced the stale token in the meantime.
+func refreshExpiredToken(client *FitbitClient, staleToken string) error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if client.AccessToken != staleToken {
+		return nil
 	}
 
-	client.AccessToken = tokenResp.AccessToken
-	client.RefreshToken = tokenResp.RefreshToken
-	client.ExpiresIn = tokenResp.ExpiresIn
-	client.TokenType = tokenResp.TokenType
-	client.UserID = tokenResp.UserID
+	return refreshTokenLocked(client)
+}
+
+func refreshToken(client *FitbitClient) error {
+	client.mu.Lock()
+	defer client.mu.Unlock()
 
-	// store the token in a json file
-	tokenFile, err := json.MarshalIndent(tokenResp, "", "  ")
+	return refreshTokenLocked(client)
+}
+
+func refreshTokenLocked(client *FitbitClient) error {
+
+	data := url.Values{}
+	data.Set("client_id", client.SecretClient.ClientID)
+	data.Set("grant_type", "refresh_token")
+	data.Set("refresh_token", client.RefreshToken)
+
+	tokenResp, err := postToken(client, data)
 	if err != nil {
 		return err
 	}
 
-	err = writeFile("tokens.json", tokenFile)
+	if err := saveToken(client, tokenResp); err != nil {
+		return err
+	}
+
+	fmt.Println("Fitbit token refreshed and saved")
+
+	return nil
+}
+
+// saveToken applies a token response to the client and persists it. Callers
+// must hold client.mu.
+func saveToken(client *FitbitClient, tokenResp *FitbitTokenResponse) error {
+	tokenResp.ExpiresAt = time.Now().Add(time.Duration(tokenResp.ExpiresIn) * time.Second)
+
+	applyToken(client, tokenResp)
+
+	return client.Store.Save(tokenResp)
+}
+
+// reloadToken replaces the client's token with the one in its store, e.g.
+// after the account was linked again.
+func reloadToken(client *FitbitClient) error {
+	token, err := client.Store.Load()
 	if err != nil {
 		return err
 	}
 
-	fmt.Println("Fitbit token refreshed and saved to tokens.json")
+	client.mu.Lock()
+	defer client.mu.Unlock()
 
+	applyToken(client, token)
 	return nil
 }
+
+// applyToken copies a token onto the client. Callers must hold client.mu
+// unless the client isn't shared yet.
+func applyToken(client *FitbitClient, token *FitbitTokenResponse) {
+	client.AccessToken = token.AccessToken
+	client.RefreshToken = token.RefreshToken
+	client.ExpiresIn = token.ExpiresIn
+	client.ExpiresAt = token.ExpiresAt
+	client.TokenType = token.TokenType
+	client.UserID = token.UserID
+	client.Scope = token.Scope
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"fitbit-workflow/fakefitbit"
+
+	"github.com/espcaa/random-workflows-that-actually-are-bots/slack"
+)
+
+// testConfig is the default config against a Fitbit at apiURL, with every
+// file in a temporary directory.
+func testConfig(t *testing.T, apiURL string) *Config {
+	t.Helper()
+
+	dir := t.TempDir()
+	cfg := defaultConfig()
+	cfg.Fitbit.ClientID = "CLIENTID"
+	cfg.Fitbit.ClientSecret = "secret"
+	cfg.Fitbit.CallbackURL = "http://localhost/callback"
+	cfg.Fitbit.APIURL = apiURL
+	cfg.Storage = StorageConfig{
+		UsersFile:   filepath.Join(dir, "users.json"),
+		TokensDir:   filepath.Join(dir, "tokens"),
+		TokensFile:  filepath.Join(dir, "tokens.json"),
+		LedgerFile:  filepath.Join(dir, "sent.json"),
+		HistoryFile: filepath.Join(dir, "history.db"),
+	}
+	return cfg
+}
+
+// fakeClient is a client holding the token of fakefitbit/fixtures/tokens.json,
+// good for a while yet.
+func fakeClient(t *testing.T, cfg *Config) *FitbitClient {
+	t.Helper()
+
+	var token FitbitTokenResponse
+	if err := json.Unmarshal(fakefitbit.TokensJSON, &token); err != nil {
+		t.Fatal(err)
+	}
+	token.ExpiresAt = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+
+	c := newFitbitClient(cfg, *newSecretClient(cfg), NewMemoryTokenStore(&token))
+	applyToken(c, &token)
+	return c
+}
+
+// fakeSlack answers every call like a successful chat.postMessage and keeps
+// the methods called, and the messages posted.
+type fakeSlack struct {
+	mu       sync.Mutex
+	methods  []string
+	messages []slack.Message
+}
+
+func (f *fakeSlack) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var msg slack.Message
+	json.NewDecoder(r.Body).Decode(&msg)
+
+	f.mu.Lock()
+	f.methods = append(f.methods, filepath.Base(r.URL.Path))
+	if filepath.Base(r.URL.Path) == "chat.postMessage" {
+		f.messages = append(f.messages, msg)
+	}
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"ok":true,"channel":"C123","ts":"1700000000.000100"}`))
+}
+
+func (f *fakeSlack) calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string(nil), f.methods...)
+}
+
+func (f *fakeSlack) posts() []slack.Message {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]slack.Message(nil), f.messages...)
+}
+
+func TestRefreshOnExpiredToken(t *testing.T) {
+	fake, srv := fakefitbit.NewServer()
+	defer srv.Close()
+
+	cfg := testConfig(t, srv.URL)
+	c := fakeClient(t, cfg)
+	oldToken := c.accessToken()
+
+	fake.ExpireAccessToken()
+
+	sleep, err := getSleep(c, "2026-10-14")
+	if err != nil {
+		t.Fatalf("getSleep() after the token expired: %v", err)
+	}
+	if len(sleep.Sleep) == 0 {
+		t.Error("getSleep() returned no sleep")
+	}
+
+	if n := fake.Issued(); n != 1 {
+		t.Errorf("the token endpoint issued %d tokens, want 1", n)
+	}
+	if c.accessToken() == oldToken {
+		t.Error("the client still has the expired access token")
+	}
+	saved, err := c.Store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.AccessToken != c.accessToken() || saved.RefreshToken != c.RefreshToken {
+		t.Errorf("saved token %s/%s, want the refreshed %s/%s", saved.AccessToken, saved.RefreshToken, c.accessToken(), c.RefreshToken)
+	}
+
+	// the refreshed token keeps working without another refresh
+	if _, err := getSleep(c, "2026-10-15"); err != nil {
+		t.Fatalf("getSleep() with the refreshed token: %v", err)
+	}
+	if n := fake.Issued(); n != 1 {
+		t.Errorf("the token endpoint issued %d tokens, want still 1", n)
+	}
+}
+
+func TestSendSleepReportNoSleep(t *testing.T) {
+	fake, srv := fakefitbit.NewServer()
+	defer srv.Close()
+
+	slackAPI := &fakeSlack{}
+	slackSrv := httptest.NewServer(slackAPI)
+	defer slackSrv.Close()
+
+	cfg := testConfig(t, srv.URL)
+	c := fakeClient(t, cfg)
+
+	ledger, err := openLedger(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err := openHistory(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer history.Close()
+
+	slackClient := slack.New("xoxb-test")
+	slackClient.BaseURL = slackSrv.URL
+
+	u := &User{ID: fakefitbit.UserID, Timezone: "UTC", Channel: "C123"}
+	status := &UserStatus{}
+	logger := log.New(log.Writer(), "["+u.ID+"] ", log.Flags())
+	date := "2026-10-14"
+	clock := newFakeClock(time.Date(2026, 10, 14, 8, 30, 0, 0, time.UTC))
+
+	// the tracker hasn't synced yet, keep polling
+	fake.SetNoSleep(true)
+	done, err := sendSleepReport(cfg, u, c, slackClient, ledger, history, &FakeLLM{}, testGuardrails(t), status, clock, logger, date)
+	if done || err != nil {
+		t.Fatalf("sendSleepReport() without sleep = %t, %v, want false, nil", done, err)
+	}
+	if ledger.Posted(u.ID, date) {
+		t.Error("the night is in the ledger without any sleep")
+	}
+	if calls := slackAPI.calls(); len(calls) > 0 {
+		t.Errorf("Slack was called without any sleep: %v", calls)
+	}
+	if info := status.snapshot(); info.LastFetch.IsZero() || info.LastFetchErr != "" {
+		t.Errorf("status after the fetch = %+v, want a fetch without error", info)
+	}
+
+	// then it does
+	fake.SetNoSleep(false)
+	done, err = sendSleepReport(cfg, u, c, slackClient, ledger, history, &FakeLLM{}, testGuardrails(t), status, clock, logger, date)
+	if !done || err != nil {
+		t.Fatalf("sendSleepReport() once synced = %t, %v, want true, nil", done, err)
+	}
+	if report, _ := ledger.Get(u.ID, date); !report.Posted || !report.PostedAt.Equal(clock.Now()) {
+		t.Errorf("ledger has %+v, want the night posted at %s", report, clock.Now())
+	}
+	if calls := slackAPI.calls(); len(calls) != 1 || calls[0] != "chat.postMessage" {
+		t.Errorf("Slack calls = %v, want one chat.postMessage", calls)
+	}
+}
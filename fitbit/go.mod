@@ -5,3 +5,30 @@ go 1.24.0
 require github.com/go-chi/chi/v5 v5.2.3
 
 require github.com/joho/godotenv v1.5.1
+
+require gopkg.in/yaml.v3 v3.0.1
+
+require github.com/robfig/cron/v3 v3.0.0
+
+require modernc.org/sqlite v1.46.1
+
+require github.com/espcaa/random-workflows-that-actually-are-bots/slack v0.0.0
+
+replace github.com/espcaa/random-workflows-that-actually-are-bots/slack => ../slack
+
+require github.com/espcaa/random-workflows-that-actually-are-bots/tokenfile v0.0.0
+
+replace github.com/espcaa/random-workflows-that-actually-are-bots/tokenfile => ../tokenfile
+
+require (
+	github.com/dustin/go-humanize v1.0.1 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/ncruces/go-strftime v1.0.0 // indirect
+	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
+	golang.org/x/exp v0.0.0-20251023183803-a4bb9ffd2546 // indirect
+	golang.org/x/sys v0.37.0 // indirect
+	modernc.org/libc v1.67.6 // indirect
+	modernc.org/mathutil v1.7.1 // indirect
+	modernc.org/memory v1.11.0 // indirect
+)
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed guardrails.yaml
+var guardrailsString string
+
+// Guardrails are the rules of guardrails.yaml, checked against the AI's
+// output before it goes to Slack.
+type Guardrails struct {
+	BannedWords      []string `yaml:"banned_words"`
+	BannedCharacters []string `yaml:"banned_characters"`
+	SecondPerson     []string `yaml:"second_person"`
+	MaxSentences     int      `yaml:"max_sentences"`
+	MaxCharacters    int      `yaml:"max_characters"`
+	Rewrites         int      `yaml:"rewrites"`
+	RewritePrompt    string   `yaml:"rewrite_prompt"`
+
+	bannedWords  *regexp.Regexp
+	secondPerson *regexp.Regexp
+
+	// set from the persona, see ForPersona
+	checkEmoji   bool
+	allowedEmoji map[string]bool
+}
+
+// Violation is a rule a reply broke.
+type Violation struct {
+	Rule   string // banned_words, second_person, ...
+	Detail string
+}
+
+func (v Violation) String() string {
+	return v.Rule + ": " + v.Detail
+}
+
+// loadGuardrails reads the guardrails file of the config, or the built-in
+// rules without one. It's meant to run once at startup, the rules are then
+// passed along with the LLM.
+func loadGuardrails(cfg *Config) (*Guardrails, error) {
+	name, data := "guardrails.yaml", []byte(guardrailsString)
+	if cfg.Storage.GuardrailsFile != "" {
+		name = cfg.Storage.GuardrailsFile
+		var err error
+		if data, err = os.ReadFile(name); err != nil {
+			return nil, err
+		}
+	}
+	return parseGuardrails(name, data)
+}
+
+func parseGuardrails(name string, data []byte) (*Guardrails, error) {
+	var g Guardrails
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&g); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", name, err)
+	}
+	if g.Rewrites < 0 {
+		return nil, fmt.Errorf("%s: rewrites can't be negative, got %d", name, g.Rewrites)
+	}
+	g.bannedWords = wordsRegexp(g.BannedWords)
+	g.secondPerson = wordsRegexp(g.SecondPerson)
+	return &g, nil
+}
+
+// wordsRegexp matches any of words as whole words, ignoring case. Curly
+// apostrophes count as straight ones.
+func wordsRegexp(words []string) *regexp.Regexp {
+	if len(words) == 0 {
+		return nil
+	}
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = strings.ReplaceAll(regexp.QuoteMeta(w), "'", "['’]")
+	}
+	// longest first, so "you're" wins over "you"
+	sort.SliceStable(quoted, func(i, j int) bool { return len(quoted[i]) > len(quoted[j]) })
+	return regexp.MustCompile(`(?i)\b(?:` + strings.Join(quoted, "|") + `)\b`)
+}
+
+// ForPersona returns the rules with the persona's own: its max length, and
+// no emoji but its own. A nil persona changes nothing.
+func (g *Guardrails) ForPersona(p *Persona) *Guardrails {
+	if p == nil {
+		return g
+	}
+	out := *g
+	if p.MaxLength > 0 {
+		out.MaxCharacters = p.MaxLength
+	}
+	out.checkEmoji = true
+	out.allowedEmoji = make(map[string]bool)
+	for _, e := range p.Emoji {
+		out.allowedEmoji[strings.TrimSuffix(e, "\uFE0F")] = true
+	}
+	return &out
+}
+
+// emojiPattern matches the usual emoji blocks, with an optional variation
+// selector.
+var emojiPattern = regexp.MustCompile(`[\x{1F000}-\x{1FAFF}\x{2600}-\x{27BF}\x{2B00}-\x{2BFF}]\x{FE0F}?`)
+
+// bannedEmoji returns the emoji of text the persona doesn't allow.
+func (g *Guardrails) bannedEmoji(text string) []string {
+	if !g.checkEmoji {
+		return nil
+	}
+	var banned []string
+	for _, e := range emojiPattern.FindAllString(text, -1) {
+		if !g.allowedEmoji[strings.TrimSuffix(e, "\uFE0F")] {
+			banned = append(banned, e)
+		}
+	}
+	return banned
+}
+
+var sentenceEnd = regexp.MustCompile(`[.!?]+["')]*(\s+|$)`)
+
+// sentences splits text after each ., ! or ?, keeping the punctuation.
+func sentences(text string) []string {
+	var out []string
+	start := 0
+	for _, loc := range sentenceEnd.FindAllStringIndex(text, -1) {
+		if s := strings.TrimSpace(text[start:loc[1]]); s != "" {
+			out = append(out, s)
+		}
+		start = loc[1]
+	}
+	if s := strings.TrimSpace(text[start:]); s != "" {
+		out = append(out, s)
+	}
+	return out
+}
+
+// Check returns the rules text breaks.
+func (g *Guardrails) Check(text string) []Violation {
+	var violations []Violation
+	if g.bannedWords != nil {
+		if found := g.bannedWords.FindAllString(text, -1); len(found) > 0 {
+			violations = append(violations, Violation{"banned_words", strings.Join(found, ", ")})
+		}
+	}
+	for _, c := range g.BannedCharacters {
+		if strings.Contains(text, c) {
+			violations = append(violations, Violation{"banned_characters", fmt.Sprintf("%q", c)})
+		}
+	}
+	if g.secondPerson != nil {
+		if found := g.secondPerson.FindAllString(text, -1); len(found) > 0 {
+			violations = append(violations, Violation{"second_person", strings.Join(found, ", ")})
+		}
+	}
+	if found := g.bannedEmoji(text); len(found) > 0 {
+		violations = append(violations, Violation{"emoji", strings.Join(found, " ") + " isn't allowed for this persona"})
+	}
+	if n := len(sentences(text)); g.MaxSentences > 0 && n > g.MaxSentences {
+		violations = append(violations, Violation{"max_sentences", fmt.Sprintf("%d sentences, at most %d", n, g.MaxSentences)})
+	}
+	if n := len([]rune(text)); g.MaxCharacters > 0 && n > g.MaxCharacters {
+		violations = append(violations, Violation{"max_characters", fmt.Sprintf("%d characters, at most %d", n, g.MaxCharacters)})
+	}
+	return violations
+}
+
+var (
+	extraSpaces  = regexp.MustCompile(`[ \t]{2,}`)
+	spaceBefore  = regexp.MustCompile(`\s+([,.!?;:])`)
+	doubleCommas = regexp.MustCompile(`,(\s*,)+`)
+)
+
+// Sanitize fixes what can be fixed without the model: banned words and
+// characters go, sentences speaking to the reader are cut and the rest is
+// trimmed to length. It can come back empty.
+func (g *Guardrails) Sanitize(text string) string {
+	for _, c := range g.BannedCharacters {
+		text = strings.ReplaceAll(text, " "+c+" ", ", ")
+		text = strings.ReplaceAll(text, c, ", ")
+	}
+	if g.bannedWords != nil {
+		text = g.bannedWords.ReplaceAllString(text, "")
+	}
+	for _, e := range g.bannedEmoji(text) {
+		text = strings.Replace(text, e, "", 1)
+	}
+	text = extraSpaces.ReplaceAllString(text, " ")
+	text = doubleCommas.ReplaceAllString(text, ",")
+	text = spaceBefore.ReplaceAllString(text, "$1")
+
+	var kept []string
+	length := 0
+	for _, s := range sentences(text) {
+		if g.secondPerson != nil && g.secondPerson.MatchString(s) {
+			continue
+		}
+		// what's left of a sentence that started with a banned word
+		s = strings.TrimLeft(s, ",;: ")
+		if strings.Trim(s, ".!? ") == "" {
+			continue
+		}
+		if r, size := utf8.DecodeRuneInString(s); r != utf8.RuneError {
+			s = string(unicode.ToUpper(r)) + s[size:]
+		}
+		if g.MaxSentences > 0 && len(kept) == g.MaxSentences {
+			break
+		}
+		if g.MaxCharacters > 0 && length+len([]rune(s))+len(kept) > g.MaxCharacters {
+			break
+		}
+		kept = append(kept, s)
+		length += len([]rune(s))
+	}
+	return strings.Join(kept, " ")
+}
+
+// Enforce checks reply, the model's answer to messages, and has the model
+// rewrite it while it breaks the rules and there are rewrites left. What
+// still breaks them then is sanitized.
+func (g *Guardrails) Enforce(ctx context.Context, llm LLM, messages []AiMessage, reply string, logger *log.Logger) string {
+	for attempt := 0; ; attempt++ {
+		violations := g.Check(reply)
+		if len(violations) == 0 {
+			return reply
+		}
+		for _, v := range violations {
+			logger.Println("AI reply broke a guardrail:", v)
+		}
+		if attempt == g.Rewrites {
+			break
+		}
+
+		var list strings.Builder
+		list.WriteString(g.RewritePrompt + "\n")
+		for _, v := range violations {
+			list.WriteString("\n- " + v.String())
+		}
+		messages = append(messages[:len(messages):len(messages)],
+			AiMessage{Role: "assistant", Content: reply},
+			AiMessage{Role: "user", Content: list.String()},
+		)
+
+		logger.Println("Asking the AI for a rewrite...")
+		rewrite, err := llm.Complete(ctx, messages, nil)
+		if err != nil {
+			logger.Println("Error getting the rewrite:", err)
+			break
+		}
+		reply = rewrite
+	}
+
+	sanitized := g.Sanitize(reply)
+	logger.Println("Sanitized AI reply:", sanitized)
+	return sanitized
+}
+
+// enforceGuardrails runs the rules and those of persona, if any, on an AI
+// reply.
+func enforceGuardrails(guardrails *Guardrails, llm LLM, persona *Persona, messages []AiMessage, reply string, logger *log.Logger) string {
+	return guardrails.ForPersona(persona).Enforce(context.Background(), llm, messages, reply, logger)
+}
+
+// sanitizeWithGuardrails cleans up a short piece of an AI reply, like a
+// headline, without asking for a rewrite.
+func sanitizeWithGuardrails(guardrails *Guardrails, persona *Persona, text string, logger *log.Logger) string {
+	if text == "" {
+		return ""
+	}
+	guardrails = guardrails.ForPersona(persona)
+	violations := guardrails.Check(text)
+	if len(violations) == 0 {
+		return text
+	}
+	for _, v := range violations {
+		logger.Println("AI reply broke a guardrail:", v)
+	}
+	return guardrails.Sanitize(text)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testGuardrails are the built-in rules.
+func testGuardrails(t *testing.T) *Guardrails {
+	t.Helper()
+
+	g, err := loadGuardrails(defaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return g
+}
+
+func TestLoadGuardrails(t *testing.T) {
+	dir := t.TempDir()
+	custom := filepath.Join(dir, "guardrails.yaml")
+	if err := os.WriteFile(custom, []byte("banned_words: [literally]\nmax_sentences: 2\nrewrites: 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	typo := filepath.Join(dir, "typo.yaml")
+	if err := os.WriteFile(typo, []byte("banned_word: [literally]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := defaultConfig()
+	g, err := loadGuardrails(cfg)
+	if err != nil {
+		t.Fatalf("loadGuardrails() of the built-in rules: %v", err)
+	}
+	if g.Rewrites != 2 || g.bannedWords == nil || g.secondPerson == nil {
+		t.Errorf("built-in rules = %+v", g)
+	}
+
+	cfg.Storage.GuardrailsFile = custom
+	g, err = loadGuardrails(cfg)
+	if err != nil {
+		t.Fatalf("loadGuardrails(%s): %v", custom, err)
+	}
+	if g.MaxSentences != 2 || g.Rewrites != 1 || g.secondPerson != nil {
+		t.Errorf("rules of %s = %+v", custom, g)
+	}
+	if v := g.Check("Literally asleep. You too."); len(v) != 1 || v[0].Rule != "banned_words" {
+		t.Errorf("Check() = %v, want only the file's banned word", v)
+	}
+
+	for _, name := range []string{typo, filepath.Join(dir, "missing.yaml")} {
+		cfg.Storage.GuardrailsFile = name
+		if _, err := loadGuardrails(cfg); err == nil {
+			t.Errorf("loadGuardrails(%s) error = nil", name)
+		}
+	}
+}
+
+func TestEnforceRewrite(t *testing.T) {
+	g := testGuardrails(t)
+	llm := &FakeLLM{Reply: "The sleeper went to bed at 2am and woke up at 6."}
+
+	got := g.Enforce(context.Background(), llm, testMessages, "You went to bed at 2am, honestly.", log.New(log.Writer(), "[test] ", log.Flags()))
+	if got != llm.Reply {
+		t.Errorf("Enforce() = %q, want the rewrite", got)
+	}
+
+	requests := llm.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("%d rewrites asked, want 1", len(requests))
+	}
+	// the conversation, the broken reply and what it broke
+	messages := requests[0]
+	if len(messages) != len(testMessages)+2 {
+		t.Fatalf("rewrite request of %d messages, want %d", len(messages), len(testMessages)+2)
+	}
+	if m := messages[len(testMessages)]; m.Role != "assistant" || m.Content != "You went to bed at 2am, honestly." {
+		t.Errorf("rewrite request has %+v, want the broken reply", m)
+	}
+	ask := messages[len(testMessages)+1]
+	if ask.Role != "user" || !strings.HasPrefix(ask.Content, g.RewritePrompt) {
+		t.Errorf("rewrite request asks %+v, want the rewrite prompt", ask)
+	}
+	for _, rule := range []string{"banned_words: honestly", "second_person: You"} {
+		if !strings.Contains(ask.Content, rule) {
+			t.Errorf("rewrite request doesn't list %q:\n%s", rule, ask.Content)
+		}
+	}
+}
+
+func TestEnforceSanitize(t *testing.T) {
+	g := testGuardrails(t)
+	// the model keeps breaking the rules
+	llm := &FakeLLM{Reply: "Honestly, bedtime was 2am — again. Your alarm lost. Sleep was 4 hours."}
+
+	got := g.Enforce(context.Background(), llm, testMessages, "You slept 4 hours.", log.New(log.Writer(), "[test] ", log.Flags()))
+	if want := "Bedtime was 2am, again. Sleep was 4 hours."; got != want {
+		t.Errorf("Enforce() = %q, want %q", got, want)
+	}
+	if n := len(llm.Requests()); n != g.Rewrites {
+		t.Errorf("%d rewrites asked, want %d", n, g.Rewrites)
+	}
+}
+
+func TestSanitizeWithGuardrails(t *testing.T) {
+	g := testGuardrails(t)
+	logger := log.New(log.Writer(), "[test] ", log.Flags())
+	persona := &Persona{MaxLength: 30, Emoji: []string{"😴"}}
+
+	tests := []struct {
+		name    string
+		persona *Persona
+		text    string
+		want    string
+	}{
+		{"clean", nil, "A short night.", "A short night."},
+		{"empty", nil, "", ""},
+		{"banned word and dash", nil, "Genuinely late – again.", "Late, again."},
+		{"second person", nil, "Late again. You need a bed.", "Late again."},
+		{"persona emoji", persona, "Late again 😴🔥.", "Late again 😴."},
+		{"persona length", persona, "Late again. Later than ever. Latest yet.", "Late again. Later than ever."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeWithGuardrails(g, tt.persona, tt.text, logger); got != tt.want {
+				t.Errorf("sanitizeWithGuardrails(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
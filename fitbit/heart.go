@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// HeartData is the heart side of a night: resting heart rate and HRV
+// against their 30-day averages, and the heart rate while asleep.
+type HeartData struct {
+	RestingHR    int     // bpm, 0 if Fitbit had none
+	RestingHRAvg float64 // over the 30 days before
+
+	SleepHRMin int // during the main sleep, from the intraday series
+	SleepHRMax int
+	SleepHRAvg float64
+
+	HRV     float64 // nightly RMSSD in ms, 0 if Fitbit had none
+	DeepHRV float64 // RMSSD during deep sleep
+	HRVAvg  float64 // over the 30 days before
+}
+
+type fitbitHeartResponse struct {
+	ActivitiesHeart []struct {
+		DateTime string `json:"dateTime"`
+		Value    struct {
+			RestingHeartRate int `json:"restingHeartRate"`
+		} `json:"value"`
+	} `json:"activities-heart"`
+}
+
+// the intraday reply also has activities-heart, but with a string value
+type fitbitHeartIntradayResponse struct {
+	Intraday struct {
+		Dataset []struct {
+			Time  string `json:"time"`
+			Value int    `json:"value"`
+		} `json:"dataset"`
+	} `json:"activities-heart-intraday"`
+}
+
+type fitbitHRVResponse struct {
+	HRV []struct {
+		DateTime string `json:"dateTime"`
+		Value    struct {
+			DailyRmssd float64 `json:"dailyRmssd"`
+			DeepRmssd  float64 `json:"deepRmssd"`
+		} `json:"value"`
+	} `json:"hrv"`
+}
+
+func getHeartRate(client *FitbitClient, date, period string) (*fitbitHeartResponse, error) {
+	body, err := fitbitGet(client, "/1/user/"+client.UserID+"/activities/heart/date/"+date+"/"+period+".json")
+	if err != nil {
+		return nil, fmt.Errorf("fitbit heart rate endpoint: %w", err)
+	}
+
+	var heartResp fitbitHeartResponse
+	if err := json.Unmarshal(body, &heartResp); err != nil {
+		return nil, err
+	}
+	return &heartResp, nil
+}
+
+// getHeartRateIntraday returns the per minute heart rate of date between
+// two "15:04" times. Fitbit only serves it to personal apps.
+func getHeartRateIntraday(client *FitbitClient, date, from, to string) (*fitbitHeartIntradayResponse, error) {
+	body, err := fitbitGet(client, "/1/user/"+client.UserID+"/activities/heart/date/"+date+"/1d/1min/time/"+from+"/"+to+".json")
+	if err != nil {
+		return nil, fmt.Errorf("fitbit intraday heart rate endpoint: %w", err)
+	}
+
+	var heartResp fitbitHeartIntradayResponse
+	if err := json.Unmarshal(body, &heartResp); err != nil {
+		return nil, err
+	}
+	return &heartResp, nil
+}
+
+func getHRVRange(client *FitbitClient, startDate, endDate string) (*fitbitHRVResponse, error) {
+	body, err := fitbitGet(client, "/1/user/"+client.UserID+"/hrv/date/"+startDate+"/"+endDate+".json")
+	if err != nil {
+		return nil, fmt.Errorf("fitbit hrv endpoint: %w", err)
+	}
+
+	var hrvResp fitbitHRVResponse
+	if err := json.Unmarshal(body, &hrvResp); err != nil {
+		return nil, err
+	}
+	return &hrvResp, nil
+}
+
+// getHeartData gathers the heart data of a night. Whatever Fitbit doesn't
+// have is left at zero. The error lists the calls that failed, the data is
+// nil only if they all did.
+func getHeartData(client *FitbitClient, night *Night) (*HeartData, error) {
+	day, err := time.Parse(dateLayout, night.Date)
+	if err != nil {
+		return nil, err
+	}
+	// the month before the night, for the averages
+	monthStart := day.AddDate(0, 0, -30).Format(dateLayout)
+	monthEnd := day.AddDate(0, 0, -1).Format(dateLayout)
+
+	var heart HeartData
+	var errs []error
+
+	if resp, err := getHeartRate(client, night.Date, "1d"); err != nil {
+		errs = append(errs, err)
+	} else if len(resp.ActivitiesHeart) > 0 {
+		heart.RestingHR = resp.ActivitiesHeart[0].Value.RestingHeartRate
+	}
+
+	if resp, err := getHeartRate(client, monthEnd, "30d"); err != nil {
+		errs = append(errs, err)
+	} else {
+		var values []float64
+		for _, d := range resp.ActivitiesHeart {
+			if d.Value.RestingHeartRate > 0 {
+				values = append(values, float64(d.Value.RestingHeartRate))
+			}
+		}
+		heart.RestingHRAvg = mean(values)
+	}
+
+	if err := addSleepHeartRate(client, night, &heart); err != nil {
+		errs = append(errs, err)
+	}
+
+	if resp, err := getHRVRange(client, night.Date, night.Date); err != nil {
+		errs = append(errs, err)
+	} else if len(resp.HRV) > 0 {
+		heart.HRV = resp.HRV[0].Value.DailyRmssd
+		heart.DeepHRV = resp.HRV[0].Value.DeepRmssd
+	}
+
+	// the HRV range endpoint is limited to 30 days
+	if resp, err := getHRVRange(client, monthStart, monthEnd); err != nil {
+		errs = append(errs, err)
+	} else {
+		var values []float64
+		for _, d := range resp.HRV {
+			if d.Value.DailyRmssd > 0 {
+				values = append(values, d.Value.DailyRmssd)
+			}
+		}
+		heart.HRVAvg = mean(values)
+	}
+
+	// 5 calls, give up only if they all failed
+	if len(errs) == 5 {
+		return nil, errors.Join(errs...)
+	}
+	return &heart, errors.Join(errs...)
+}
+
+// addSleepHeartRate sets the min, max and average heart rate during the
+// main sleep. A night crossing midnight takes one call per date.
+func addSleepHeartRate(client *FitbitClient, night *Night, heart *HeartData) error {
+	start, end := night.Main.Start(), night.Main.End()
+
+	var values []int
+	for day := start; day.Format(dateLayout) <= end.Format(dateLayout); day = day.AddDate(0, 0, 1) {
+		from, to := "00:00", "23:59"
+		if day.Format(dateLayout) == start.Format(dateLayout) {
+			from = start.Format("15:04")
+		}
+		if day.Format(dateLayout) == end.Format(dateLayout) {
+			to = end.Format("15:04")
+		}
+
+		resp, err := getHeartRateIntraday(client, day.Format(dateLayout), from, to)
+		if err != nil {
+			return err
+		}
+		for _, point := range resp.Intraday.Dataset {
+			values = append(values, point.Value)
+		}
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	heart.SleepHRMin, heart.SleepHRMax = values[0], values[0]
+	var sum int
+	for _, v := range values {
+		heart.SleepHRMin = min(heart.SleepHRMin, v)
+		heart.SleepHRMax = max(heart.SleepHRMax, v)
+		sum += v
+	}
+	heart.SleepHRAvg = float64(sum) / float64(len(values))
+
+	return nil
+}
+
+// Summary is the compact line for the Slack message, empty without data.
+func (h *HeartData) Summary() string {
+	var parts []string
+	if h.RestingHR > 0 {
+		parts = append(parts, withAverage(fmt.Sprintf("resting HR %d bpm", h.RestingHR), h.RestingHRAvg, "%.0f"))
+	}
+	if h.HRV > 0 {
+		parts = append(parts, withAverage(fmt.Sprintf("HRV %.0f ms", h.HRV), h.HRVAvg, "%.0f"))
+	}
+	return joinSummary(parts)
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// withAverage appends the 30-day average to a summary part if there is one.
+func withAverage(part string, avg float64, format string) string {
+	if avg == 0 {
+		return part
+	}
+	return part + " (30d avg " + fmt.Sprintf(format, avg) + ")"
+}
+
+// joinSummary capitalizes and joins the parts of a summary line.
+func joinSummary(parts []string) string {
+	if len(parts) == 0 {
+		return ""
+	}
+	line := strings.Join(parts, ", ")
+	return strings.ToUpper(line[:1]) + line[1:]
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"log"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"fitbit-workflow/fakefitbit"
+
+	"github.com/espcaa/random-workflows-that-actually-are-bots/slack"
+)
+
+// testNightDate is a night of the fake, 2026-10-13 23:10 to 07:02. Its
+// resting heart rate is 61 and its HRV 38 ms.
+const testNightDate = "2026-10-14"
+
+// fakeNight is the fake's night of date.
+func fakeNight(t *testing.T, c *FitbitClient, date string) *Night {
+	t.Helper()
+
+	resp, err := getSleep(c, date)
+	if err != nil {
+		t.Fatal(err)
+	}
+	night := newNight(date, resp.Sleep)
+	if night == nil {
+		t.Fatalf("no sleep on %s", date)
+	}
+	return night
+}
+
+// reportText posts the report of date with cfg and returns its text.
+func reportText(t *testing.T, cfg *Config, date string) string {
+	t.Helper()
+
+	slackAPI := &fakeSlack{}
+	slackSrv := httptest.NewServer(slackAPI)
+	defer slackSrv.Close()
+
+	ledger, err := openLedger(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err := openHistory(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer history.Close()
+
+	slackClient := slack.New("xoxb-test")
+	slackClient.BaseURL = slackSrv.URL
+
+	u := &User{ID: fakefitbit.UserID, Timezone: "UTC", Channel: "C123"}
+	logger := log.New(log.Writer(), "["+u.ID+"] ", log.Flags())
+	clock := newFakeClock(time.Date(2026, 10, 14, 8, 30, 0, 0, time.UTC))
+	done, err := sendSleepReport(cfg, u, fakeClient(t, cfg), slackClient, ledger, history, &FakeLLM{}, testGuardrails(t), &UserStatus{}, clock, logger, date)
+	if !done || err != nil {
+		t.Fatalf("sendSleepReport() = %t, %v, want true, nil", done, err)
+	}
+
+	posts := slackAPI.posts()
+	if len(posts) != 1 {
+		t.Fatalf("%d messages posted, want 1", len(posts))
+	}
+	return posts[0].Text
+}
+
+func TestGetHeartData(t *testing.T) {
+	fake, srv := fakefitbit.NewServer()
+	defer srv.Close()
+
+	cfg := testConfig(t, srv.URL)
+	c := fakeClient(t, cfg)
+	night := fakeNight(t, c, testNightDate)
+
+	heart, err := getHeartData(c, night)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if heart.RestingHR != 61 || heart.HRV != 38 || heart.DeepHRV != 34.5 {
+		t.Errorf("resting HR %d, HRV %v, deep HRV %v, want 61, 38 and 34.5", heart.RestingHR, heart.HRV, heart.DeepHRV)
+	}
+	// the fake's values go 56 to 61 and 38 to 44
+	if heart.RestingHRAvg < 56 || heart.RestingHRAvg > 61 || heart.HRVAvg < 38 || heart.HRVAvg > 44 {
+		t.Errorf("30 day averages %v and %v, want the fake's ranges", heart.RestingHRAvg, heart.HRVAvg)
+	}
+	// asleep from 23:10, over midnight, past 6:00 when the fake's heart
+	// rate goes up
+	if heart.SleepHRMin != 52 || heart.SleepHRMax != 75 || heart.SleepHRAvg <= 52 || heart.SleepHRAvg >= 75 {
+		t.Errorf("sleeping HR %d to %d, avg %v, want 52 to 75", heart.SleepHRMin, heart.SleepHRMax, heart.SleepHRAvg)
+	}
+
+	// a day off the wrist in the month doesn't drag the averages down
+	fake.SetNotWorn("2026-10-01", "2026-10-02")
+	gaps, err := getHeartData(c, night)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gaps.RestingHRAvg < 56 || gaps.HRVAvg < 38 {
+		t.Errorf("averages with missing days %v and %v, want them left out", gaps.RestingHRAvg, gaps.HRVAvg)
+	}
+}
+
+func TestGetHeartDataNotWorn(t *testing.T) {
+	fake, srv := fakefitbit.NewServer()
+	defer srv.Close()
+
+	cfg := testConfig(t, srv.URL)
+	c := fakeClient(t, cfg)
+	night := fakeNight(t, c, testNightDate)
+
+	// the night spans two dates, neither has heart data
+	fake.SetNotWorn("2026-10-13", testNightDate)
+	heart, err := getHeartData(c, night)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if heart.RestingHR != 0 || heart.HRV != 0 || heart.DeepHRV != 0 || heart.SleepHRMin != 0 || heart.SleepHRMax != 0 || heart.SleepHRAvg != 0 {
+		t.Errorf("heart data of a night off the wrist = %+v, want zeros", heart)
+	}
+	if heart.RestingHRAvg == 0 || heart.HRVAvg == 0 {
+		t.Errorf("heart data of a night off the wrist = %+v, want the averages still", heart)
+	}
+	if line := heart.Summary(); line != "" {
+		t.Errorf("Summary() = %q, want nothing to say", line)
+	}
+}
+
+func TestGetHeartDataErrors(t *testing.T) {
+	fake, srv := fakefitbit.NewServer()
+	defer srv.Close()
+
+	cfg := testConfig(t, srv.URL)
+	c := fakeClient(t, cfg)
+	night := fakeNight(t, c, testNightDate)
+
+	fake.RevokeScope("heartrate")
+	heart, err := getHeartData(c, night)
+	if err == nil || heart != nil {
+		t.Errorf("getHeartData() without heartrate = %+v, %v, want nil and an error", heart, err)
+	}
+}
+
+func TestHeartSummary(t *testing.T) {
+	tests := []struct {
+		name  string
+		heart HeartData
+		want  string
+	}{
+		{"everything", HeartData{RestingHR: 58, RestingHRAvg: 60.4, HRV: 42.3, HRVAvg: 39.6}, "Resting HR 58 bpm (30d avg 60), HRV 42 ms (30d avg 40)"},
+		{"no averages", HeartData{RestingHR: 58, HRV: 42}, "Resting HR 58 bpm, HRV 42 ms"},
+		{"no resting HR", HeartData{RestingHRAvg: 60, HRV: 42, HRVAvg: 40}, "HRV 42 ms (30d avg 40)"},
+		{"no HRV", HeartData{RestingHR: 58, RestingHRAvg: 60, HRVAvg: 40}, "Resting HR 58 bpm (30d avg 60)"},
+		// the sleeping heart rate is for the AI only
+		{"sleep HR only", HeartData{SleepHRMin: 50, SleepHRMax: 70, SleepHRAvg: 55}, ""},
+		{"nothing", HeartData{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.heart.Summary(); got != tt.want {
+				t.Errorf("Summary() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReportHeartLine(t *testing.T) {
+	fake, srv := fakefitbit.NewServer()
+	defer srv.Close()
+
+	cfg := testConfig(t, srv.URL)
+	cfg.Fitbit.Features = []Feature{FeatureHeartRate}
+	if text := reportText(t, cfg, testNightDate); !strings.Contains(text, "\nResting HR 61 bpm (30d avg ") || !strings.Contains(text, ", HRV 38 ms (30d avg ") {
+		t.Errorf("report = %q, want the heart line", text)
+	}
+
+	// no resting heart rate, no line
+	fake.SetNotWorn(testNightDate)
+	cfg = testConfig(t, srv.URL)
+	cfg.Fitbit.Features = []Feature{FeatureHeartRate}
+	if text := reportText(t, cfg, testNightDate); strings.Contains(text, "Resting HR") || strings.Contains(text, "HRV") {
+		t.Errorf("report of a night off the wrist = %q, want no heart line", text)
+	}
+
+	// and none without the feature
+	fake.SetNotWorn()
+	cfg = testConfig(t, srv.URL)
+	if text := reportText(t, cfg, testNightDate); strings.Contains(text, "Resting HR") {
+		t.Errorf("report without the heartrate feature = %q", text)
+	}
+}
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// History is the local copy of every user's sleep: the sessions, their
+// stage segments and a summary per date. The bot adds each night as it
+// reports it and `backfill` imports older ones, so history doesn't have to
+// be fetched from Fitbit again every morning.
+type History struct {
+	db *sql.DB
+}
+
+const historySchema = `
+CREATE TABLE IF NOT EXISTS sleep_logs (
+	log_id                 INTEGER PRIMARY KEY,
+	user_id                TEXT NOT NULL,
+	date_of_sleep          TEXT NOT NULL,
+	start_time             TEXT NOT NULL,
+	end_time               TEXT NOT NULL,
+	duration               INTEGER NOT NULL, -- milliseconds
+	efficiency             INTEGER NOT NULL,
+	is_main_sleep          INTEGER NOT NULL,
+	info_code              INTEGER NOT NULL,
+	log_type               TEXT NOT NULL,
+	type                   TEXT NOT NULL,
+	minutes_after_wakeup   INTEGER NOT NULL,
+	minutes_awake          INTEGER NOT NULL,
+	minutes_asleep         INTEGER NOT NULL,
+	minutes_to_fall_asleep INTEGER NOT NULL,
+	time_in_bed            INTEGER NOT NULL,
+	deep_minutes           INTEGER NOT NULL,
+	light_minutes          INTEGER NOT NULL,
+	rem_minutes            INTEGER NOT NULL,
+	wake_minutes           INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS sleep_logs_user_date ON sleep_logs (user_id, date_of_sleep);
+
+CREATE TABLE IF NOT EXISTS sleep_stages (
+	log_id    INTEGER NOT NULL,
+	date_time TEXT NOT NULL,
+	level     TEXT NOT NULL,
+	seconds   INTEGER NOT NULL,
+	short     INTEGER NOT NULL -- from levels.shortData, the short wakes
+);
+CREATE INDEX IF NOT EXISTS sleep_stages_log ON sleep_stages (log_id);
+
+CREATE TABLE IF NOT EXISTS sleep_summaries (
+	user_id              TEXT NOT NULL,
+	date                 TEXT NOT NULL,
+	total_minutes_asleep INTEGER NOT NULL,
+	total_sleep_records  INTEGER NOT NULL,
+	total_time_in_bed    INTEGER NOT NULL,
+	deep_minutes         INTEGER NOT NULL,
+	light_minutes        INTEGER NOT NULL,
+	rem_minutes          INTEGER NOT NULL,
+	wake_minutes         INTEGER NOT NULL,
+	PRIMARY KEY (user_id, date)
+);
+
+-- dates fetched from Fitbit, with or without sleep, except recent ones
+-- without sleep since the tracker may not have synced them yet
+CREATE TABLE IF NOT EXISTS synced_dates (
+	user_id TEXT NOT NULL,
+	date    TEXT NOT NULL,
+	PRIMARY KEY (user_id, date)
+);
+`
+
+func openHistory(cfg *Config) (*History, error) {
+	return OpenHistory(cfg.Storage.HistoryFile)
+}
+
+// OpenHistory opens the SQLite database at path, creating it if needed.
+func OpenHistory(path string) (*History, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// every user's loop writes to it, one connection keeps SQLite happy
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(historySchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return &History{db: db}, nil
+}
+
+func (h *History) Close() error {
+	return h.db.Close()
+}
+
+// lateSyncDays is how many days back a night can still show up on Fitbit,
+// if the tracker syncs late. Dates that recent without sleep are fetched
+// again next time instead of being marked synced. It's counted from today in
+// UTC, so it covers today and yesterday in every timezone.
+const lateSyncDays = 2
+
+// SaveSleep stores a sleep response covering the user's dates from start to
+// end, both included. Whatever was stored for those dates is replaced, so
+// saving the same range twice is harmless and logs deleted on Fitbit go
+// away.
+func (h *History) SaveSleep(userID, startDate, endDate string, resp *FitbitSleepResponse) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM sleep_stages WHERE log_id IN
+		(SELECT log_id FROM sleep_logs WHERE user_id = ? AND date_of_sleep BETWEEN ? AND ?)`,
+		userID, startDate, endDate); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM sleep_logs WHERE user_id = ? AND date_of_sleep BETWEEN ? AND ?`,
+		userID, startDate, endDate); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM sleep_summaries WHERE user_id = ? AND date BETWEEN ? AND ?`,
+		userID, startDate, endDate); err != nil {
+		return err
+	}
+
+	for _, s := range resp.Sleep {
+		if err := insertSleepLog(tx, userID, &s); err != nil {
+			return fmt.Errorf("sleep log %d: %w", s.LogID, err)
+		}
+	}
+
+	start, err := time.Parse(dateLayout, startDate)
+	if err != nil {
+		return err
+	}
+	end, err := time.Parse(dateLayout, endDate)
+	if err != nil {
+		return err
+	}
+	nights := nightsByDate(resp)
+	recent := time.Now().UTC().AddDate(0, 0, -lateSyncDays).Format(dateLayout)
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		date := d.Format(dateLayout)
+		if nights[date] == nil && date >= recent {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO synced_dates (user_id, date) VALUES (?, ?)`,
+			userID, date); err != nil {
+			return err
+		}
+	}
+
+	// the range endpoint has no summary, so it is worked out from the
+	// sessions the same way for both endpoints
+	for date, night := range nights {
+		if date < startDate || date > endDate {
+			continue
+		}
+		if err := insertSleepSummary(tx, userID, night); err != nil {
+			return fmt.Errorf("summary of %s: %w", date, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func insertSleepLog(tx *sql.Tx, userID string, s *SleepLog) error {
+	_, err := tx.Exec(`INSERT OR REPLACE INTO sleep_logs (
+		log_id, user_id, date_of_sleep, start_time, end_time, duration,
+		efficiency, is_main_sleep, info_code, log_type, type,
+		minutes_after_wakeup, minutes_awake, minutes_asleep, minutes_to_fall_asleep, time_in_bed,
+		deep_minutes, light_minutes, rem_minutes, wake_minutes
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.LogID, userID, s.DateOfSleep, s.StartTime, s.EndTime, s.Duration,
+		s.Efficiency, s.IsMainSleep, s.InfoCode, s.LogType, s.Type,
+		s.MinutesAfterWakeup, s.MinutesAwake, s.MinutesAsleep, s.MinutesToFallAsleep, s.TimeInBed,
+		s.Levels.Summary.Deep.Minutes, s.Levels.Summary.Light.Minutes, s.Levels.Summary.Rem.Minutes, s.Levels.Summary.Wake.Minutes,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range s.Levels.Data {
+		if _, err := tx.Exec(`INSERT INTO sleep_stages (log_id, date_time, level, seconds, short) VALUES (?, ?, ?, ?, 0)`,
+			s.LogID, d.DateTime, d.Level, d.Seconds); err != nil {
+			return err
+		}
+	}
+	for _, d := range s.Levels.ShortData {
+		if _, err := tx.Exec(`INSERT INTO sleep_stages (log_id, date_time, level, seconds, short) VALUES (?, ?, ?, ?, 1)`,
+			s.LogID, d.DateTime, d.Level, d.Seconds); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func insertSleepSummary(tx *sql.Tx, userID string, night *Night) error {
+	var inBed, deep, light, rem, wake int
+	for _, s := range night.Sessions() {
+		inBed += s.TimeInBed
+		deep += s.Levels.Summary.Deep.Minutes
+		light += s.Levels.Summary.Light.Minutes
+		rem += s.Levels.Summary.Rem.Minutes
+		wake += s.Levels.Summary.Wake.Minutes
+	}
+
+	_, err := tx.Exec(`INSERT INTO sleep_summaries (
+		user_id, date, total_minutes_asleep, total_sleep_records, total_time_in_bed,
+		deep_minutes, light_minutes, rem_minutes, wake_minutes
+	) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, night.Date, night.MinutesAsleep, len(night.Naps)+1, inBed,
+		deep, light, rem, wake,
+	)
+	return err
+}
+
+// SleepRange returns the stored sessions of the user's dates from start to
+// end, both included, shaped like the sleep range endpoint's reply.
+func (h *History) SleepRange(userID, startDate, endDate string) (*FitbitSleepResponse, error) {
+	rows, err := h.db.Query(`SELECT
+		log_id, date_of_sleep, start_time, end_time, duration,
+		efficiency, is_main_sleep, info_code, log_type, type,
+		minutes_after_wakeup, minutes_awake, minutes_asleep, minutes_to_fall_asleep, time_in_bed,
+		deep_minutes, light_minutes, rem_minutes, wake_minutes
+	FROM sleep_logs WHERE user_id = ? AND date_of_sleep BETWEEN ? AND ?
+	ORDER BY date_of_sleep DESC, start_time DESC`, userID, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	resp := &FitbitSleepResponse{}
+	byID := make(map[int64]int)
+	for rows.Next() {
+		var s SleepLog
+		if err := rows.Scan(
+			&s.LogID, &s.DateOfSleep, &s.StartTime, &s.EndTime, &s.Duration,
+			&s.Efficiency, &s.IsMainSleep, &s.InfoCode, &s.LogType, &s.Type,
+			&s.MinutesAfterWakeup, &s.MinutesAwake, &s.MinutesAsleep, &s.MinutesToFallAsleep, &s.TimeInBed,
+			&s.Levels.Summary.Deep.Minutes, &s.Levels.Summary.Light.Minutes, &s.Levels.Summary.Rem.Minutes, &s.Levels.Summary.Wake.Minutes,
+		); err != nil {
+			return nil, err
+		}
+		byID[s.LogID] = len(resp.Sleep)
+		resp.Sleep = append(resp.Sleep, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	stages, err := h.db.Query(`SELECT st.log_id, st.date_time, st.level, st.seconds, st.short
+	FROM sleep_stages st JOIN sleep_logs l ON l.log_id = st.log_id
+	WHERE l.user_id = ? AND l.date_of_sleep BETWEEN ? AND ?
+	ORDER BY st.rowid`, userID, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	defer stages.Close()
+
+	for stages.Next() {
+		var logID int64
+		var short bool
+		var d struct {
+			DateTime string `json:"dateTime"`
+			Level    string `json:"level"`
+			Seconds  int    `json:"seconds"`
+		}
+		if err := stages.Scan(&logID, &d.DateTime, &d.Level, &d.Seconds, &short); err != nil {
+			return nil, err
+		}
+		s := &resp.Sleep[byID[logID]]
+		if short {
+			s.Levels.ShortData = append(s.Levels.ShortData, d)
+		} else {
+			s.Levels.Data = append(s.Levels.Data, d)
+		}
+	}
+
+	return resp, stages.Err()
+}
+
+// FirstUnsynced returns the first of the user's dates from start to end
+// that was never fetched from Fitbit, if any.
+func (h *History) FirstUnsynced(userID, startDate, endDate string) (date string, ok bool, err error) {
+	start, err := time.Parse(dateLayout, startDate)
+	if err != nil {
+		return "", false, err
+	}
+	end, err := time.Parse(dateLayout, endDate)
+	if err != nil {
+		return "", false, err
+	}
+
+	rows, err := h.db.Query(`SELECT date FROM synced_dates WHERE user_id = ? AND date BETWEEN ? AND ?`,
+		userID, startDate, endDate)
+	if err != nil {
+		return "", false, err
+	}
+	defer rows.Close()
+
+	synced := make(map[string]bool)
+	for rows.Next() {
+		var d string
+		if err := rows.Scan(&d); err != nil {
+			return "", false, err
+		}
+		synced[d] = true
+	}
+	if err := rows.Err(); err != nil {
+		return "", false, err
+	}
+
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		if !synced[d.Format(dateLayout)] {
+			return d.Format(dateLayout), true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// loadSleepHistory returns the user's nights from start to end out of the
+// history. Dates never fetched yet, e.g. on the first run, and recent ones
+// that had no sleep are fetched from Fitbit and saved first.
+func loadSleepHistory(history *History, client *FitbitClient, userID, startDate, endDate string) (*FitbitSleepResponse, error) {
+	first, ok, err := history.FirstUnsynced(userID, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		resp, err := getSleepRange(client, first, endDate)
+		if err != nil {
+			return nil, err
+		}
+		if err := history.SaveSleep(userID, first, endDate, resp); err != nil {
+			return nil, err
+		}
+	}
+
+	return history.SleepRange(userID, startDate, endDate)
+}
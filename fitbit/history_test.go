@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestLateSync checks a recent night that wasn't on Fitbit yet is fetched
+// again once the tracker synced it, while older empty dates stay synced.
+func TestLateSync(t *testing.T) {
+	today := time.Now().UTC()
+	day := func(ago int) string { return today.AddDate(0, 0, -ago).Format(dateLayout) }
+
+	var mu sync.Mutex
+	served := sleepLogs(day(4), 3) // 6, 5 and 4 days ago
+	var paths []string
+	fitbit := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		paths = append(paths, r.URL.Path)
+		json.NewEncoder(w).Encode(served)
+	}))
+	defer fitbit.Close()
+
+	history, err := OpenHistory(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer history.Close()
+
+	c := &FitbitClient{
+		AccessToken: "token",
+		ExpiresAt:   time.Now().Add(time.Hour),
+		UserID:      "TESTUSER",
+		BaseURL:     fitbit.URL,
+		HTTPClient:  fitbit.Client(),
+	}
+
+	resp, err := loadSleepHistory(history, c, c.UserID, day(6), day(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Sleep) != 3 {
+		t.Fatalf("%d nights, want 3", len(resp.Sleep))
+	}
+
+	// 3 days ago is settled, the last two days could still sync
+	first, ok, err := history.FirstUnsynced(c.UserID, day(6), day(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || first != day(2) {
+		t.Errorf("FirstUnsynced() = %s, %t, want %s", first, ok, day(2))
+	}
+
+	// the tracker syncs last night
+	mu.Lock()
+	served = sleepLogs(day(1), 1)
+	mu.Unlock()
+
+	resp, err = loadSleepHistory(history, c, c.UserID, day(6), day(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Sleep) != 4 || resp.Sleep[0].DateOfSleep != day(1) {
+		t.Errorf("%d nights from %s, want 4 with last night's", len(resp.Sleep), resp.Sleep[0].DateOfSleep)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"/1.2/user/TESTUSER/sleep/date/" + day(6) + "/" + day(0) + ".json",
+		"/1.2/user/TESTUSER/sleep/date/" + day(2) + "/" + day(0) + ".json",
+	}
+	if len(paths) != len(want) {
+		t.Fatalf("requested %v, want %v", paths, want)
+	}
+	for i := range want {
+		if paths[i] != want[i] {
+			t.Errorf("request #%d = %s, want %s", i+1, paths[i], want[i])
+		}
+	}
+}
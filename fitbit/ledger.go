@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/espcaa/random-workflows-that-actually-are-bots/tokenfile"
+)
+
+// SentReport is what the bot did about one user's night.
+type SentReport struct {
+	Posted   bool      `json:"posted"`
+	PostedAt time.Time `json:"posted_at,omitzero"`
+	Channel  string    `json:"channel,omitempty"`
+	SlackTS  string    `json:"slack_ts,omitempty"` // ts of the Slack message
+	LogID    int64     `json:"log_id,omitempty"`   // Fitbit sleep log the report was built from
+}
+
+// Ledger remembers which daily reports went out, so a restart neither posts
+// today's report twice nor forgets one that never made it. It is kept in one
+// JSON file, keyed by user ID and then by date.
+type Ledger struct {
+	mu      sync.Mutex
+	path    string
+	reports map[string]map[string]SentReport
+}
+
+func openLedger(cfg *Config) (*Ledger, error) {
+	return LoadLedger(cfg.Storage.LedgerFile)
+}
+
+func LoadLedger(path string) (*Ledger, error) {
+	ledger := &Ledger{
+		path:    path,
+		reports: make(map[string]map[string]SentReport),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ledger, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &ledger.reports); err != nil {
+		return nil, fmt.Errorf("%s is corrupted: %w", path, err)
+	}
+
+	return ledger, nil
+}
+
+// Get returns what was recorded for a user's date, if anything.
+func (l *Ledger) Get(userID, date string) (SentReport, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	report, ok := l.reports[userID][date]
+	return report, ok
+}
+
+// Posted reports whether the user's report for date already went out.
+func (l *Ledger) Posted(userID, date string) bool {
+	report, _ := l.Get(userID, date)
+	return report.Posted
+}
+
+// Record saves what happened to a user's report for date.
+func (l *Ledger) Record(userID, date string, report SentReport) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.reports[userID] == nil {
+		l.reports[userID] = make(map[string]SentReport)
+	}
+	l.reports[userID][date] = report
+
+	data, err := json.MarshalIndent(l.reports, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return tokenfile.WriteAtomic(l.path, data)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"fitbit-workflow/fakefitbit"
+
+	"github.com/espcaa/random-workflows-that-actually-are-bots/slack"
+)
+
+func TestLedger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sent.json")
+
+	ledger, err := LoadLedger(path)
+	if err != nil {
+		t.Fatalf("LoadLedger() without a file: %v", err)
+	}
+	if _, ok := ledger.Get("ALICE", "2026-10-14"); ok {
+		t.Error("a new ledger has a report")
+	}
+
+	postedAt := time.Date(2026, 10, 14, 8, 30, 0, 0, time.UTC)
+	sent := SentReport{Posted: true, PostedAt: postedAt, Channel: "C123", SlackTS: "1700000000.000100", LogID: 42}
+	if err := ledger.Record("ALICE", "2026-10-14", sent); err != nil {
+		t.Fatal(err)
+	}
+	if err := ledger.Record("BOB", "2026-10-14", SentReport{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// what a restart sees
+	reloaded, err := LoadLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, ok := reloaded.Get("ALICE", "2026-10-14")
+	if !ok || report != sent {
+		t.Errorf("Get() after a restart = %+v, %t, want %+v", report, ok, sent)
+	}
+	if !reloaded.Posted("ALICE", "2026-10-14") {
+		t.Error("the report isn't posted after a restart")
+	}
+	// per user and per date
+	for _, key := range [][2]string{{"ALICE", "2026-10-13"}, {"BOB", "2026-10-14"}, {"CAROL", "2026-10-14"}} {
+		if reloaded.Posted(key[0], key[1]) {
+			t.Errorf("Posted(%s, %s) = true", key[0], key[1])
+		}
+	}
+	if _, ok := reloaded.Get("BOB", "2026-10-14"); !ok {
+		t.Error("a report that didn't go out isn't recorded")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("ledger has mode %o, want 600", perm)
+	}
+}
+
+func TestLedgerCorrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sent.json")
+	if err := os.WriteFile(path, []byte(`{"ALICE":{"2026-10-14":`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadLedger(path); err == nil || !strings.Contains(err.Error(), path+" is corrupted") {
+		t.Errorf("LoadLedger() error = %v, want %s is corrupted", err, path)
+	}
+}
+
+// TestLedgerNoDuplicate checks a report recorded before a restart isn't
+// posted again after it.
+func TestLedgerNoDuplicate(t *testing.T) {
+	_, srv := fakefitbit.NewServer()
+	defer srv.Close()
+
+	slackAPI := &fakeSlack{}
+	slackSrv := httptest.NewServer(slackAPI)
+	defer slackSrv.Close()
+
+	cfg := testConfig(t, srv.URL)
+	c := fakeClient(t, cfg)
+
+	history, err := openHistory(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer history.Close()
+
+	slackClient := slack.New("xoxb-test")
+	slackClient.BaseURL = slackSrv.URL
+
+	u := &User{ID: fakefitbit.UserID, Timezone: "UTC", Channel: "C123"}
+	logger := log.New(log.Writer(), "["+u.ID+"] ", log.Flags())
+	clock := newFakeClock(time.Date(2026, 10, 14, 8, 30, 0, 0, time.UTC))
+	date := "2026-10-14"
+
+	send := func(ledger *Ledger) {
+		t.Helper()
+		done, err := sendSleepReport(cfg, u, c, slackClient, ledger, history, &FakeLLM{}, testGuardrails(t), &UserStatus{}, clock, logger, date)
+		if !done || err != nil {
+			t.Fatalf("sendSleepReport() = %t, %v, want true, nil", done, err)
+		}
+	}
+
+	ledger, err := openLedger(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send(ledger)
+	send(ledger)
+
+	restarted, err := openLedger(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	send(restarted)
+
+	if posts := slackAPI.posts(); len(posts) != 1 {
+		t.Errorf("%d reports posted, want 1", len(posts))
+	}
+	report, _ := restarted.Get(u.ID, date)
+	if !report.PostedAt.Equal(clock.Now()) || report.SlackTS == "" || report.LogID == 0 {
+		t.Errorf("ledger after a restart has %+v, want the post at %s", report, clock.Now())
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// LLM writes the AI parts of the reports. The backends differ in their
+// wire format only, every one of them takes the same messages. A format asks
+// for JSON, nil for text; backends without a way to ask for it rely on the
+// prompt saying so.
+type LLM interface {
+	Complete(ctx context.Context, messages []AiMessage, format *ResponseFormat) (string, error)
+}
+
+// defaultLLMURLs are the endpoints used when ai.base_url is empty, keyed by
+// provider. DeepSeek was the bot's only backend before there was a choice.
+var defaultLLMURLs = map[string]string{
+	"openai":    "https://api.deepseek.com/chat/completions",
+	"anthropic": "https://api.anthropic.com/v1/messages",
+	"ollama":    "http://localhost:11434/api/chat",
+	"fake":      "",
+}
+
+// ErrEmptyCompletion is returned when the API answered without any text.
+var ErrEmptyCompletion = errors.New("empty completion")
+
+// LLMError is a non-2xx reply from an LLM API, with whatever the provider's
+// error envelope said.
+type LLMError struct {
+	Provider   string
+	StatusCode int
+	Type       string // e.g. "rate_limit_error", empty if the body had none
+	Message    string
+	Body       string
+
+	// RetryAfter is how long the API asks to wait, zero if it didn't say.
+	RetryAfter time.Duration
+}
+
+func (e *LLMError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s returned %d: %s", e.Provider, e.StatusCode, e.Body)
+	}
+	if e.Type == "" {
+		return fmt.Sprintf("%s returned %d: %s", e.Provider, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("%s returned %d: %s: %s", e.Provider, e.StatusCode, e.Type, e.Message)
+}
+
+// newLLMError decodes the error envelopes of the supported APIs: OpenAI's
+// and Anthropic's {"error": {"type", "message"}} and Ollama's
+// {"error": "message"}.
+func newLLMError(provider string, statusCode int, body []byte) *LLMError {
+	llmErr := &LLMError{Provider: provider, StatusCode: statusCode, Body: string(body)}
+
+	var envelope struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(body, &envelope) != nil || len(envelope.Error) == 0 {
+		return llmErr
+	}
+
+	var detail struct {
+		Type    string `json:"type"`
+		Code    any    `json:"code"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(envelope.Error, &detail) == nil {
+		llmErr.Type = detail.Type
+		if llmErr.Type == "" && detail.Code != nil {
+			llmErr.Type = fmt.Sprint(detail.Code)
+		}
+		llmErr.Message = detail.Message
+		return llmErr
+	}
+
+	var message string
+	if json.Unmarshal(envelope.Error, &message) == nil {
+		llmErr.Message = message
+	}
+	return llmErr
+}
+
+// newLLM returns the backends in the config chained together: the main one,
+// then the fallbacks, each with its retries.
+func newLLM(cfg *Config) (LLM, error) {
+	// the deadline is set per attempt, see llmChain
+	httpClient := &http.Client{}
+
+	chain := &llmChain{
+		timeout: cfg.AI.Timeout,
+		retries: cfg.AI.Retries,
+		backoff: cfg.AI.RetryBackoff,
+	}
+	for _, b := range cfg.AI.backends() {
+		llm, err := newBackend(b, cfg.AI.MaxTokens, httpClient)
+		if err != nil {
+			return nil, err
+		}
+		chain.backends = append(chain.backends, namedLLM{name: strings.TrimSuffix(b.Provider+"/"+b.Model, "/"), llm: llm})
+	}
+	return chain, nil
+}
+
+// newBackend returns the client of a single backend.
+func newBackend(b AIBackend, maxTokens int, httpClient *http.Client) (LLM, error) {
+	baseURL, ok := defaultLLMURLs[b.Provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown AI provider %q", b.Provider)
+	}
+	if b.BaseURL != "" {
+		baseURL = b.BaseURL
+	}
+
+	api := llmAPI{
+		provider:   b.Provider,
+		url:        baseURL,
+		apiKey:     b.APIKey,
+		httpClient: httpClient,
+	}
+
+	switch b.Provider {
+	case "openai":
+		return &openAILLM{api: api, model: b.Model}, nil
+	case "anthropic":
+		return &anthropicLLM{api: api, model: b.Model, maxTokens: maxTokens}, nil
+	case "ollama":
+		return &ollamaLLM{api: api, model: b.Model}, nil
+	default:
+		return &FakeLLM{}, nil
+	}
+}
+
+// llmAPI is the HTTP side shared by the backends.
+type llmAPI struct {
+	provider   string
+	url        string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// post sends request as JSON with the given headers and decodes a 2xx reply
+// into response. Anything else comes back as an *LLMError.
+func (a *llmAPI) post(ctx context.Context, header http.Header, request, response any) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", a.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", a.provider, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("%s: reading the reply: %w", a.provider, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		llmErr := newLLMError(a.provider, resp.StatusCode, respBody)
+		llmErr.RetryAfter = retryAfter(resp.Header)
+		return llmErr
+	}
+
+	if err := json.Unmarshal(respBody, response); err != nil {
+		return fmt.Errorf("%s: decoding the reply: %w", a.provider, err)
+	}
+	return nil
+}
+
+// completion trims a reply and turns an empty one into ErrEmptyCompletion.
+func completion(provider, text string) (string, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", fmt.Errorf("%s: %w", provider, ErrEmptyCompletion)
+	}
+	return text, nil
+}
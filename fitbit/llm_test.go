@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var testMessages = []AiMessage{
+	{Role: "system", Content: "be mean"},
+	{Role: "system", Content: "SLEEP DATA FOR 2026-10-14"},
+	{Role: "user", Content: "go on"},
+}
+
+// llmServer answers every request with status and body, after handing the
+// request and its body to check.
+func llmServer(t *testing.T, status int, header http.Header, body string, check func(r *http.Request, body []byte)) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got a %s of %s, want a POST of JSON", r.Method, r.Header.Get("Content-Type"))
+		}
+		if check != nil {
+			check(r, data)
+		}
+
+		for name, values := range header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func decode[T any](t *testing.T, data []byte) T {
+	t.Helper()
+
+	var v T
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatalf("decoding %s: %v", data, err)
+	}
+	return v
+}
+
+func TestBackendRequests(t *testing.T) {
+	jsonObject := &ResponseFormat{Type: "json_object"}
+	jsonSchema := &ResponseFormat{Type: "json_schema", JSONSchema: &JSONSchema{Name: "roast", Schema: json.RawMessage(`{"type":"object"}`)}}
+
+	tests := []struct {
+		name     string
+		provider string
+		apiKey   string
+		format   *ResponseFormat
+		reply    string
+		check    func(t *testing.T, r *http.Request, body []byte)
+	}{
+		{
+			name:     "openai",
+			provider: "openai",
+			apiKey:   "sk-test",
+			reply:    `{"choices":[{"message":{"role":"assistant","content":"  a roast\n"}}]}`,
+			check: func(t *testing.T, r *http.Request, body []byte) {
+				if got := r.Header.Get("Authorization"); got != "Bearer sk-test" {
+					t.Errorf("Authorization = %q", got)
+				}
+				req := decode[AiRequest](t, body)
+				if req.Model != "test-model" || len(req.Messages) != 3 || req.ResponseFormat != nil {
+					t.Errorf("request = %+v", req)
+				}
+			},
+		},
+		{
+			name:     "openai json",
+			provider: "openai",
+			format:   jsonSchema,
+			reply:    `{"choices":[{"message":{"role":"assistant","content":"a roast"}}]}`,
+			check: func(t *testing.T, r *http.Request, body []byte) {
+				if got := r.Header.Get("Authorization"); got != "" {
+					t.Errorf("Authorization = %q without a key", got)
+				}
+				req := decode[AiRequest](t, body)
+				if req.ResponseFormat == nil || req.ResponseFormat.Type != "json_schema" || req.ResponseFormat.JSONSchema.Name != "roast" {
+					t.Errorf("response_format = %+v", req.ResponseFormat)
+				}
+			},
+		},
+		{
+			name:     "anthropic",
+			provider: "anthropic",
+			apiKey:   "sk-ant-test",
+			format:   jsonObject,
+			reply:    `{"content":[{"type":"thinking","text":"hmm"},{"type":"text","text":"a "},{"type":"text","text":"roast"}],"stop_reason":"end_turn"}`,
+			check: func(t *testing.T, r *http.Request, body []byte) {
+				if got := r.Header.Get("x-api-key"); got != "sk-ant-test" {
+					t.Errorf("x-api-key = %q", got)
+				}
+				if got := r.Header.Get("anthropic-version"); got != anthropicVersion {
+					t.Errorf("anthropic-version = %q", got)
+				}
+				// the first system message is the prompt, the rest is one user turn
+				req := decode[anthropicRequest](t, body)
+				want := []AiMessage{{Role: "user", Content: "SLEEP DATA FOR 2026-10-14\n\ngo on"}}
+				if req.System != "be mean" || req.MaxTokens != 512 || len(req.Messages) != 1 || req.Messages[0] != want[0] {
+					t.Errorf("request = %+v, want the system prompt apart and %+v", req, want)
+				}
+			},
+		},
+		{
+			name:     "ollama",
+			provider: "ollama",
+			reply:    `{"message":{"role":"assistant","content":"a roast"},"done":true}`,
+			check: func(t *testing.T, r *http.Request, body []byte) {
+				req := decode[ollamaRequest](t, body)
+				if req.Stream || req.Format != nil || len(req.Messages) != 3 {
+					t.Errorf("request = %+v, want 3 messages without streaming or format", req)
+				}
+			},
+		},
+		{
+			name:     "ollama json",
+			provider: "ollama",
+			format:   jsonObject,
+			reply:    `{"message":{"role":"assistant","content":"a roast"},"done":true}`,
+			check: func(t *testing.T, r *http.Request, body []byte) {
+				if req := decode[ollamaRequest](t, body); string(req.Format) != `"json"` {
+					t.Errorf("format = %s, want \"json\"", req.Format)
+				}
+			},
+		},
+		{
+			name:     "ollama json schema",
+			provider: "ollama",
+			apiKey:   "proxy-key",
+			format:   jsonSchema,
+			reply:    `{"message":{"role":"assistant","content":"a roast"},"done":true}`,
+			check: func(t *testing.T, r *http.Request, body []byte) {
+				if got := r.Header.Get("Authorization"); got != "Bearer proxy-key" {
+					t.Errorf("Authorization = %q", got)
+				}
+				if req := decode[ollamaRequest](t, body); string(req.Format) != `{"type":"object"}` {
+					t.Errorf("format = %s, want the schema", req.Format)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := llmServer(t, http.StatusOK, nil, tt.reply, func(r *http.Request, body []byte) {
+				tt.check(t, r, body)
+			})
+
+			llm, err := newBackend(AIBackend{Provider: tt.provider, BaseURL: srv.URL, Model: "test-model", APIKey: tt.apiKey}, 512, srv.Client())
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := llm.Complete(context.Background(), testMessages, tt.format)
+			if err != nil {
+				t.Fatalf("Complete() error = %v", err)
+			}
+			if got != "a roast" {
+				t.Errorf("Complete() = %q, want %q", got, "a roast")
+			}
+		})
+	}
+}
+
+func TestBackendErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		status   int
+		header   http.Header
+		body     string
+		want     *LLMError // nil for an error that isn't an *LLMError
+		empty    bool
+	}{
+		{
+			name:     "openai rate limit",
+			provider: "openai",
+			status:   http.StatusTooManyRequests,
+			header:   http.Header{"Retry-After": {"7"}},
+			body:     `{"error":{"message":"Rate limit reached","type":"requests","code":"rate_limit_exceeded"}}`,
+			want:     &LLMError{Provider: "openai", StatusCode: 429, Type: "requests", Message: "Rate limit reached", RetryAfter: 7 * time.Second},
+		},
+		{
+			name:     "openai code only",
+			provider: "openai",
+			status:   http.StatusUnauthorized,
+			body:     `{"error":{"message":"Invalid API key","code":"invalid_api_key"}}`,
+			want:     &LLMError{Provider: "openai", StatusCode: 401, Type: "invalid_api_key", Message: "Invalid API key"},
+		},
+		{
+			name:     "anthropic overloaded",
+			provider: "anthropic",
+			status:   529,
+			body:     `{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+			want:     &LLMError{Provider: "anthropic", StatusCode: 529, Type: "overloaded_error", Message: "Overloaded"},
+		},
+		{
+			name:     "ollama missing model",
+			provider: "ollama",
+			status:   http.StatusNotFound,
+			body:     `{"error":"model \"llama3\" not found, try pulling it first"}`,
+			want:     &LLMError{Provider: "ollama", StatusCode: 404, Message: `model "llama3" not found, try pulling it first`},
+		},
+		{
+			name:     "proxy error page",
+			provider: "openai",
+			status:   http.StatusBadGateway,
+			body:     `<html>bad gateway</html>`,
+			want:     &LLMError{Provider: "openai", StatusCode: 502},
+		},
+		{
+			name:     "openai no choices",
+			provider: "openai",
+			status:   http.StatusOK,
+			body:     `{"choices":[]}`,
+			empty:    true,
+		},
+		{
+			name:     "anthropic blank text",
+			provider: "anthropic",
+			status:   http.StatusOK,
+			body:     `{"content":[{"type":"text","text":"  \n"}]}`,
+			empty:    true,
+		},
+		{
+			name:     "not json",
+			provider: "ollama",
+			status:   http.StatusOK,
+			body:     `not json`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := llmServer(t, tt.status, tt.header, tt.body, nil)
+
+			llm, err := newBackend(AIBackend{Provider: tt.provider, BaseURL: srv.URL, Model: "test-model"}, 512, srv.Client())
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = llm.Complete(context.Background(), testMessages, nil)
+			if err == nil {
+				t.Fatal("Complete() error = nil")
+			}
+			if got := errors.Is(err, ErrEmptyCompletion); got != tt.empty {
+				t.Errorf("errors.Is(%v, ErrEmptyCompletion) = %t, want %t", err, got, tt.empty)
+			}
+
+			var llmErr *LLMError
+			if !errors.As(err, &llmErr) {
+				if tt.want != nil {
+					t.Fatalf("Complete() error = %v, want an *LLMError", err)
+				}
+				return
+			}
+			if tt.want == nil {
+				t.Fatalf("Complete() error = %v, want something else than an *LLMError", err)
+			}
+			tt.want.Body = tt.body
+			if *llmErr != *tt.want {
+				t.Errorf("Complete() error = %+v, want %+v", llmErr, tt.want)
+			}
+		})
+	}
+}
+
+func TestLLMErrorMessage(t *testing.T) {
+	tests := []struct {
+		err  *LLMError
+		want string
+	}{
+		{&LLMError{Provider: "openai", StatusCode: 502, Body: "bad gateway"}, "openai returned 502: bad gateway"},
+		{&LLMError{Provider: "ollama", StatusCode: 404, Message: "no model"}, "ollama returned 404: no model"},
+		{&LLMError{Provider: "anthropic", StatusCode: 529, Type: "overloaded_error", Message: "Overloaded"}, "anthropic returned 529: overloaded_error: Overloaded"},
+	}
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("Error() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestUnknownProvider(t *testing.T) {
+	if _, err := newBackend(AIBackend{Provider: "clippy"}, 512, http.DefaultClient); err == nil {
+		t.Error("newBackend() of an unknown provider, error = nil")
+	}
+}
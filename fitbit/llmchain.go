@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// namedLLM is a backend of the chain, named after its provider and model
+// for the logs.
+type namedLLM struct {
+	name string
+	llm  LLM
+}
+
+// llmChain tries its backends in order until one answers. Each attempt gets
+// its own deadline, and a backend that is rate limited or having a bad
+// time (429 or 5xx) is tried again after a growing pause before moving on.
+// Other errors, timeouts included, go straight to the next backend: a
+// model that hung once will likely hang again.
+type llmChain struct {
+	backends []namedLLM
+	timeout  time.Duration
+	retries  int
+	backoff  time.Duration
+}
+
+func (c *llmChain) Complete(ctx context.Context, messages []AiMessage, format *ResponseFormat) (string, error) {
+	var errs []error
+	for i, b := range c.backends {
+		text, err := c.try(ctx, b, messages, format)
+		if err == nil {
+			return text, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", b.name, err))
+		if ctx.Err() != nil {
+			break
+		}
+		if i+1 < len(c.backends) {
+			log.Printf("AI backend %s failed, falling back to %s: %v", b.name, c.backends[i+1].name, err)
+		}
+	}
+	return "", errors.Join(errs...)
+}
+
+// try asks a single backend, retrying what is worth retrying.
+func (c *llmChain) try(ctx context.Context, b namedLLM, messages []AiMessage, format *ResponseFormat) (string, error) {
+	wait := c.backoff
+	for attempt := 0; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, c.timeout)
+		text, err := b.llm.Complete(attemptCtx, messages, format)
+		cancel()
+		if err == nil || attempt >= c.retries || !retryableLLMError(err) {
+			return text, err
+		}
+
+		delay := wait
+		var llmErr *LLMError
+		if errors.As(err, &llmErr) && llmErr.RetryAfter > delay {
+			// no point waiting longer than a whole attempt, the
+			// fallbacks might be free right now
+			if llmErr.RetryAfter > c.timeout {
+				return "", err
+			}
+			delay = llmErr.RetryAfter
+		}
+
+		log.Printf("AI backend %s failed, retrying in %s: %v", b.name, delay, err)
+		select {
+		case <-ctx.Done():
+			return "", errors.Join(err, ctx.Err())
+		case <-time.After(delay):
+		}
+		wait *= 2
+	}
+}
+
+// retryableLLMError reports whether err is a 429 or a 5xx.
+func retryableLLMError(err error) bool {
+	var llmErr *LLMError
+	if !errors.As(err, &llmErr) {
+		return false
+	}
+	return llmErr.StatusCode == http.StatusTooManyRequests || llmErr.StatusCode >= 500
+}
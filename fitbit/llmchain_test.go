@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+// llmFunc is an LLM made of a function.
+type llmFunc func(ctx context.Context, messages []AiMessage, format *ResponseFormat) (string, error)
+
+func (f llmFunc) Complete(ctx context.Context, messages []AiMessage, format *ResponseFormat) (string, error) {
+	return f(ctx, messages, format)
+}
+
+// hungLLM never answers, it only gives up with its context.
+var hungLLM = llmFunc(func(ctx context.Context, _ []AiMessage, _ *ResponseFormat) (string, error) {
+	<-ctx.Done()
+	return "", ctx.Err()
+})
+
+func statusError(status int) error {
+	return &LLMError{Provider: "fake", StatusCode: status}
+}
+
+func TestLLMChain(t *testing.T) {
+	tests := []struct {
+		name     string
+		main     *FakeLLM
+		fallback *FakeLLM
+		want     string
+		// how many requests each backend got
+		mainCalls, fallbackCalls int
+	}{
+		{
+			name:      "first try",
+			main:      &FakeLLM{Reply: "main"},
+			fallback:  &FakeLLM{Reply: "fallback"},
+			want:      "main",
+			mainCalls: 1,
+		},
+		{
+			name:      "retried after a 429",
+			main:      &FakeLLM{Errs: []error{statusError(429)}, Reply: "main"},
+			fallback:  &FakeLLM{Reply: "fallback"},
+			want:      "main",
+			mainCalls: 2,
+		},
+		{
+			name:      "retried after 5xx",
+			main:      &FakeLLM{Errs: []error{statusError(500), statusError(503)}, Reply: "main"},
+			fallback:  &FakeLLM{Reply: "fallback"},
+			want:      "main",
+			mainCalls: 3,
+		},
+		{
+			name:          "out of retries",
+			main:          &FakeLLM{Errs: []error{statusError(502), statusError(502), statusError(502)}, Reply: "main"},
+			fallback:      &FakeLLM{Reply: "fallback"},
+			want:          "fallback",
+			mainCalls:     3,
+			fallbackCalls: 1,
+		},
+		{
+			name:          "not retried after a 4xx",
+			main:          &FakeLLM{Err: statusError(400)},
+			fallback:      &FakeLLM{Reply: "fallback"},
+			want:          "fallback",
+			mainCalls:     1,
+			fallbackCalls: 1,
+		},
+		{
+			name:          "not retried after an empty completion",
+			main:          &FakeLLM{Err: fmt.Errorf("fake: %w", ErrEmptyCompletion)},
+			fallback:      &FakeLLM{Reply: "fallback"},
+			want:          "fallback",
+			mainCalls:     1,
+			fallbackCalls: 1,
+		},
+		{
+			name:          "Retry-After longer than an attempt",
+			main:          &FakeLLM{Errs: []error{&LLMError{Provider: "fake", StatusCode: 429, RetryAfter: time.Hour}}, Reply: "main"},
+			fallback:      &FakeLLM{Reply: "fallback"},
+			want:          "fallback",
+			mainCalls:     1,
+			fallbackCalls: 1,
+		},
+		{
+			name:          "fallback retried too",
+			main:          &FakeLLM{Err: statusError(401)},
+			fallback:      &FakeLLM{Errs: []error{statusError(529)}, Reply: "fallback"},
+			want:          "fallback",
+			mainCalls:     1,
+			fallbackCalls: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := &llmChain{
+				backends: []namedLLM{{name: "main", llm: tt.main}, {name: "fallback", llm: tt.fallback}},
+				timeout:  time.Second,
+				retries:  2,
+				backoff:  time.Millisecond,
+			}
+
+			got, err := chain.Complete(context.Background(), testMessages, nil)
+			if err != nil {
+				t.Fatalf("Complete() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Complete() = %q, want %q", got, tt.want)
+			}
+			if n := len(tt.main.Requests()); n != tt.mainCalls {
+				t.Errorf("main got %d requests, want %d", n, tt.mainCalls)
+			}
+			if n := len(tt.fallback.Requests()); n != tt.fallbackCalls {
+				t.Errorf("fallback got %d requests, want %d", n, tt.fallbackCalls)
+			}
+		})
+	}
+}
+
+func TestLLMChainRetryAfter(t *testing.T) {
+	main := &FakeLLM{Errs: []error{&LLMError{Provider: "fake", StatusCode: 429, RetryAfter: 50 * time.Millisecond}}, Reply: "main"}
+	chain := &llmChain{
+		backends: []namedLLM{{name: "main", llm: main}},
+		timeout:  time.Second,
+		retries:  1,
+		backoff:  time.Millisecond,
+	}
+
+	start := time.Now()
+	if _, err := chain.Complete(context.Background(), testMessages, nil); err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Errorf("retried after %s, want at least the 50ms of Retry-After", waited)
+	}
+}
+
+func TestLLMChainTimeout(t *testing.T) {
+	fallback := &FakeLLM{Reply: "fallback"}
+	chain := &llmChain{
+		backends: []namedLLM{{name: "main", llm: hungLLM}, {name: "fallback", llm: fallback}},
+		timeout:  20 * time.Millisecond,
+		retries:  2,
+		backoff:  time.Millisecond,
+	}
+
+	start := time.Now()
+	got, err := chain.Complete(context.Background(), testMessages, nil)
+	if err != nil || got != "fallback" {
+		t.Fatalf("Complete() = %q, %v, want the fallback's reply", got, err)
+	}
+	// a timeout isn't retried
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("took %s to fall back", waited)
+	}
+}
+
+func TestLLMChainAllFail(t *testing.T) {
+	main := &FakeLLM{Err: statusError(503)}
+	fallback := &FakeLLM{Err: statusError(400)}
+	chain := &llmChain{
+		backends: []namedLLM{{name: "main", llm: main}, {name: "fallback", llm: fallback}},
+		timeout:  time.Second,
+		retries:  2,
+		backoff:  time.Millisecond,
+	}
+
+	_, err := chain.Complete(context.Background(), testMessages, nil)
+	if err == nil {
+		t.Fatal("Complete() error = nil")
+	}
+	for _, name := range []string{"main: fake returned 503", "fallback: fake returned 400"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("Complete() error = %q, want it to mention %q", err, name)
+		}
+	}
+	if n := len(main.Requests()); n != 3 {
+		t.Errorf("main got %d requests, want 3", n)
+	}
+}
+
+// TestWriteRoastCanned checks the report still gets a roast when every
+// backend failed.
+func TestWriteRoastCanned(t *testing.T) {
+	cfg := defaultConfig()
+	logger := log.New(log.Writer(), "[test] ", log.Flags())
+	persona := reportPersona(cfg, nil, "2026-10-14", logger)
+	data := SleepLogData{Date: "2026-10-14", MinutesAsleep: 300, TotalAsleep: 300, MinutesToFallAsleep: 45}
+
+	chain := &llmChain{
+		backends: []namedLLM{{name: "main", llm: &FakeLLM{Err: statusError(500)}}, {name: "fallback", llm: &FakeLLM{Err: errors.New("connection refused")}}},
+		timeout:  time.Second,
+		retries:  1,
+		backoff:  time.Millisecond,
+	}
+
+	roast := writeRoast(cfg, chain, testGuardrails(t), persona, data, 8, logger)
+	if want := cannedRoast(data, 8); roast.Roast != want {
+		t.Errorf("roast = %q, want the canned %q", roast.Roast, want)
+	}
+	if roast.Roast == "" {
+		t.Error("the canned roast is empty")
+	}
+}
@@ -1,43 +1,56 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
-	"github.com/go-chi/chi/v5"
+	"fitbit-workflow/fakefitbit"
+
+	"github.com/espcaa/random-workflows-that-actually-are-bots/slack"
 	"github.com/joho/godotenv"
 )
 
-var aiBaseUrl string = "https://api.deepseek.com/chat/completions"
-var aiModel string = "deepseek-v4-flash"
-
 type SecretClient struct {
 	ClientID      string
 	Secret        string
 	CodeVerifier  string
 	CodeChallenge string
 	CallbackURL   string
+	Scopes        []string
 }
 
 type FitbitClient struct {
 	AccessToken  string       `json:"access_token"`
 	RefreshToken string       `json:"refresh_token"`
 	ExpiresIn    int64        `json:"expires_in"`
+	ExpiresAt    time.Time    `json:"expires_at"`
 	TokenType    string       `json:"token_type"`
 	UserID       string       `json:"user_id"`
+	Scope        string       `json:"scope"`
 	SecretClient SecretClient `json:"-"`
 	GoalHours    float64
-}
 
-var callbackUrl string = "https://fitbit.hackclub.cc/callback"
+	// BaseURL is the Fitbit API root, fitbit.api_url in the config, so the
+	// bot can run against a fake server or a recording proxy.
+	BaseURL    string       `json:"-"`
+	HTTPClient *http.Client `json:"-"`
+
+	// Store is where refreshed tokens are persisted.
+	Store TokenStore `json:"-"`
+
+	// mu serializes token refreshes and guards the token fields above.
+	mu sync.Mutex
+}
 
 func main() {
 
@@ -52,111 +65,164 @@ func main() {
 
 	if len(args) == 0 {
 		// start the program as usual
-		startApp(false)
+		startApp(loadConfigOrDie(), false)
 	} else if args[0] == "setup" {
-		// start a chi server
-
-		client := newSecretClient()
-
-		// print the url to visit
+		// start a chi server with just the linking pages
 
-		var port = os.Getenv("PORT")
-		if port == "" {
-			port = "8080"
-		}
+		cfg := loadConfigOrDie()
 
-		if os.Getenv("FITBIT_CALLBACK_URL") != "" {
-			client.CallbackURL = os.Getenv("FITBIT_CALLBACK_URL")
-		} else {
-			client.CallbackURL = "https://fitbit.hackclub.cc/callback"
+		registry, err := openRegistry(cfg)
+		if err != nil {
+			log.Fatal(err)
 		}
 
-		callbackUrl = client.CallbackURL
+		s := newServer(cfg, registry, *newSecretClient(cfg), nil)
 
-		v := url.Values{}
-		v.Set("client_id", client.ClientID)
-		v.Set("response_type", "code")
-		v.Set("code_challenge", client.CodeChallenge)
-		v.Set("code_challenge_method", "S256")
-		v.Set("scope", "activity heartrate location nutrition oxygen_saturation profile respiratory_rate settings sleep social temperature weight")
-		v.Set("callback_uri", callbackUrl)
-		v.Set("redirect_uri", callbackUrl)
+		// print the url to visit
 
-		loginURL := "https://www.fitbit.com/oauth2/authorize?" + v.Encode()
+		loginURL, err := s.logins.Begin(s.secret)
+		if err != nil {
+			log.Fatal(err)
+		}
 
 		fmt.Println(loginURL)
 
 		log.Println("Visit the following URL to authorize the application:")
 		log.Println(loginURL)
+		log.Println("It works once, more accounts can be linked from", s.loginPageURL())
 
-		r := chi.NewRouter()
+		http.ListenAndServe(":"+cfg.Server.Port, s.routes())
+	} else if args[0] == "serve" {
+		serve(loadConfigOrDie())
+	} else if args[0] == "test" {
+		startApp(loadConfigOrDie(), true)
+	} else if args[0] == "tokens" {
+		// the token tools don't need the Fitbit credentials, so the config
+		// isn't validated
+		cfg, err := LoadConfig()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := tokensCommand(cfg, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+	} else if args[0] == "config" {
+		if err := configCommand(args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	} else if args[0] == "subscribe" {
+		// register every user's sleep subscription for the webhook
+		if err := subscribeCommand(loadConfigOrDie()); err != nil {
+			log.Fatal(err)
+		}
+	} else if args[0] == "backfill" {
+		// import older nights into the history database
+		if err := backfillCommand(loadConfigOrDie(), args[1:]); err != nil {
+			log.Fatal(err)
+		}
+	} else if args[0] == "digest" {
+		// post a digest right away, to try it out
+		if err := digestCommand(loadConfigOrDie(), args[1:]); err != nil {
+			log.Fatal(err)
+		}
+	} else if args[0] == "personas" {
+		// list the personas, or try one on a stored night
+		if err := personasCommand(loadConfigOrDie(), args[1:]); err != nil {
+			log.Fatal(err)
+		}
+	} else if args[0] == "fake-fitbit" {
+		var port = os.Getenv("PORT")
+		if port == "" {
+			port = "9000"
+		}
 
-		r.Get("/callback", func(w http.ResponseWriter, r *http.Request) {
-			HandleFitbitCallback(w, r, client)
-		})
+		log.Println("Serving fake Fitbit API on :" + port)
+		log.Println("Run the bot against it with FITBIT_API_URL=http://localhost:" + port + " and the tokens from fakefitbit/fixtures/tokens.json")
 
-		http.ListenAndServe(":"+port, r)
-	} else if args[0] == "test" {
-		startApp(true)
+		fake := fakefitbit.New()
+		// FAKE_FITBIT_NAP=1 adds a second session to every day
+		fake.SetNap(os.Getenv("FAKE_FITBIT_NAP") != "")
+		// FAKE_FITBIT_RATE_LIMIT=150 allows that many calls an hour, like Fitbit
+		if limit, err := strconv.Atoi(os.Getenv("FAKE_FITBIT_RATE_LIMIT")); err == nil {
+			fake.SetRateLimit(limit, time.Hour)
+		}
+
+		log.Fatal(http.ListenAndServe(":"+port, fake.Handler()))
 	} else {
-		fmt.Println("Usage: go run . [setup|test|nothing]")
+		fmt.Println("Usage: go run . [serve|setup|test|tokens|config check|subscribe|backfill|digest weekly|digest monthly|personas list|personas preview|fake-fitbit|nothing]")
 	}
 }
 
-func newFitbitClientFromJSON(data []byte) (*FitbitClient, error) {
-	var client = &FitbitClient{}
-
-	err := json.Unmarshal(data, client)
+func newFitbitClient(cfg *Config, secret SecretClient, store TokenStore) *FitbitClient {
+	return &FitbitClient{
+		SecretClient: secret,
+		GoalHours:    cfg.Report.GoalHours,
+		BaseURL:      strings.TrimSuffix(cfg.Fitbit.APIURL, "/"),
+		HTTPClient:   &http.Client{Timeout: 30 * time.Second},
+		Store:        store,
+	}
+}
 
+// newFitbitClientFromStore builds a client from the token saved in store.
+func newFitbitClientFromStore(cfg *Config, store TokenStore, secret SecretClient) (*FitbitClient, error) {
+	token, err := store.Load()
 	if err != nil {
 		return nil, err
 	}
 
-	client.GoalHours = 8.0 // default to 8 hours
+	var client = newFitbitClient(cfg, secret, store)
+	applyToken(client, token)
 
 	return client, nil
 }
 
-func newSecretClient() *SecretClient {
-
-	// generate the code verifier and code challenge
+// newSecretClient returns the app credentials. The PKCE verifier and
+// challenge are filled in per linking attempt, see pendingLogins.
+func newSecretClient(cfg *Config) *SecretClient {
+	return &SecretClient{
+		ClientID:    cfg.Fitbit.ClientID,
+		Secret:      cfg.Fitbit.ClientSecret,
+		CallbackURL: cfg.Fitbit.CallbackURL,
+		Scopes:      requiredScopes(cfg.EnabledFeatures()),
+	}
+}
 
-	var code, err = GenerateCodeVerifier(43)
+func startApp(cfg *Config, runTest bool) {
 
+	registry, err := openRegistry(cfg)
 	if err != nil {
-		panic(err)
+		log.Fatal("Error loading users: ", err)
 	}
 
-	var challenge = GenerateCodeChallenge(code)
-
-	return &SecretClient{
-		CodeVerifier:  code,
-		CodeChallenge: challenge,
-		ClientID:      os.Getenv("FITBIT_CLIENT_ID"),
-		Secret:        os.Getenv("FITBIT_CLIENT_SECRET"),
+	if len(registry.Users()) == 0 {
+		log.Fatal("no Fitbit account linked yet, please run 'go run . setup' first")
 	}
-}
-
-func startApp(runTest bool) {
 
-	// check if tokens.json exists
-
-	if _, err := os.Stat("tokens.json"); os.IsNotExist(err) {
-		log.Fatal("tokens.json not found, please run 'go run main.go setup' first")
+	ledger, err := openLedger(cfg)
+	if err != nil {
+		log.Fatal("Error loading sent reports: ", err)
 	}
 
-	tokenFile, err := os.ReadFile("tokens.json")
+	history, err := openHistory(cfg)
+	if err != nil {
+		log.Fatal("Error opening the sleep history: ", err)
+	}
+	defer history.Close()
 
-	client, err := newFitbitClientFromJSON(tokenFile)
-	client.SecretClient = *newSecretClient()
+	llm, err := newLLM(cfg)
+	if err != nil {
+		log.Fatal("Error setting up the AI provider: ", err)
+	}
 
+	guardrails, err := loadGuardrails(cfg)
 	if err != nil {
-		log.Fatal(err)
+		log.Fatal("Error loading the guardrails: ", err)
 	}
 
 	log.Println("running this janky bot")
 
-	runBot(client, runTest)
+	runBot(cfg, registry, ledger, history, llm, guardrails, *newSecretClient(cfg), runTest)
 }
 
 func checkNewSleepData(client *FitbitClient) bool {
@@ -176,151 +242,269 @@ func checkNewSleepData(client *FitbitClient) bool {
 	}
 }
 
-func runBot(c *FitbitClient, runTest bool) {
+// runBot runs the daily report loop of every registered user side by side,
+// so a failing account doesn't hold up the others.
+func runBot(cfg *Config, registry *Registry, ledger *Ledger, history *History, llm LLM, guardrails *Guardrails, secret SecretClient, runTest bool) {
+	bot := newBot(cfg, registry, ledger, history, llm, guardrails, secret, realClock{}, runTest)
+	if err := bot.StartAll(); err != nil {
+		log.Fatal("No Fitbit account could start:\n", err)
+	}
+	bot.Wait()
+}
+
+func runUserBot(cfg *Config, u *User, c *FitbitClient, slackClient *slack.Client, ledger *Ledger, history *History, llm LLM, guardrails *Guardrails, status *UserStatus, notify <-chan string, clock Clock, runTest bool) {
 
-	refreshTicker := time.NewTicker(6 * time.Hour)
-	defer refreshTicker.Stop()
+	logger := log.New(log.Writer(), "["+u.ID+"] ", log.Flags())
+
+	// API calls refresh on demand, this just keeps an idle bot's token from
+	// going stale
+	stop := make(chan struct{})
+	defer close(stop)
 
 	go func() {
-		for range refreshTicker.C {
-			if err := refreshToken(c); err != nil {
-				log.Println("Error refreshing token:", err)
+		for {
+			select {
+			case <-clock.After(tokenRefreshInterval):
+			case <-stop:
+				return
+			}
+			if err := ensureFreshToken(c); err != nil {
+				logger.Println("Error refreshing token:", err)
 			}
 		}
 	}()
 
-	var lastSentDate string
+	loc := u.Location()
+
+	schedule, err := newReportSchedule(cfg, loc)
+	if err != nil {
+		logger.Println("Error parsing the report schedule:", err)
+		return
+	}
+
+	posted := func(date string) bool { return ledger.Posted(u.ID, date) }
 
 	for {
-		now := time.Now()
-		next5am := time.Date(now.Year(), now.Month(), now.Day(), 5, 0, 0, 0, now.Location())
-		if now.After(next5am) {
-			next5am = next5am.Add(24 * time.Hour)
+		start := schedule.NextWindow(clock.Now(), posted)
+		if runTest {
+			logger.Println("Test mode: skipping the wait until", start)
+			start = clock.Now().In(loc)
+			runTest = false
 		}
-		timeUntilNext5am := next5am.Sub(now)
-		log.Println("Sleeping until 5 AM:", next5am)
 
-		if !runTest {
-			time.Sleep(timeUntilNext5am)
-		} else {
-			log.Println("Test mode: skipping sleep until 5 AM")
+		logger.Println("Sleeping until", start)
+		status.scheduled(start)
+		if wait := start.Sub(clock.Now()); wait > 0 {
+			select {
+			case <-clock.After(wait):
+			case date := <-notify:
+				// a night synced outside the window is reported right away
+				if !recentNight(clock.Now().In(loc), date) || posted(date) {
+					continue
+				}
+				logger.Println("Fitbit says sleep synced for", date+", reporting it now")
+				_, err := sendSleepReport(cfg, u, c, slackClient, ledger, history, llm, guardrails, status, clock, logger, date)
+				if errors.Is(err, ErrScopeNotGranted) {
+					logger.Println("Sleep access was revoked, stopping until the account is linked again:", err)
+					return
+				}
+				if err != nil {
+					logger.Println("Error sending the report, waiting for the window -", err)
+				}
+				continue
+			}
 		}
 
-		for {
-			today := time.Now().Format("2006-01-02")
-			if todayHour := time.Now().Hour(); todayHour > 22 {
+		date := start.Format(dateLayout)
+		deadline := start.Add(cfg.Schedule.Window)
+		retry := newBackoff(cfg)
+
+		// the window polls right away, older notifications don't matter
+		select {
+		case <-notify:
+		default:
+		}
+
+		for clock.Now().Before(deadline) {
+			done, err := sendSleepReport(cfg, u, c, slackClient, ledger, history, llm, guardrails, status, clock, logger, date)
+			if errors.Is(err, ErrScopeNotGranted) {
+				logger.Println("Sleep access was revoked, stopping until the account is linked again:", err)
+				return
+			}
+			if done {
 				break
 			}
 
-			sleepData, err := getSleep(c, today)
+			var wait time.Duration
 			if err != nil {
-				log.Println("Error getting sleep data:", err)
-
-				lastSentDate = today
-				continue
+				wait = retry.Next()
+				logger.Println("Error sending the report, retrying in", wait, "-", err)
+			} else {
+				retry.Reset()
+				wait = cfg.Schedule.PollInterval
+				logger.Println("No sleep data yet, retrying in", wait)
 			}
 
-			if len(sleepData.Sleep) == 0 {
-				log.Println("No sleep data yet, retrying in 1 hour")
-				time.Sleep(1 * time.Hour)
-				continue
+			// don't sleep past the end of the window, and stop waiting as
+			// soon as Fitbit says new sleep synced
+			wait = min(wait, deadline.Sub(clock.Now()))
+			status.scheduled(clock.Now().Add(wait))
+			select {
+			case <-clock.After(wait):
+			case synced := <-notify:
+				logger.Println("Fitbit says sleep synced for", synced+", checking now")
 			}
+		}
+	}
+}
 
-			if today != lastSentDate {
-				var totalSleepMillis int64
-				start := sleepData.Sleep[0].StartTime
-				end := sleepData.Sleep[0].EndTime
-				for _, s := range sleepData.Sleep {
-					totalSleepMillis += s.Duration
-					if s.StartTime < start {
-						start = s.StartTime
-					}
-					if s.EndTime > end {
-						end = s.EndTime
-					}
-				}
+// sendSleepReport posts the report for date unless it already went out.
+// done is false with a nil error while Fitbit has no sleep for date yet.
+func sendSleepReport(cfg *Config, u *User, c *FitbitClient, slackClient *slack.Client, ledger *Ledger, history *History, llm LLM, guardrails *Guardrails, status *UserStatus, clock Clock, logger *log.Logger, date string) (done bool, err error) {
+	// the ledger survives restarts, unlike a variable
+	if ledger.Posted(u.ID, date) {
+		logger.Println("Already sent sleep data for", date)
+		return true, nil
+	}
 
-				const fitbitTimeLayout = "2006-01-02T15:04:05.000"
+	loc := u.Location()
 
-				startTime, err := time.Parse(fitbitTimeLayout, sleepData.Sleep[0].StartTime)
-				if err != nil {
-					log.Println("Error parsing start time:", err)
-					startTime = time.Now()
-				}
+	sleepData, err := getSleep(c, date)
+	status.fetched(err)
+	if err != nil {
+		return false, fmt.Errorf("getting sleep data: %w", err)
+	}
 
-				endTime, err := time.Parse(fitbitTimeLayout, sleepData.Sleep[0].EndTime)
-				if err != nil {
-					log.Println("Error parsing end time:", err)
-					endTime = time.Now()
-				}
+	if len(sleepData.Sleep) == 0 {
+		return false, nil
+	}
 
-				startStr := startTime.Format("3:04 PM")
-				endStr := endTime.Format("3:04 PM")
-				hours := float64(totalSleepMillis) / (1000 * 60 * 60)
-				hoursStr := fmt.Sprintf("%.1f", hours)
+	if err := history.SaveSleep(u.ID, date, date, sleepData); err != nil {
+		logger.Println("Error saving the night to the history:", err)
+	}
 
-				messageText := fmt.Sprintf("I slept from %s -> %s for a total of %s hours!", startStr, endStr, hoursStr)
-				msg := SlackMessage{
-					Channel: os.Getenv("SLACK_CHANNEL_ID"),
-					Text:    messageText,
-				}
+	night := newNight(date, sleepData.Sleep)
+	mainSleep := night.Main
 
-				// generate the ai rambling
-				var aiMessage string
+	messageText := fmt.Sprintf("I slept from %s -> %s for a total of %.1f hours!", mainSleep.Start().Format("3:04 PM"), mainSleep.End().Format("3:04 PM"), mainSleep.Hours())
+	if len(night.Naps) > 0 {
+		var naps []string
+		for _, nap := range night.Naps {
+			naps = append(naps, fmt.Sprintf("%s -> %s", nap.Start().Format("3:04 PM"), nap.End().Format("3:04 PM")))
+		}
+		napWord := "a nap"
+		if len(naps) > 1 {
+			napWord = fmt.Sprintf("%d naps", len(naps))
+		}
+		messageText += fmt.Sprintf("\nPlus %s (%s), %.1f hours overall.", napWord, strings.Join(naps, ", "), night.TotalHours())
+	}
+	msg := slack.Message{
+		Channel:  u.Channel,
+		ThreadTS: u.ThreadTS,
+		Text:     messageText,
+	}
 
-				// grab last 5 days for history context
-				rangeStart := time.Now().AddDate(0, 0, -5).Format("2006-01-02")
-				rangeEnd := time.Now().Format("2006-01-02")
-				rangeData, err := getSleepRange(c, rangeStart, rangeEnd)
-				if err != nil {
-					log.Println("Error getting sleep range data:", err)
-				}
+	// generate the ai rambling
 
-				sleepLogData := MakeSleepLogData(sleepData, rangeData)
-				sleepLogDataMessage, err := FormatSleepLog(sleepLogData)
-				if err != nil {
-					log.Println("Error formatting sleep log data:", err)
-					// Fallback or early return/continue if you don't want to send a blank prompt
-				}
+	// grab the last few days for history context
+	day, _ := time.ParseInLocation(dateLayout, date, loc)
+	rangeStart := day.AddDate(0, 0, -cfg.Report.HistoryDays).Format(dateLayout)
+	// tonight is already in the history, only the nights before count
+	rangeEnd := day.AddDate(0, 0, -1).Format(dateLayout)
+	rangeData, err := loadSleepHistory(history, c, u.ID, rangeStart, rangeEnd)
+	if err != nil {
+		logger.Println("Error getting sleep range data:", err)
+	}
 
-				log.Println("Generating AI rambling...")
-				promptMessages := []AiMessage{
-					{
-						Role:    "system",
-						Content: GetSystemPrompt(),
-					},
-					{
-						Role:    "system",
-						Content: sleepLogDataMessage,
-					},
-				}
+	sleepLogData := MakeSleepLogData(night, rangeData, cfg.Report.HistoryDays)
 
-				log.Println("Sleep log message:", sleepLogDataMessage)
-				aiResponse, err := Complete(promptMessages, aiModel, aiBaseUrl)
-				if err != nil {
-					log.Println("Error generating AI message:", err)
+	// the metrics look further back than the history
+	var metricsData *FitbitSleepResponse
+	if cfg.Report.MetricsDays > 1 {
+		metricsStart := day.AddDate(0, 0, 1-cfg.Report.MetricsDays).Format(dateLayout)
+		metricsData, err = loadSleepHistory(history, c, u.ID, metricsStart, rangeEnd)
+		if err != nil {
+			logger.Println("Error getting sleep history for the metrics:", err)
+		}
+	}
+	nightMetrics := sleepMetrics(night, metricsData, c.GoalHours)
+	sleepLogData.Metrics = &nightMetrics
+	msg.Text += "\n" + metricsSummary(nightMetrics)
+
+	if featureOn(cfg, c, FeatureHeartRate) {
+		heart, err := getHeartData(c, night)
+		if err != nil {
+			logger.Println("Error getting heart rate data:", err)
+		}
+		if heart != nil {
+			sleepLogData.Heart = heart
+			if line := heart.Summary(); line != "" {
+				msg.Text += "\n" + line
+			}
+		}
+	}
 
-				} else {
-					log.Println("AI response:", aiResponse)
-					aiMessage = aiResponse
-					msg.Text += "\n\n" + aiMessage
-				}
+	vitals, err := getVitals(cfg, c, date)
+	if err != nil {
+		logger.Println("Error getting vitals:", err)
+	}
+	sleepLogData.Vitals = vitals
+	if line := vitalsSummary(vitals); line != "" {
+		msg.Text += "\n" + line
+	}
 
-				bar := generateSleepBar(totalSleepMillis, c.GoalHours)
-				msg.Text += "\n\n" + fmt.Sprintf("`%s` (%.1fh/%.1fh)", bar, hours, c.GoalHours)
+	if featureOn(cfg, c, FeatureActivity) {
+		activity, err := getActivityData(c, night)
+		if err != nil {
+			logger.Println("Error getting activity data:", err)
+		}
+		if activity != nil {
+			sleepLogData.Activity = activity
+			msg.Text += "\n" + activity.Summary()
+		}
+	}
 
-				if err := sendSlackMessage(msg); err != nil {
-					log.Println("Error sending Slack message:", err)
-				}
+	persona := reportPersona(cfg, u, date, logger)
+	logger.Println("Generating AI rambling as", persona.Name+"...")
+	roast := writeRoast(cfg, llm, guardrails, persona, sleepLogData, c.GoalHours, logger)
+	if roast.Headline != "" {
+		msg.Text = "*" + roast.Headline + "*\n" + msg.Text
+	}
+	msg.Text += "\n\n" + roast.Roast
+	if roast.PositiveNote != "" {
+		msg.Text += "\n:sparkles: " + roast.PositiveNote
+	}
 
-				lastSentDate = today
-			} else {
-				log.Println("Already sent sleep data for today")
-			}
+	bar := generateSleepBar(night.TotalMillis, c.GoalHours)
+	msg.Text += "\n\n" + fmt.Sprintf("`%s` (%.1fh/%.1fh)", bar, night.TotalHours(), c.GoalHours)
+	if roast.Score != nil {
+		msg.Text += fmt.Sprintf(" · %d/100", *roast.Score)
+	}
+	if bedtime := roast.Bedtime(); bedtime != "" {
+		msg.Text += "\nTonight: in bed by " + bedtime
+	}
 
-			time.Sleep(1 * time.Hour)
-		}
+	logger.Println("Sending Slack message to channel:", msg.Channel)
+	resp, err := slackClient.PostMessage(context.Background(), msg)
+	status.posted(err)
+	if err != nil {
+		return false, fmt.Errorf("sending Slack message: %w", err)
 	}
+	logger.Println("Slack message sent:", msg.Text)
+
+	err = ledger.Record(u.ID, date, SentReport{
+		Posted:   true,
+		PostedAt: clock.Now(),
+		Channel:  resp.Channel,
+		SlackTS:  resp.TS,
+		LogID:    mainSleep.LogID,
+	})
+	if err != nil {
+		// it went out, retrying would only post it twice
+		logger.Println("Error recording sent report:", err)
+	}
+
+	return true, nil
 }
 
 func generateSleepBar(sleptMillis int64, goalHours float64) string {
@@ -0,0 +1,280 @@
+// Package metrics works out how much and how regularly someone sleeps over
+// a run of nights: the sleep debt against a goal, how much bedtimes and wake
+// times move around, the sleep regularity index and social jetlag.
+//
+// Times are wall clock times, the way Fitbit reports them, so every night
+// is compared in the sleeper's own local time.
+package metrics
+
+import (
+	"math"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// Night is everything the metrics need to know about one date of sleep.
+type Night struct {
+	// Date is the date Fitbit filed the sleep under, the day of waking up.
+	Date string
+	// Start and End are the main sleep's.
+	Start, End time.Time
+	// MinutesAsleep counts every session of the date, naps included.
+	MinutesAsleep int
+	// Sessions are every session of the date, the main sleep included.
+	Sessions []Session
+}
+
+// Session is one stretch of sleep with its stage segments, if Fitbit had
+// any.
+type Session struct {
+	Start, End time.Time
+	Segments   []Segment
+}
+
+// Segment is one stage or short wake of a session, as in Fitbit's
+// levels.data and levels.shortData.
+type Segment struct {
+	Start    time.Time
+	Duration time.Duration
+	Level    string
+}
+
+// Asleep reports whether the segment's level is a kind of sleep. Fitbit uses
+// "wake" in stage logs and "awake" and "restless" in classic ones.
+func (s Segment) Asleep() bool {
+	switch s.Level {
+	case "wake", "awake", "restless":
+		return false
+	}
+	return true
+}
+
+// Midpoint is halfway through the main sleep.
+func (n Night) Midpoint() time.Time {
+	return n.Start.Add(n.End.Sub(n.Start) / 2)
+}
+
+// Summary is every metric over a run of nights.
+type Summary struct {
+	Nights int
+
+	// SleepDebt is how far the nights fell short of the goal, surplus nights
+	// paying some of it back. It never goes below zero.
+	SleepDebt time.Duration
+	Goal      time.Duration
+
+	Bedtime  ClockStats
+	WakeTime ClockStats
+
+	// Regularity is the sleep regularity index, from -100 to 100, over
+	// RegularityPairs pairs of consecutive nights. 100 is sleeping and waking
+	// at the exact same minutes every day, around 0 is random.
+	Regularity      float64
+	RegularityPairs int
+
+	// SocialJetlag is how much later the middle of the night is on weekends
+	// than on weekdays, negative if earlier. HasSocialJetlag is false
+	// without nights of both kinds.
+	SocialJetlag    time.Duration
+	HasSocialJetlag bool
+}
+
+// Compute works out the summary of nights against a nightly goal.
+func Compute(nights []Night, goal time.Duration) Summary {
+	summary := Summary{
+		Nights:    len(nights),
+		SleepDebt: SleepDebt(nights, goal),
+		Goal:      goal,
+		Bedtime:   Bedtimes(nights),
+		WakeTime:  WakeTimes(nights),
+	}
+	summary.Regularity, summary.RegularityPairs = Regularity(nights)
+	summary.SocialJetlag, summary.HasSocialJetlag = SocialJetlag(nights)
+	return summary
+}
+
+// SleepDebt adds up what each night slept short of goal, minus what it
+// slept over, floored at zero. Dates without a night are left out rather
+// than counted as no sleep at all, since the tracker may just not have been
+// worn.
+func SleepDebt(nights []Night, goal time.Duration) time.Duration {
+	var debt time.Duration
+	for _, n := range nights {
+		debt += goal - time.Duration(n.MinutesAsleep)*time.Minute
+	}
+	return max(debt, 0)
+}
+
+// ClockStats is the average time of day of something and how much it moves
+// around. Times of day are averaged on the clock face, so 11 PM and 1 AM
+// average to midnight and not to noon.
+type ClockStats struct {
+	// Mean is the time of day as a duration since midnight.
+	Mean   time.Duration
+	StdDev time.Duration
+	N      int
+}
+
+// Clock is the mean time of day, e.g. "11:40 PM".
+func (c ClockStats) Clock() string {
+	return time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).Add(c.Mean).Format("3:04 PM")
+}
+
+// Bedtimes is the clock stats of the main sleeps' starts.
+func Bedtimes(nights []Night) ClockStats {
+	var times []time.Time
+	for _, n := range nights {
+		times = append(times, n.Start)
+	}
+	return clockStats(times)
+}
+
+// WakeTimes is the clock stats of the main sleeps' ends.
+func WakeTimes(nights []Night) ClockStats {
+	var times []time.Time
+	for _, n := range nights {
+		times = append(times, n.End)
+	}
+	return clockStats(times)
+}
+
+func clockStats(times []time.Time) ClockStats {
+	if len(times) == 0 {
+		return ClockStats{}
+	}
+
+	// each time of day is an angle on a 24h clock face
+	var sumCos, sumSin float64
+	for _, t := range times {
+		angle := 2 * math.Pi * float64(t.Hour()*60+t.Minute()) / (24 * 60)
+		sumCos += math.Cos(angle)
+		sumSin += math.Sin(angle)
+	}
+	n := float64(len(times))
+	meanAngle := math.Atan2(sumSin/n, sumCos/n)
+	if meanAngle < 0 {
+		meanAngle += 2 * math.Pi
+	}
+
+	// the circular standard deviation, which is the usual one for times
+	// close together
+	r := math.Min(math.Hypot(sumCos/n, sumSin/n), 1)
+	stdDev := math.Sqrt(-2 * math.Log(r))
+
+	toDuration := func(angle float64) time.Duration {
+		return time.Duration(math.Round(angle/(2*math.Pi)*24*60)) * time.Minute
+	}
+	return ClockStats{
+		Mean:   toDuration(meanAngle) % (24 * time.Hour),
+		StdDev: toDuration(stdDev),
+		N:      len(times),
+	}
+}
+
+// Regularity is the sleep regularity index: the chance of being in the
+// same state, asleep or awake, at any two moments 24 hours apart, scaled
+// from -100 to 100. Only pairs of nights on consecutive dates count, pairs
+// is how many there were and the index is 0 without any.
+//
+// Each date covers the 24 hours from noon the day before, with stage
+// segments where Fitbit has them and whole sessions otherwise.
+func Regularity(nights []Night) (sri float64, pairs int) {
+	byDate := make(map[string]Night, len(nights))
+	for _, n := range nights {
+		byDate[n.Date] = n
+	}
+
+	var same, total int
+	for _, n := range nights {
+		day, err := time.Parse(dateLayout, n.Date)
+		if err != nil {
+			continue
+		}
+		next, ok := byDate[day.AddDate(0, 0, 1).Format(dateLayout)]
+		if !ok {
+			continue
+		}
+
+		a, b := asleepMinutes(n), asleepMinutes(next)
+		for i := range a {
+			if a[i] == b[i] {
+				same++
+			}
+		}
+		total += len(a)
+		pairs++
+	}
+
+	if pairs == 0 {
+		return 0, 0
+	}
+	return 200*float64(same)/float64(total) - 100, pairs
+}
+
+// asleepMinutes marks the minutes of n's 24 hours spent asleep.
+func asleepMinutes(n Night) []bool {
+	minutes := make([]bool, 24*60)
+
+	day, err := time.Parse(dateLayout, n.Date)
+	if err != nil {
+		return minutes
+	}
+	windowStart := day.Add(-12 * time.Hour)
+
+	mark := func(start, end time.Time, asleep bool) {
+		from := int(start.Sub(windowStart) / time.Minute)
+		to := int(end.Sub(windowStart) / time.Minute)
+		for i := max(from, 0); i < min(to, len(minutes)); i++ {
+			minutes[i] = asleep
+		}
+	}
+
+	for _, s := range n.Sessions {
+		if len(s.Segments) == 0 {
+			mark(s.Start, s.End, true)
+			continue
+		}
+		// sleep first, then the wakes on top, since the short wakes
+		// overlap the stages
+		for _, asleep := range []bool{true, false} {
+			for _, seg := range s.Segments {
+				if seg.Asleep() == asleep {
+					mark(seg.Start, seg.Start.Add(seg.Duration), asleep)
+				}
+			}
+		}
+	}
+
+	return minutes
+}
+
+// SocialJetlag is how much later the middle of the night falls on weekends
+// than on weekdays. Weekend nights are the ones waking up on a Saturday or
+// a Sunday. ok is false without both kinds of night.
+func SocialJetlag(nights []Night) (jetlag time.Duration, ok bool) {
+	var weekdays, weekends []time.Time
+	for _, n := range nights {
+		day, err := time.Parse(dateLayout, n.Date)
+		if err != nil {
+			continue
+		}
+		if wd := day.Weekday(); wd == time.Saturday || wd == time.Sunday {
+			weekends = append(weekends, n.Midpoint())
+		} else {
+			weekdays = append(weekdays, n.Midpoint())
+		}
+	}
+	if len(weekdays) == 0 || len(weekends) == 0 {
+		return 0, false
+	}
+
+	jetlag = clockStats(weekends).Mean - clockStats(weekdays).Mean
+	// the shorter way around the clock
+	if jetlag > 12*time.Hour {
+		jetlag -= 24 * time.Hour
+	} else if jetlag < -12*time.Hour {
+		jetlag += 24 * time.Hour
+	}
+	return jetlag, true
+}
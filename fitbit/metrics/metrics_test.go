@@ -0,0 +1,276 @@
+package metrics
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const fitbitTimeLayout = "2006-01-02T15:04:05.000"
+
+// sleepResponse is the part of Fitbit's sleep log list the fixtures use.
+type sleepResponse struct {
+	Sleep []struct {
+		DateOfSleep   string `json:"dateOfSleep"`
+		StartTime     string `json:"startTime"`
+		EndTime       string `json:"endTime"`
+		MinutesAsleep int    `json:"minutesAsleep"`
+		IsMainSleep   bool   `json:"isMainSleep"`
+		Levels        struct {
+			Data []struct {
+				DateTime string `json:"dateTime"`
+				Level    string `json:"level"`
+				Seconds  int    `json:"seconds"`
+			} `json:"data"`
+		} `json:"levels"`
+	} `json:"sleep"`
+}
+
+// loadNights reads a fixture of testdata into nights, the way the bot
+// builds them from the Fitbit API.
+func loadNights(t *testing.T, name string) []Night {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp sleepResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+
+	parse := func(s string) time.Time {
+		t.Helper()
+		tm, err := time.Parse(fitbitTimeLayout, s)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		return tm
+	}
+
+	var nights []Night
+	index := make(map[string]int)
+	for _, l := range resp.Sleep {
+		i, ok := index[l.DateOfSleep]
+		if !ok {
+			i = len(nights)
+			index[l.DateOfSleep] = i
+			nights = append(nights, Night{Date: l.DateOfSleep})
+		}
+		n := &nights[i]
+
+		s := Session{Start: parse(l.StartTime), End: parse(l.EndTime)}
+		for _, d := range l.Levels.Data {
+			s.Segments = append(s.Segments, Segment{
+				Start:    parse(d.DateTime),
+				Duration: time.Duration(d.Seconds) * time.Second,
+				Level:    d.Level,
+			})
+		}
+		n.Sessions = append(n.Sessions, s)
+		n.MinutesAsleep += l.MinutesAsleep
+		if l.IsMainSleep {
+			n.Start, n.End = s.Start, s.End
+		}
+	}
+	return nights
+}
+
+func approx(a, b float64) bool {
+	return math.Abs(a-b) < 0.01
+}
+
+func TestComputeNoSleep(t *testing.T) {
+	nights := loadNights(t, "empty.json")
+
+	got := Compute(nights, 8*time.Hour)
+	want := Summary{Goal: 8 * time.Hour}
+	if got != want {
+		t.Errorf("Compute() = %+v, want %+v", got, want)
+	}
+}
+
+func TestComputeSingleNight(t *testing.T) {
+	nights := loadNights(t, "single.json")
+
+	got := Compute(nights, 8*time.Hour)
+	want := Summary{
+		Nights:    1,
+		SleepDebt: time.Hour,
+		Goal:      8 * time.Hour,
+		Bedtime:   ClockStats{Mean: 22*time.Hour + 45*time.Minute, N: 1},
+		WakeTime:  ClockStats{Mean: 6*time.Hour + 15*time.Minute, N: 1},
+	}
+	if got != want {
+		t.Errorf("Compute() = %+v, want %+v", got, want)
+	}
+}
+
+func TestBedtimesAcrossMidnight(t *testing.T) {
+	nights := loadNights(t, "midnight.json")
+
+	// 11:30 PM and 12:30 AM, midnight and not noon
+	got := Bedtimes(nights)
+	want := ClockStats{Mean: 0, StdDev: 30 * time.Minute, N: 2}
+	if got != want {
+		t.Errorf("Bedtimes() = %+v, want %+v", got, want)
+	}
+	if clock := got.Clock(); clock != "12:00 AM" {
+		t.Errorf("Clock() = %q, want %q", clock, "12:00 AM")
+	}
+
+	got = WakeTimes(nights)
+	want = ClockStats{Mean: 8 * time.Hour, StdDev: 30 * time.Minute, N: 2}
+	if got != want {
+		t.Errorf("WakeTimes() = %+v, want %+v", got, want)
+	}
+
+	// to bed and up an hour later the second night, 120 of the 1440 minutes
+	// differ
+	sri, pairs := Regularity(nights)
+	if pairs != 1 || !approx(sri, 200*1320.0/1440-100) {
+		t.Errorf("Regularity() = %.2f, %d, want %.2f, 1", sri, pairs, 200*1320.0/1440-100)
+	}
+}
+
+func TestClockStatsWrapAround(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2026, 10, 12, hour, min, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name  string
+		times []time.Time
+		want  time.Duration
+	}{
+		{"before midnight", []time.Time{at(23, 0), at(0, 0)}, 23*time.Hour + 30*time.Minute},
+		{"after midnight", []time.Time{at(0, 0), at(1, 0)}, 30 * time.Minute},
+		{"on both sides", []time.Time{at(22, 0), at(2, 0)}, 0},
+		{"same day", []time.Time{at(13, 0), at(15, 0)}, 14 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := clockStats(tt.times)
+			if got.Mean != tt.want {
+				t.Errorf("clockStats().Mean = %s, want %s", got.Mean, tt.want)
+			}
+			if got.Mean < 0 || got.Mean >= 24*time.Hour {
+				t.Errorf("clockStats().Mean = %s, not a time of day", got.Mean)
+			}
+		})
+	}
+}
+
+func TestSleepDebt(t *testing.T) {
+	night := func(minutes int) Night {
+		return Night{MinutesAsleep: minutes}
+	}
+
+	tests := []struct {
+		name   string
+		nights []Night
+		want   time.Duration
+	}{
+		{"no nights", nil, 0},
+		{"short nights", []Night{night(7 * 60), night(6 * 60)}, 3 * time.Hour},
+		{"paid back in part", []Night{night(7 * 60), night(6 * 60), night(9 * 60)}, 2 * time.Hour},
+		{"never below zero", []Night{night(9 * 60), night(10 * 60)}, 0},
+		{"on target", []Night{night(8 * 60)}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SleepDebt(tt.nights, 8*time.Hour); got != tt.want {
+				t.Errorf("SleepDebt() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComputeWeek(t *testing.T) {
+	// Monday to Friday 11 PM to 7 AM with 7h30 asleep, the weekend 12:30 AM
+	// to 8:30 AM with 8h
+	nights := loadNights(t, "week.json")
+	got := Compute(nights, 8*time.Hour)
+
+	if got.Nights != 7 {
+		t.Errorf("Nights = %d, want 7", got.Nights)
+	}
+	if want := 5 * 30 * time.Minute; got.SleepDebt != want {
+		t.Errorf("SleepDebt = %s, want %s", got.SleepDebt, want)
+	}
+
+	// midpoints at 3 AM on weekdays and 4:30 AM on weekends
+	if want := 90 * time.Minute; !got.HasSocialJetlag || got.SocialJetlag != want {
+		t.Errorf("SocialJetlag = %s, %t, want %s, true", got.SocialJetlag, got.HasSocialJetlag, want)
+	}
+
+	// only Friday to Saturday differs, by 90 minutes at either end
+	want := 200*(6*1440-180.0)/(6*1440) - 100
+	if got.RegularityPairs != 6 || !approx(got.Regularity, want) {
+		t.Errorf("Regularity = %.2f over %d pairs, want %.2f over 6", got.Regularity, got.RegularityPairs, want)
+	}
+}
+
+func TestSocialJetlag(t *testing.T) {
+	night := func(date string, start, end time.Duration) Night {
+		day, _ := time.Parse(dateLayout, date)
+		return Night{Date: date, Start: day.Add(start), End: day.Add(end)}
+	}
+
+	tests := []struct {
+		name   string
+		nights []Night
+		want   time.Duration
+		ok     bool
+	}{
+		{
+			name:   "weekdays only",
+			nights: []Night{night("2026-10-14", -time.Hour, 7*time.Hour)},
+		},
+		{
+			name:   "weekends only",
+			nights: []Night{night("2026-10-17", -time.Hour, 7*time.Hour)},
+		},
+		{
+			// Friday's midpoint 3 AM, Saturday's 5 AM
+			name: "later on weekends",
+			nights: []Night{
+				night("2026-10-16", -time.Hour, 7*time.Hour),
+				night("2026-10-17", time.Hour, 9*time.Hour),
+			},
+			want: 2 * time.Hour,
+			ok:   true,
+		},
+		{
+			name: "earlier on weekends",
+			nights: []Night{
+				night("2026-10-16", 0, 8*time.Hour),
+				night("2026-10-18", -2*time.Hour, 6*time.Hour),
+			},
+			want: -2 * time.Hour,
+			ok:   true,
+		},
+		{
+			// midpoints at 11 PM and 1 AM, 2 hours apart across midnight
+			name: "across midnight",
+			nights: []Night{
+				night("2026-10-15", -5*time.Hour, 3*time.Hour),
+				night("2026-10-18", -3*time.Hour, 5*time.Hour),
+			},
+			want: 2 * time.Hour,
+			ok:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := SocialJetlag(tt.nights)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("SocialJetlag() = %s, %t, want %s, %t", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
@@ -5,6 +5,10 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 )
 
 func GenerateCodeVerifier(length int) (string, error) {
@@ -30,3 +34,89 @@ func GenerateCodeChallenge(verifier string) string {
 	hash := sha256.Sum256([]byte(verifier))
 	return base64.RawURLEncoding.EncodeToString(hash[:])
 }
+
+// authorizeURL is the Fitbit consent page for the client's PKCE challenge.
+// state comes back untouched on the callback and ties it to this attempt.
+func authorizeURL(c *SecretClient, state string) string {
+	v := url.Values{}
+	v.Set("state", state)
+	v.Set("client_id", c.ClientID)
+	v.Set("response_type", "code")
+	v.Set("code_challenge", c.CodeChallenge)
+	v.Set("code_challenge_method", "S256")
+	v.Set("scope", strings.Join(c.Scopes, " "))
+	v.Set("callback_uri", c.CallbackURL)
+	v.Set("redirect_uri", c.CallbackURL)
+
+	return "https://www.fitbit.com/oauth2/authorize?" + v.Encode()
+}
+
+// loginTTL is how long a linking attempt may take before its state expires.
+const loginTTL = 10 * time.Minute
+
+// pendingLogins maps the state of each linking attempt in flight to its
+// PKCE verifier, so concurrent attempts don't mix up their verifiers and a
+// callback without a known state is rejected.
+type pendingLogins struct {
+	mu      sync.Mutex
+	byState map[string]pendingLogin
+}
+
+type pendingLogin struct {
+	verifier string
+	expires  time.Time
+}
+
+func newPendingLogins() *pendingLogins {
+	return &pendingLogins{byState: make(map[string]pendingLogin)}
+}
+
+// Begin starts a linking attempt and returns the authorize URL to send the
+// user to.
+func (p *pendingLogins) Begin(secret SecretClient) (string, error) {
+	verifier, err := GenerateCodeVerifier(43)
+	if err != nil {
+		return "", err
+	}
+
+	state, err := GenerateCodeVerifier(43)
+	if err != nil {
+		return "", err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.prune()
+	p.byState[state] = pendingLogin{verifier: verifier, expires: time.Now().Add(loginTTL)}
+
+	secret.CodeVerifier = verifier
+	secret.CodeChallenge = GenerateCodeChallenge(verifier)
+	return authorizeURL(&secret, state), nil
+}
+
+// Finish consumes a state and returns its verifier. A state can only be used
+// once and not after it expired.
+func (p *pendingLogins) Finish(state string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.prune()
+
+	login, ok := p.byState[state]
+	if !ok {
+		return "", false
+	}
+	delete(p.byState, state)
+	return login.verifier, true
+}
+
+// prune drops expired attempts. Callers must hold p.mu.
+func (p *pendingLogins) prune() {
+	now := time.Now()
+	for state, login := range p.byState {
+		if now.After(login.expires) {
+			delete(p.byState, state)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// ollamaLLM talks to a local Ollama server's chat endpoint.
+type ollamaLLM struct {
+	api   llmAPI
+	model string
+}
+
+type ollamaRequest struct {
+	Model    string      `json:"model"`
+	Messages []AiMessage `json:"messages"`
+	Stream   bool        `json:"stream"`
+	// "json", or a JSON schema
+	Format json.RawMessage `json:"format,omitempty"`
+}
+
+type ollamaResponse struct {
+	Message AiMessage `json:"message"`
+	Done    bool      `json:"done"`
+}
+
+func (o *ollamaLLM) Complete(ctx context.Context, messages []AiMessage, format *ResponseFormat) (string, error) {
+	header := http.Header{}
+	if o.api.apiKey != "" {
+		// only needed behind an authenticating proxy
+		header.Set("Authorization", "Bearer "+o.api.apiKey)
+	}
+
+	request := ollamaRequest{Model: o.model, Messages: messages}
+	if format != nil {
+		request.Format = json.RawMessage(`"json"`)
+		if format.JSONSchema != nil {
+			request.Format = format.JSONSchema.Schema
+		}
+	}
+
+	var response ollamaResponse
+	err := o.api.post(ctx, header, request, &response)
+	if err != nil {
+		return "", err
+	}
+
+	return completion(o.api.provider, response.Message.Content)
+}
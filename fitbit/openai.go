@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"net/http"
+)
+
+// openAILLM talks to an OpenAI compatible chat completions endpoint, like
+// DeepSeek's, OpenRouter's or a local llama.cpp server.
+type openAILLM struct {
+	api   llmAPI
+	model string
+}
+
+func (o *openAILLM) Complete(ctx context.Context, messages []AiMessage, format *ResponseFormat) (string, error) {
+	header := http.Header{}
+	if o.api.apiKey != "" {
+		header.Set("Authorization", "Bearer "+o.api.apiKey)
+	}
+
+	var response AiResponse
+	err := o.api.post(ctx, header, AiRequest{Model: o.model, Messages: messages, ResponseFormat: format}, &response)
+	if err != nil {
+		return "", err
+	}
+
+	return completion(o.api.provider, response.GetContent())
+}
@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:embed personas/*.txt
+var builtinPersonas embed.FS
+
+// defaultPersona is the persona the bot had before there was a choice.
+const defaultPersona = "alcide"
+
+// randomPersona picks a different persona every day.
+const randomPersona = "random"
+
+// userNamePlaceholder is replaced by the user's display name in the prompts.
+const userNamePlaceholder = "USER_NAME"
+
+// personaWeekdays are the keys of report.persona_days.
+var personaWeekdays = []string{"monday", "tuesday", "wednesday", "thursday", "friday", "saturday", "sunday"}
+
+// Persona is a voice for the roasts, read from a .txt file of the personas
+// directory: a YAML header between --- lines, then the prompt.
+type Persona struct {
+	ID        string   `yaml:"-"` // the file name without .txt, what the config refers to
+	Name      string   `yaml:"name"`
+	Tone      string   `yaml:"tone"`
+	Emoji     []string `yaml:"emoji"`      // the only emoji allowed in the roasts, none if empty
+	MaxLength int      `yaml:"max_length"` // in characters, the guardrails' max if 0
+	Prompt    string   `yaml:"-"`
+}
+
+// SystemPrompt is the persona's prompt, followed by what the roasts share:
+// the rules and the format of the sleep stats, with historyDays earlier
+// nights.
+func (p *Persona) SystemPrompt(historyDays int) string {
+	return p.Prompt + "\n" + p.emojiRule() + "\n\n" + GetSystemPrompt(historyDays)
+}
+
+// DigestPrompt is the persona's prompt followed by what the digests share.
+func (p *Persona) DigestPrompt() string {
+	return p.Prompt + "\n" + p.emojiRule() + "\n\n" + GetDigestPrompt()
+}
+
+func (p *Persona) emojiRule() string {
+	if len(p.Emoji) == 0 {
+		return "No emoji at all."
+	}
+	return "The only emoji allowed are " + strings.Join(p.Emoji, " ") + ", and sparingly."
+}
+
+// personasFS is the personas directory of the config, or the built-in
+// personas without one.
+func personasFS(cfg *Config) fs.FS {
+	if cfg.Storage.PersonasDir != "" {
+		return os.DirFS(cfg.Storage.PersonasDir)
+	}
+	sub, _ := fs.Sub(builtinPersonas, "personas")
+	return sub
+}
+
+// loadPersonas reads every persona, sorted by ID.
+func loadPersonas(cfg *Config) ([]*Persona, error) {
+	fsys := personasFS(cfg)
+	names, err := fs.Glob(fsys, "*.txt")
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no personas in %s", cfg.Storage.PersonasDir)
+	}
+
+	var personas []*Persona
+	for _, name := range names {
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		p, err := parsePersona(strings.TrimSuffix(name, path.Ext(name)), data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		personas = append(personas, p)
+	}
+	return personas, nil
+}
+
+func parsePersona(id string, data []byte) (*Persona, error) {
+	p := &Persona{ID: id}
+
+	// the header is optional
+	text := string(bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")))
+	if rest, ok := strings.CutPrefix(text, "---\n"); ok {
+		header, prompt, found := strings.Cut(rest, "\n---\n")
+		if !found {
+			return nil, errors.New("the header has no closing ---")
+		}
+		if err := yaml.Unmarshal([]byte(header), p); err != nil {
+			return nil, fmt.Errorf("parsing the header: %w", err)
+		}
+		text = prompt
+	}
+
+	p.Prompt = strings.TrimSpace(text)
+	if p.Prompt == "" {
+		return nil, errors.New("the prompt is empty")
+	}
+	if p.Name == "" {
+		p.Name = id
+	}
+	if p.MaxLength < 0 {
+		return nil, fmt.Errorf("max_length can't be negative, got %d", p.MaxLength)
+	}
+	return p, nil
+}
+
+// ForUser returns the persona with its prompt addressed to u.
+func (p *Persona) ForUser(u *User) *Persona {
+	named := *p
+	named.Prompt = strings.ReplaceAll(p.Prompt, userNamePlaceholder, u.DisplayName())
+	return &named
+}
+
+func findPersona(personas []*Persona, id string) (*Persona, bool) {
+	for _, p := range personas {
+		if p.ID == id {
+			return p, true
+		}
+	}
+	return nil, false
+}
+
+// personaChoice is the persona ID, or "random", for u's night of date: the
+// user's own, else the one of the weekday, else the default.
+func personaChoice(cfg *Config, u *User, date string) string {
+	if u != nil && u.Persona != "" {
+		return u.Persona
+	}
+	if day, err := time.Parse(dateLayout, date); err == nil {
+		if id, ok := cfg.Report.PersonaDays[strings.ToLower(day.Weekday().String())]; ok {
+			return id
+		}
+	}
+	return cfg.Report.Persona
+}
+
+// pickPersona returns the persona of u's night of date. A random pick
+// depends on the user and the date only, so retries get the same one.
+func pickPersona(cfg *Config, u *User, date string) (*Persona, error) {
+	personas, err := loadPersonas(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	id := personaChoice(cfg, u, date)
+	if id == randomPersona {
+		h := fnv.New32a()
+		if u != nil {
+			h.Write([]byte(u.ID))
+		}
+		h.Write([]byte(date))
+		return personas[h.Sum32()%uint32(len(personas))].ForUser(u), nil
+	}
+
+	p, ok := findPersona(personas, id)
+	if !ok {
+		return nil, fmt.Errorf("unknown persona %q", id)
+	}
+	return p.ForUser(u), nil
+}
+
+// reportPersona is pickPersona falling back to the built-in default, so a
+// broken personas directory doesn't hold up the report.
+func reportPersona(cfg *Config, u *User, date string, logger *log.Logger) *Persona {
+	p, err := pickPersona(cfg, u, date)
+	if err == nil {
+		return p
+	}
+	logger.Println("Error picking the persona, using the default one:", err)
+
+	data, _ := builtinPersonas.ReadFile("personas/" + defaultPersona + ".txt")
+	if p, err = parsePersona(defaultPersona, data); err != nil {
+		// can't happen with the embedded file, but a prompt is a prompt
+		p = &Persona{ID: defaultPersona, Name: defaultPersona, Prompt: string(data)}
+	}
+	return p.ForUser(u)
+}
+
+// sleepPrompt is what the AI gets for a night.
+func sleepPrompt(persona *Persona, historyDays int, sleepLogDataMessage string) []AiMessage {
+	return []AiMessage{
+		{
+			Role:    "system",
+			Content: persona.SystemPrompt(historyDays),
+		},
+		{
+			Role:    "system",
+			Content: sleepLogDataMessage,
+		},
+	}
+}
+
+// personasCommand lists the personas or previews one.
+func personasCommand(cfg *Config, args []string) error {
+	if len(args) > 0 && args[0] == "list" {
+		return listPersonas(cfg)
+	}
+	if len(args) > 0 && args[0] == "preview" {
+		return previewPersona(cfg, args[1:])
+	}
+	return errors.New("usage: personas list|preview NAME [--date YYYY-MM-DD] [--user ID]")
+}
+
+func listPersonas(cfg *Config) error {
+	personas, err := loadPersonas(cfg)
+	if err != nil {
+		return err
+	}
+
+	// who gets each persona
+	usedBy := make(map[string][]string)
+	usedBy[cfg.Report.Persona] = append(usedBy[cfg.Report.Persona], "default")
+	for _, day := range personaWeekdays {
+		if id, ok := cfg.Report.PersonaDays[day]; ok {
+			usedBy[id] = append(usedBy[id], day)
+		}
+	}
+	if registry, err := openRegistry(cfg); err == nil {
+		for _, u := range registry.Users() {
+			if u.Persona != "" {
+				usedBy[u.Persona] = append(usedBy[u.Persona], "user "+u.ID)
+			}
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tTONE\tEMOJI\tMAX LENGTH\tUSED BY")
+	for _, p := range personas {
+		emoji := strings.Join(p.Emoji, " ")
+		if emoji == "" {
+			emoji = "none"
+		}
+		maxLength := "-"
+		if p.MaxLength > 0 {
+			maxLength = fmt.Sprint(p.MaxLength)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", p.ID, p.Name, p.Tone, emoji, maxLength, strings.Join(usedBy[p.ID], ", "))
+	}
+	if random := usedBy[randomPersona]; len(random) > 0 {
+		fmt.Fprintf(w, "%s\t\tone of the above each day\t\t\t%s\n", randomPersona, strings.Join(random, ", "))
+	}
+	return w.Flush()
+}
+
+// previewPersona prints what a persona says about a night of the history
+// database, without posting it.
+func previewPersona(cfg *Config, args []string) error {
+	preview, err := parsePreviewArgs(args, time.Now())
+	if err != nil {
+		return err
+	}
+
+	personas, err := loadPersonas(cfg)
+	if err != nil {
+		return err
+	}
+	persona, ok := findPersona(personas, preview.name)
+	if !ok {
+		return fmt.Errorf("unknown persona %q, see personas list", preview.name)
+	}
+	day, _ := time.Parse(dateLayout, preview.date)
+
+	registry, err := openRegistry(cfg)
+	if err != nil {
+		return err
+	}
+	u, err := previewUser(registry, preview.userID)
+	if err != nil {
+		return err
+	}
+
+	history, err := openHistory(cfg)
+	if err != nil {
+		return err
+	}
+	defer history.Close()
+
+	resp, err := history.SleepRange(u.ID, preview.date, preview.date)
+	if err != nil {
+		return err
+	}
+	if len(resp.Sleep) == 0 {
+		return fmt.Errorf("no stored sleep for %s on %s, run backfill first", u.ID, preview.date)
+	}
+	night := newNight(preview.date, resp.Sleep)
+
+	goalHours := u.GoalHours
+	if goalHours <= 0 {
+		goalHours = cfg.Report.GoalHours
+	}
+
+	// the same context as the report, from the history only
+	rangeEnd := day.AddDate(0, 0, -1).Format(dateLayout)
+	rangeData, err := history.SleepRange(u.ID, day.AddDate(0, 0, -cfg.Report.HistoryDays).Format(dateLayout), rangeEnd)
+	if err != nil {
+		return err
+	}
+	sleepLogData := MakeSleepLogData(night, rangeData, cfg.Report.HistoryDays)
+
+	var metricsData *FitbitSleepResponse
+	if cfg.Report.MetricsDays > 1 {
+		metricsData, err = history.SleepRange(u.ID, day.AddDate(0, 0, 1-cfg.Report.MetricsDays).Format(dateLayout), rangeEnd)
+		if err != nil {
+			return err
+		}
+	}
+	nightMetrics := sleepMetrics(night, metricsData, goalHours)
+	sleepLogData.Metrics = &nightMetrics
+
+	llm, err := newLLM(cfg)
+	if err != nil {
+		return err
+	}
+	guardrails, err := loadGuardrails(cfg)
+	if err != nil {
+		return err
+	}
+
+	persona = persona.ForUser(u)
+	logger := log.New(log.Writer(), "["+u.ID+"] ", log.Flags())
+	roast := writeRoast(cfg, llm, guardrails, persona, sleepLogData, goalHours, logger)
+
+	fmt.Printf("%s on %s:\n\n", persona.Name, preview.date)
+	if roast.Headline != "" {
+		fmt.Printf("*%s*\n", roast.Headline)
+	}
+	fmt.Println(roast.Roast)
+	if roast.PositiveNote != "" {
+		fmt.Println(":sparkles:", roast.PositiveNote)
+	}
+	if roast.Score != nil {
+		fmt.Printf("Score: %d/100\n", *roast.Score)
+	}
+	if bedtime := roast.Bedtime(); bedtime != "" {
+		fmt.Println("Tonight: in bed by", bedtime)
+	}
+	return nil
+}
+
+// previewArgs are the arguments of personas preview.
+type previewArgs struct {
+	name   string
+	date   string // YYYY-MM-DD, today by default
+	userID string
+}
+
+func parsePreviewArgs(args []string, now time.Time) (previewArgs, error) {
+	var preview previewArgs
+	flags := flag.NewFlagSet("personas preview", flag.ContinueOnError)
+	flags.StringVar(&preview.date, "date", now.Format(dateLayout), "night to roast, YYYY-MM-DD")
+	flags.StringVar(&preview.userID, "user", "", "Fitbit user ID, the only linked user by default")
+	// the name can come before or after the flags
+	if err := flags.Parse(args); err != nil {
+		return preview, err
+	}
+	rest := flags.Args()
+	if len(rest) > 0 {
+		if err := flags.Parse(rest[1:]); err != nil {
+			return preview, err
+		}
+	}
+	if len(rest) == 0 || flags.NArg() > 0 {
+		return preview, errors.New("usage: personas preview NAME [--date YYYY-MM-DD] [--user ID]")
+	}
+	preview.name = rest[0]
+
+	if _, err := time.Parse(dateLayout, preview.date); err != nil {
+		return preview, fmt.Errorf("--date: %w", err)
+	}
+	return preview, nil
+}
+
+func previewUser(registry *Registry, id string) (*User, error) {
+	if id != "" {
+		u, ok := registry.Get(id)
+		if !ok {
+			return nil, fmt.Errorf("no linked user %s", id)
+		}
+		return u, nil
+	}
+
+	users := registry.Users()
+	switch len(users) {
+	case 0:
+		return nil, errors.New("no Fitbit account linked yet")
+	case 1:
+		return users[0], nil
+	}
+	ids := make([]string, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+	sort.Strings(ids)
+	return nil, fmt.Errorf("more than one linked user, pick one with --user: %s", strings.Join(ids, ", "))
+}
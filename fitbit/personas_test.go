@@ -0,0 +1,208 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuiltinPersonas(t *testing.T) {
+	personas, err := loadPersonas(testConfig(t, "http://localhost"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := findPersona(personas, defaultPersona); !ok {
+		t.Errorf("no built-in %s persona", defaultPersona)
+	}
+
+	u := &User{ID: "ALICE", Name: "Bob"}
+	for _, p := range personas {
+		if !strings.Contains(p.Prompt, userNamePlaceholder) {
+			t.Errorf("%s doesn't say whose sleep it is", p.ID)
+		}
+		named := p.ForUser(u)
+		if strings.Contains(named.Prompt, userNamePlaceholder) || !strings.Contains(named.Prompt, "Bob's sleep") {
+			t.Errorf("%s prompt for Bob = %q", p.ID, named.Prompt)
+		}
+		if !strings.Contains(p.Prompt, userNamePlaceholder) {
+			t.Errorf("ForUser changed the prompt of %s", p.ID)
+		}
+
+		// no name, no made up one
+		if anon := p.ForUser(&User{ID: "ALICE"}); !strings.Contains(anon.Prompt, "the user's sleep") {
+			t.Errorf("%s prompt without a name = %q", p.ID, anon.Prompt)
+		}
+	}
+}
+
+func TestPickPersona(t *testing.T) {
+	cfg := testConfig(t, "http://localhost")
+	cfg.Report.Persona = "alcide"
+	cfg.Report.PersonaDays = map[string]string{"monday": "coach", "sunday": "random"}
+
+	const (
+		tuesday = "2025-06-03"
+		monday  = "2025-06-02"
+		sunday  = "2025-06-01"
+	)
+
+	tests := []struct {
+		name string
+		user *User
+		date string
+		want string
+	}{
+		{"default", &User{ID: "ALICE"}, tuesday, "alcide"},
+		{"weekday", &User{ID: "ALICE"}, monday, "coach"},
+		{"the user's own wins", &User{ID: "ALICE", Persona: "nana"}, monday, "nana"},
+		{"no user", nil, monday, "coach"},
+		{"bad date", &User{ID: "ALICE"}, "yesterday", "alcide"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := pickPersona(cfg, tt.user, tt.date)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.ID != tt.want {
+				t.Errorf("pickPersona() = %s, want %s", p.ID, tt.want)
+			}
+		})
+	}
+
+	t.Run("random", func(t *testing.T) {
+		u := &User{ID: "ALICE"}
+		first, err := pickPersona(cfg, u, sunday)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// retries of the night get the same one
+		again, err := pickPersona(cfg, u, sunday)
+		if err != nil || again.ID != first.ID {
+			t.Errorf("pickPersona() again = %v, %v, want %s", again, err, first.ID)
+		}
+
+		// and it does rotate over the days
+		seen := map[string]bool{}
+		day, _ := time.Parse(dateLayout, sunday)
+		for i := range 20 {
+			p, err := pickPersona(cfg, u, day.AddDate(0, 0, 7*i).Format(dateLayout))
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen[p.ID] = true
+		}
+		if len(seen) < 2 {
+			t.Errorf("random picked only %v over 20 sundays", seen)
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		if _, err := pickPersona(cfg, &User{ID: "ALICE", Persona: "nobody"}, tuesday); err == nil || !strings.Contains(err.Error(), "nobody") {
+			t.Errorf("pickPersona() error = %v, want the unknown persona", err)
+		}
+
+		// the report goes on with the default
+		p := reportPersona(cfg, &User{ID: "ALICE", Name: "Bob", Persona: "nobody"}, tuesday, log.New(log.Writer(), "[test] ", log.Flags()))
+		if p.ID != defaultPersona || !strings.Contains(p.Prompt, "Bob") {
+			t.Errorf("reportPersona() = %s, %q, want the default for Bob", p.ID, p.Prompt)
+		}
+	})
+}
+
+func TestPersonasDir(t *testing.T) {
+	cfg := testConfig(t, "http://localhost")
+	cfg.Storage.PersonasDir = t.TempDir()
+	cfg.Report.Persona = "pirate"
+
+	write := func(name, data string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(cfg.Storage.PersonasDir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("pirate.txt", "---\nname: Captain\nemoji: [\"🏴‍☠️\"]\nmax_length: 300\n---\nArr, USER_NAME slept like a landlubber.\n")
+	write("plain.txt", "Just a prompt, no header.\n")
+	write("notes.md", "not a persona")
+
+	personas, err := loadPersonas(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(personas) != 2 || personas[0].ID != "pirate" || personas[1].ID != "plain" {
+		t.Fatalf("loadPersonas() = %v, want pirate and plain", personas)
+	}
+	if _, ok := findPersona(personas, defaultPersona); ok {
+		t.Error("the directory replaces the built-in personas, got alcide too")
+	}
+
+	p, err := pickPersona(cfg, &User{ID: "ALICE", Name: "Bob"}, "2025-06-03")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "Captain" || p.MaxLength != 300 || len(p.Emoji) != 1 {
+		t.Errorf("pickPersona() = %+v, want the header of pirate.txt", p)
+	}
+	if p.Prompt != "Arr, Bob slept like a landlubber." {
+		t.Errorf("prompt = %q", p.Prompt)
+	}
+	if plain := personas[1]; plain.Name != "plain" || plain.Prompt != "Just a prompt, no header." {
+		t.Errorf("persona without a header = %+v", plain)
+	}
+
+	// broken files
+	for name, data := range map[string]string{
+		"unclosed.txt": "---\nname: Oops\nNo closing line.\n",
+		"empty.txt":    "---\nname: Empty\n---\n\n",
+		"negative.txt": "---\nmax_length: -1\n---\nHi.\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			write(name, data)
+			defer os.Remove(filepath.Join(cfg.Storage.PersonasDir, name))
+
+			if _, err := loadPersonas(cfg); err == nil || !strings.Contains(err.Error(), name) {
+				t.Errorf("loadPersonas() error = %v, want one about %s", err, name)
+			}
+		})
+	}
+
+	cfg.Storage.PersonasDir = t.TempDir()
+	if _, err := loadPersonas(cfg); err == nil {
+		t.Error("loadPersonas() of an empty directory, error = nil")
+	}
+}
+
+func TestParsePreviewArgs(t *testing.T) {
+	now := time.Date(2025, 6, 3, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    previewArgs
+		wantErr bool
+	}{
+		{name: "name only", args: []string{"coach"}, want: previewArgs{name: "coach", date: "2025-06-03"}},
+		{name: "flags after", args: []string{"coach", "--date", "2025-05-30", "--user", "ALICE"}, want: previewArgs{name: "coach", date: "2025-05-30", userID: "ALICE"}},
+		{name: "flags before", args: []string{"--date=2025-05-30", "coach"}, want: previewArgs{name: "coach", date: "2025-05-30"}},
+		{name: "flags around", args: []string{"--user", "ALICE", "coach", "--date", "2025-05-30"}, want: previewArgs{name: "coach", date: "2025-05-30", userID: "ALICE"}},
+		{name: "no name", args: []string{"--date", "2025-05-30"}, wantErr: true},
+		{name: "nothing", wantErr: true},
+		{name: "two names", args: []string{"coach", "nana"}, wantErr: true},
+		{name: "bad date", args: []string{"coach", "--date", "30/05/2025"}, wantErr: true},
+		{name: "unknown flag", args: []string{"coach", "--loud"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePreviewArgs(tt.args, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePreviewArgs() error = %v, want an error: %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parsePreviewArgs() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/espcaa/random-workflows-that-actually-are-bots/tokenfile"
+)
+
+// User is one linked Fitbit account and where its reports go.
+type User struct {
+	ID        string  `json:"id"`             // Fitbit user_id
+	Name      string  `json:"name,omitempty"` // what the personas call them
+	GoalHours float64 `json:"goal_hours"`
+	Channel   string  `json:"channel"`
+	ThreadTS  string  `json:"thread_ts,omitempty"`
+	Persona   string  `json:"persona,omitempty"`
+	Timezone  string  `json:"timezone,omitempty"`
+
+	// TokensFile overrides the default <tokens dir>/<id>.json location. It
+	// is set for the account migrated from a single-user tokens.json.
+	TokensFile string `json:"tokens_file,omitempty"`
+}
+
+// DisplayName is the name the personas use, "the user" without one.
+func (u *User) DisplayName() string {
+	if u == nil || u.Name == "" {
+		return "the user"
+	}
+	return u.Name
+}
+
+// Location is the user's timezone, falling back to the server's.
+func (u *User) Location() *time.Location {
+	if u.Timezone == "" {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(u.Timezone)
+	if err != nil {
+		log.Printf("[%s] Unknown timezone %q, using local time", u.ID, u.Timezone)
+		return time.Local
+	}
+	return loc
+}
+
+// Registry is the set of linked users, kept in users.json. Each user's token
+// lives in its own token file so a refresh only ever rewrites one account.
+type Registry struct {
+	mu        sync.Mutex
+	cfg       *Config
+	path      string
+	tokensDir string
+	key       []byte
+	users     map[string]*User
+}
+
+// openRegistry loads the registry from the configured paths. Without a
+// users.json, an existing single-user tokens.json becomes the first user.
+func openRegistry(cfg *Config) (*Registry, error) {
+	key, err := tokenfile.LoadKey()
+	if err != nil {
+		return nil, err
+	}
+	return LoadRegistry(cfg, key)
+}
+
+func LoadRegistry(cfg *Config, key []byte) (*Registry, error) {
+	path := cfg.Storage.UsersFile
+	registry := &Registry{
+		cfg:       cfg,
+		path:      path,
+		tokensDir: cfg.Storage.TokensDir,
+		key:       key,
+		users:     make(map[string]*User),
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return registry, registry.migrateLegacyTokens()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var users []*User
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, fmt.Errorf("%s is corrupted: %w", path, err)
+	}
+	for _, u := range users {
+		registry.users[u.ID] = u
+	}
+
+	return registry, nil
+}
+
+// migrateLegacyTokens registers the account from a pre-registry tokens.json,
+// keeping the token where it is.
+func (r *Registry) migrateLegacyTokens() error {
+	store := NewFileTokenStore(r.cfg.Storage.TokensFile, r.key)
+	token, err := store.Load()
+	if errors.Is(err, ErrNoToken) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	u := r.newUser(token.UserID)
+	u.TokensFile = store.Path
+	r.users[u.ID] = u
+
+	log.Printf("Migrated %s to %s as user %s", store.Path, r.path, u.ID)
+
+	return r.save()
+}
+
+// newUser is a freshly linked user with the configured defaults.
+func (r *Registry) newUser(id string) *User {
+	return &User{
+		ID:        id,
+		GoalHours: r.cfg.Report.GoalHours,
+		Channel:   r.cfg.Slack.DefaultChannel,
+	}
+}
+
+// Users returns every registered user, ordered by ID.
+func (r *Registry) Users() []*User {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	users := make([]*User, 0, len(r.users))
+	for _, u := range r.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users
+}
+
+func (r *Registry) Get(id string) (*User, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[id]
+	return u, ok
+}
+
+// Register saves a freshly linked token, adding the user if it is new. An
+// existing user keeps its settings and only gets the new token.
+func (r *Registry) Register(token *FitbitTokenResponse) (*User, error) {
+	if token.UserID == "" {
+		return nil, errors.New("token response has no user_id")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.users[token.UserID]
+	if !ok {
+		u = r.newUser(token.UserID)
+		r.users[u.ID] = u
+		if err := r.save(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := r.store(u).Save(token); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// Store returns the token store of a user.
+func (r *Registry) Store(u *User) *FileTokenStore {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.store(u)
+}
+
+func (r *Registry) store(u *User) *FileTokenStore {
+	path := u.TokensFile
+	if path == "" {
+		path = filepath.Join(r.tokensDir, u.ID+".json")
+	}
+	return NewFileTokenStore(path, r.key)
+}
+
+// TokenFiles returns the token file of every user, each followed by its
+// backup.
+func (r *Registry) TokenFiles() []string {
+	var paths []string
+	for _, u := range r.Users() {
+		store := r.Store(u)
+		paths = append(paths, store.Path, store.backupPath())
+	}
+	return paths
+}
+
+// Client builds a Fitbit client for a user from its stored token.
+func (r *Registry) Client(u *User, secret SecretClient) (*FitbitClient, error) {
+	client, err := newFitbitClientFromStore(r.cfg, r.Store(u), secret)
+	if err != nil {
+		return nil, err
+	}
+	if u.GoalHours > 0 {
+		client.GoalHours = u.GoalHours
+	}
+	return client, nil
+}
+
+// save writes users.json. Callers must hold r.mu.
+func (r *Registry) save() error {
+	users := make([]*User, 0, len(r.users))
+	for _, u := range r.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+
+	data, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(r.tokensDir, 0700); err != nil {
+		return err
+	}
+
+	return tokenfile.WriteAtomic(r.path, data)
+}
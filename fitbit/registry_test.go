@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/base64"
+	"os"
+	"testing"
+
+	"github.com/espcaa/random-workflows-that-actually-are-bots/tokenfile"
+)
+
+// TestTokensCommand checks `tokens encrypt` covers every user's token and
+// its backup, and that the bot still reads them with the key.
+func TestTokensCommand(t *testing.T) {
+	cfg := testConfig(t, "http://localhost")
+
+	registry, err := LoadRegistry(cfg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"ALICE", "BOB"} {
+		// twice, so there is a backup
+		for _, access := range []string{"old", "new"} {
+			token := &FitbitTokenResponse{AccessToken: access + "-access", RefreshToken: access + "-refresh", UserID: id}
+			if _, err := registry.Register(token); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	paths := registry.TokenFiles()
+	if len(paths) != 4 {
+		t.Fatalf("TokenFiles() = %v, want two tokens and their backups", paths)
+	}
+
+	key, err := tokenfile.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TOKENS_ENCRYPTION_KEY", key)
+	t.Setenv("TOKENS_ENCRYPTION_KEY_FILE", "")
+
+	if err := tokensCommand(cfg, []string{"encrypt"}); err != nil {
+		t.Fatalf("tokens encrypt: %v", err)
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !tokenfile.IsEncrypted(data) {
+			t.Errorf("%s is still in plaintext", path)
+		}
+	}
+
+	rawKey, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := LoadRegistry(cfg, rawKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range encrypted.Users() {
+		token, err := encrypted.Store(u).Load()
+		if err != nil {
+			t.Fatalf("loading the encrypted token of %s: %v", u.ID, err)
+		}
+		if token.AccessToken != "new-access" {
+			t.Errorf("token of %s = %s, want the latest", u.ID, token.AccessToken)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+//go:embed roast.tmpl
+var roastTemplateString string
+
+var roastTemplate = template.Must(template.New("roast").Parse(roastTemplateString))
+
+// roastData is what the canned roast lines can use.
+type roastData struct {
+	SleepLogData
+	Asleep string
+	Goal   string
+	Debt   string
+}
+
+// cannedRoast writes a roast of the night without any AI, so the report
+// still has some commentary when every backend failed. The same night
+// always gets the same roast.
+func cannedRoast(data SleepLogData, goalHours float64) string {
+	goal := time.Duration(goalHours * float64(time.Hour))
+	asleep := time.Duration(data.TotalAsleep) * time.Minute
+
+	rd := roastData{
+		SleepLogData: data,
+		Asleep:       roastDuration(asleep),
+		Goal:         roastDuration(goal),
+	}
+
+	var lines []string
+	switch {
+	case asleep < goal-90*time.Minute:
+		lines = append(lines, "short")
+	case asleep < goal:
+		lines = append(lines, "close")
+	default:
+		lines = append(lines, "goal")
+	}
+	if data.MinutesToFallAsleep > 30 {
+		lines = append(lines, "slow")
+	}
+	if data.MinutesAwake > 60 {
+		lines = append(lines, "restless")
+	}
+	if data.Metrics != nil && data.Metrics.SleepDebt > 5*time.Hour {
+		rd.Debt = roastDuration(data.Metrics.SleepDebt)
+		lines = append(lines, "debt")
+	}
+	lines = append(lines, "sign-off")
+
+	var sentences []string
+	for _, line := range lines {
+		var b strings.Builder
+		if err := roastTemplate.ExecuteTemplate(&b, roastVariant(line, data.Date), rd); err != nil {
+			continue
+		}
+		sentences = append(sentences, b.String())
+	}
+	return strings.Join(sentences, " ")
+}
+
+// roastVariant picks one of the variants of a line from the date.
+func roastVariant(line, date string) string {
+	n := 0
+	for roastTemplate.Lookup(fmt.Sprintf("%s.%d", line, n)) != nil {
+		n++
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(line + date))
+	return fmt.Sprintf("%s.%d", line, h.Sum32()%uint32(n))
+}
+
+// roastDuration formats d like 6h05.
+func roastDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	return fmt.Sprintf("%dh%02d", int(d.Hours()), int(d.Minutes())%60)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+//go:embed roast_schema.json
+var roastSchemaString string
+
+//go:embed roast_format.txt
+var roastFormatString string
+
+// Roast is the AI's take on a night. Only Roast is always there: the other
+// fields are empty when the model answered in free text, or when it's the
+// canned roast.
+type Roast struct {
+	Headline         string `json:"headline"`
+	Roast            string `json:"roast"`
+	PositiveNote     string `json:"positive_note"`
+	Score            *int   `json:"score"`             // 0 to 100
+	SuggestedBedtime string `json:"suggested_bedtime"` // "15:04"
+}
+
+// roastResponseFormat is the response format of ai.response_format, nil for
+// text.
+func roastResponseFormat(cfg *Config) *ResponseFormat {
+	switch cfg.AI.ResponseFormat {
+	case "json_schema":
+		return &ResponseFormat{
+			Type: "json_schema",
+			JSONSchema: &JSONSchema{
+				Name:   "sleep_roast",
+				Strict: true,
+				Schema: json.RawMessage(roastSchemaString),
+			},
+		}
+	case "json_object":
+		return &ResponseFormat{Type: "json_object"}
+	default:
+		return nil
+	}
+}
+
+// parseRoast decodes and checks a JSON reply. A reply that decodes but
+// breaks a rule comes back along with the error, its roast may still do.
+func parseRoast(reply string) (*Roast, error) {
+	// some models wrap it in a code block anyway
+	text := strings.TrimSpace(reply)
+	text = strings.TrimPrefix(text, "```json")
+	text = strings.TrimPrefix(text, "```")
+	text = strings.TrimSuffix(text, "```")
+
+	var r Roast
+	if err := json.Unmarshal([]byte(text), &r); err != nil {
+		return nil, err
+	}
+	r.Headline = strings.TrimSpace(r.Headline)
+	r.Roast = strings.TrimSpace(r.Roast)
+	r.PositiveNote = strings.TrimSpace(r.PositiveNote)
+
+	var errs []error
+	if r.Headline == "" {
+		errs = append(errs, errors.New("no headline"))
+	}
+	if r.Roast == "" {
+		errs = append(errs, errors.New("no roast"))
+	}
+	if r.PositiveNote == "" {
+		errs = append(errs, errors.New("no positive_note"))
+	}
+	if r.Score == nil {
+		errs = append(errs, errors.New("no score"))
+	} else if *r.Score < 0 || *r.Score > 100 {
+		errs = append(errs, fmt.Errorf("score %d isn't between 0 and 100", *r.Score))
+	}
+	if _, err := time.Parse("15:04", r.SuggestedBedtime); err != nil {
+		errs = append(errs, fmt.Errorf("suggested_bedtime %q isn't HH:MM", r.SuggestedBedtime))
+	}
+	return &r, errors.Join(errs...)
+}
+
+// freeTextRoast is what's left of a reply that isn't a valid JSON roast: its
+// roast field, or the reply itself when it isn't JSON at all. It's empty
+// when neither will do.
+func freeTextRoast(reply string, r *Roast) string {
+	if r != nil {
+		return r.Roast
+	}
+	if strings.HasPrefix(strings.TrimSpace(reply), "{") {
+		return ""
+	}
+	return strings.TrimSpace(reply)
+}
+
+// Bedtime formats the suggested bedtime like the rest of the report.
+func (r *Roast) Bedtime() string {
+	t, err := time.Parse("15:04", r.SuggestedBedtime)
+	if err != nil {
+		return ""
+	}
+	return t.Format("3:04 PM")
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const testRoastJSON = `{"headline":"Owl mode","roast":"Bedtime at 2am again.","positive_note":"Deep sleep held up.","score":42,"suggested_bedtime":"22:45"}`
+
+func TestParseRoast(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+	}{
+		{"plain", testRoastJSON},
+		{"whitespace", "\n  " + testRoastJSON + "\n"},
+		{"code block", "```json\n" + testRoastJSON + "\n```"},
+		{"bare code block", "```\n" + testRoastJSON + "\n```"},
+		{"extra field", `{"headline":"Owl mode","roast":"Bedtime at 2am again.","positive_note":"Deep sleep held up.","score":42,"suggested_bedtime":"22:45","mood":"grumpy"}`},
+		{"padded fields", `{"headline":" Owl mode ","roast":"Bedtime at 2am again.\n","positive_note":"  Deep sleep held up.","score":42,"suggested_bedtime":"22:45"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := parseRoast(tt.reply)
+			if err != nil {
+				t.Fatalf("parseRoast() error = %v", err)
+			}
+			if r.Headline != "Owl mode" || r.Roast != "Bedtime at 2am again." || r.PositiveNote != "Deep sleep held up." {
+				t.Errorf("parseRoast() = %+v", r)
+			}
+			if r.Score == nil || *r.Score != 42 {
+				t.Errorf("score = %v, want 42", r.Score)
+			}
+			if r.Bedtime() != "10:45 PM" {
+				t.Errorf("Bedtime() = %q, want 10:45 PM", r.Bedtime())
+			}
+		})
+	}
+}
+
+func TestParseRoastInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		// the roast still usable, and what the error says
+		roast string
+		want  []string
+	}{
+		{"no headline", `{"roast":"Late.","positive_note":"Slept.","score":10,"suggested_bedtime":"23:00"}`, "Late.", []string{"no headline"}},
+		{"blank roast", `{"headline":"Hm","roast":"  ","positive_note":"Slept.","score":10,"suggested_bedtime":"23:00"}`, "", []string{"no roast"}},
+		{"no score", `{"headline":"Hm","roast":"Late.","positive_note":"Slept.","suggested_bedtime":"23:00"}`, "Late.", []string{"no score"}},
+		{"score too high", `{"headline":"Hm","roast":"Late.","positive_note":"Slept.","score":140,"suggested_bedtime":"23:00"}`, "Late.", []string{"score 140"}},
+		{"negative score", `{"headline":"Hm","roast":"Late.","positive_note":"Slept.","score":-1,"suggested_bedtime":"23:00"}`, "Late.", []string{"score -1"}},
+		{"bedtime not HH:MM", `{"headline":"Hm","roast":"Late.","positive_note":"Slept.","score":10,"suggested_bedtime":"11pm"}`, "Late.", []string{`suggested_bedtime "11pm"`}},
+		{"only the roast", `{"roast":"Late."}`, "Late.", []string{"no headline", "no positive_note", "no score", "suggested_bedtime"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := parseRoast(tt.reply)
+			if err == nil {
+				t.Fatal("parseRoast() error = nil")
+			}
+			if r == nil {
+				t.Fatal("parseRoast() = nil, want the decoded roast along with the error")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("parseRoast() error = %q, want it to mention %q", err, want)
+				}
+			}
+			if got := freeTextRoast(tt.reply, r); got != tt.roast {
+				t.Errorf("freeTextRoast() = %q, want %q", got, tt.roast)
+			}
+		})
+	}
+}
+
+func TestFreeTextRoast(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		want  string
+	}{
+		{"free text", "  Bedtime at 2am again, the owl strikes.\n", "Bedtime at 2am again, the owl strikes."},
+		{"text around json", `Here you go: {"roast":"Late."}`, `Here you go: {"roast":"Late."}`},
+		{"broken json", `{"headline":"Owl mode","roast":"Bedtime at`, ""},
+		{"wrong types", `{"headline":1,"roast":["Late."]}`, ""},
+		{"empty", "  ", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := parseRoast(tt.reply)
+			if err == nil {
+				t.Fatalf("parseRoast() = %+v, want an error", r)
+			}
+			if r != nil {
+				t.Fatalf("parseRoast() = %+v, want nil for a reply that isn't a JSON roast", r)
+			}
+			if got := freeTextRoast(tt.reply, r); got != tt.want {
+				t.Errorf("freeTextRoast() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoastResponseFormat(t *testing.T) {
+	cfg := testConfig(t, "http://localhost")
+
+	cfg.AI.ResponseFormat = "text"
+	if f := roastResponseFormat(cfg); f != nil {
+		t.Errorf("text format = %+v, want nil", f)
+	}
+
+	cfg.AI.ResponseFormat = "json_object"
+	if f := roastResponseFormat(cfg); f == nil || f.Type != "json_object" || f.JSONSchema != nil {
+		t.Errorf("json_object format = %+v", f)
+	}
+
+	cfg.AI.ResponseFormat = "json_schema"
+	f := roastResponseFormat(cfg)
+	if f == nil || f.Type != "json_schema" || f.JSONSchema == nil || !f.JSONSchema.Strict {
+		t.Fatalf("json_schema format = %+v", f)
+	}
+
+	// the schema asks for every field of a roast
+	var schema struct {
+		Required []string `json:"required"`
+	}
+	if err := json.Unmarshal(f.JSONSchema.Schema, &schema); err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(testRoastJSON), &fields); err != nil {
+		t.Fatal(err)
+	}
+	if len(schema.Required) != len(fields) {
+		t.Errorf("schema requires %v, want every field of %s", schema.Required, testRoastJSON)
+	}
+	for _, name := range schema.Required {
+		if _, ok := fields[name]; !ok {
+			t.Errorf("schema requires %s, which a roast doesn't have", name)
+		}
+	}
+}
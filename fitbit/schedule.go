@@ -0,0 +1,88 @@
+package main
+
+import (
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const dateLayout = "2006-01-02"
+
+// tokenRefreshInterval is how often an idle loop refreshes its Fitbit token.
+const tokenRefreshInterval = 30 * time.Minute
+
+// Clock is the bot's view of time, so the schedule can be driven by a fake
+// clock instead of waiting real hours.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// reportSchedule decides when a user's daily report is attempted. Each cron
+// firing opens a polling window: the bot keeps asking Fitbit for the night
+// until the report is posted or the window closes.
+type reportSchedule struct {
+	cron   cron.Schedule
+	window time.Duration
+	loc    *time.Location
+}
+
+func newReportSchedule(cfg *Config, loc *time.Location) (*reportSchedule, error) {
+	schedule, err := cron.ParseStandard(cfg.Schedule.Cron)
+	if err != nil {
+		return nil, err
+	}
+	return &reportSchedule{cron: schedule, window: cfg.Schedule.Window, loc: loc}, nil
+}
+
+// NextWindow returns when the next polling window opens. If now is inside
+// a window whose report wasn't posted yet, say after a restart, that window
+// is still open and its start is returned. Windows of nights already
+// posted, e.g. from a notification before the window, are skipped.
+func (s *reportSchedule) NextWindow(now time.Time, posted func(date string) bool) time.Time {
+	now = now.In(s.loc)
+	if start := s.cron.Next(now.Add(-s.window)); !start.After(now) && !posted(start.Format(dateLayout)) {
+		return start
+	}
+	next := s.cron.Next(now)
+	for posted(next.Format(dateLayout)) {
+		next = s.cron.Next(next)
+	}
+	return next
+}
+
+// recentNight reports whether date, from a Fitbit notification, is today or
+// yesterday at now. Older nights synced late aren't worth a report.
+func recentNight(now time.Time, date string) bool {
+	return date == now.Format(dateLayout) || date == now.AddDate(0, 0, -1).Format(dateLayout)
+}
+
+// backoff is an exponential delay between retries after errors.
+type backoff struct {
+	min, max time.Duration
+	next     time.Duration
+}
+
+func newBackoff(cfg *Config) *backoff {
+	return &backoff{min: cfg.Schedule.BackoffMin, max: cfg.Schedule.BackoffMax}
+}
+
+// Next returns the delay before the next retry and doubles it, up to max.
+func (b *backoff) Next() time.Duration {
+	if b.next == 0 {
+		b.next = b.min
+	}
+	d := b.next
+	b.next = min(b.next*2, b.max)
+	return d
+}
+
+// Reset goes back to the shortest delay, after a success.
+func (b *backoff) Reset() {
+	b.next = 0
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/espcaa/random-workflows-that-actually-are-bots/slack"
+)
+
+// fakeClock only moves when the test fires one of the waits it hands out
+// on waits.
+type fakeClock struct {
+	mu    sync.Mutex
+	now   time.Time
+	waits chan fakeWait
+}
+
+type fakeWait struct {
+	d    time.Duration
+	fire chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now, waits: make(chan fakeWait)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	w := fakeWait{d: d, fire: make(chan time.Time, 1)}
+	c.waits <- w
+	return w.fire
+}
+
+// fire moves the clock to the end of w and wakes up its waiter.
+func (c *fakeClock) fire(w fakeWait) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(w.d)
+	w.fire <- c.now
+}
+
+func TestNextWindow(t *testing.T) {
+	loc := time.FixedZone("CEST", 2*60*60)
+	at := func(day, hour, min int) time.Time {
+		return time.Date(2026, 10, day, hour, min, 0, 0, loc)
+	}
+
+	tests := []struct {
+		name   string
+		cron   string
+		now    time.Time
+		posted []string
+		want   time.Time
+	}{
+		{"before the window", "0 7 * * *", at(14, 6, 0), nil, at(14, 7, 0)},
+		{"as it opens", "0 7 * * *", at(14, 7, 0), nil, at(14, 7, 0)},
+		{"inside, not posted", "0 7 * * *", at(14, 8, 30), nil, at(14, 7, 0)},
+		{"inside, posted", "0 7 * * *", at(14, 8, 30), []string{"2026-10-14"}, at(15, 7, 0)},
+		{"yesterday posted only", "0 7 * * *", at(14, 8, 30), []string{"2026-10-13"}, at(14, 7, 0)},
+		{"before the window, posted", "0 7 * * *", at(14, 6, 0), []string{"2026-10-14"}, at(15, 7, 0)},
+		{"as it closes", "0 7 * * *", at(14, 9, 0), nil, at(15, 7, 0)},
+		{"after the window", "0 7 * * *", at(14, 9, 30), nil, at(15, 7, 0)},
+		{"late in the evening", "0 7 * * *", at(14, 23, 59), nil, at(15, 7, 0)},
+		{"end of the month", "0 7 * * *", at(31, 12, 0), nil, time.Date(2026, 11, 1, 7, 0, 0, 0, loc)},
+		{"in another timezone", "0 7 * * *", time.Date(2026, 10, 14, 5, 30, 0, 0, time.UTC), nil, at(14, 7, 0)},
+		{"window across midnight", "30 23 * * *", at(15, 0, 45), nil, at(14, 23, 30)},
+		{"window across midnight, posted", "30 23 * * *", at(15, 0, 45), []string{"2026-10-14"}, at(15, 23, 30)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.Schedule.Cron = tt.cron
+			cfg.Schedule.Window = 2 * time.Hour
+			schedule, err := newReportSchedule(cfg, loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			posted := func(date string) bool {
+				for _, d := range tt.posted {
+					if d == date {
+						return true
+					}
+				}
+				return false
+			}
+			if got := schedule.NextWindow(tt.now, posted); !got.Equal(tt.want) {
+				t.Errorf("NextWindow(%s) = %s, want %s", tt.now, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.Schedule.BackoffMin = time.Minute
+	cfg.Schedule.BackoffMax = 5 * time.Minute
+	b := newBackoff(cfg)
+
+	want := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}
+	for i, w := range want {
+		if got := b.Next(); got != w {
+			t.Errorf("Next() #%d = %s, want %s", i+1, got, w)
+		}
+	}
+
+	b.Reset()
+	if got := b.Next(); got != time.Minute {
+		t.Errorf("Next() after Reset() = %s, want %s", got, time.Minute)
+	}
+}
+
+// TestReportBackoff runs a user's loop against a Fitbit that always fails
+// and checks the waits between the retries.
+func TestReportBackoff(t *testing.T) {
+	fitbit := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"errors":[{"errorType":"system","message":"down"}],"success":false}`))
+	}))
+	defer fitbit.Close()
+
+	cfg := defaultConfig()
+	cfg.Schedule.Cron = "0 7 * * *"
+	cfg.Schedule.Window = 20 * time.Minute
+	cfg.Schedule.BackoffMin = time.Minute
+	cfg.Schedule.BackoffMax = 4 * time.Minute
+
+	dir := t.TempDir()
+	ledger, err := LoadLedger(filepath.Join(dir, "sent.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err := OpenHistory(filepath.Join(dir, "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer history.Close()
+
+	u := &User{ID: "TESTUSER", Timezone: "UTC"}
+	c := &FitbitClient{
+		AccessToken: "token",
+		ExpiresAt:   time.Now().Add(24 * time.Hour),
+		UserID:      u.ID,
+		BaseURL:     fitbit.URL,
+		HTTPClient:  fitbit.Client(),
+	}
+	clock := newFakeClock(time.Date(2026, 10, 14, 7, 0, 0, 0, time.UTC))
+
+	// the loop never returns, it is left waiting for the next day
+	go runUserBot(cfg, u, c, slack.New(""), ledger, history, &FakeLLM{}, testGuardrails(t), &UserStatus{}, make(chan string), clock, false)
+
+	// doubling up to the max, then cut short by the end of the window at
+	// 7:20, then tomorrow's window
+	want := []time.Duration{
+		time.Minute, 2 * time.Minute, 4 * time.Minute, 4 * time.Minute, 4 * time.Minute, 4 * time.Minute, time.Minute,
+		23*time.Hour + 40*time.Minute,
+	}
+	var got []time.Duration
+	refreshing := false
+	for len(got) < len(want) || !refreshing {
+		select {
+		case w := <-clock.waits:
+			// the token refresh waits on the same clock, it never fires here
+			if w.d == tokenRefreshInterval {
+				refreshing = true
+				continue
+			}
+			got = append(got, w.d)
+			if len(got) < len(want) {
+				clock.fire(w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the loop stopped waiting, got %v so far, refreshing %t", got, refreshing)
+		}
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("waits = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("waits = %v, want %v", got, want)
+			break
+		}
+	}
+}
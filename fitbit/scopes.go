@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Feature is a part of the report that reads some Fitbit data, and so needs
+// the matching OAuth scopes.
+type Feature string
+
+const (
+	FeatureSleep     Feature = "sleep"
+	FeatureHeartRate Feature = "heartrate" // resting HR, sleeping HR and HRV
+	FeatureActivity  Feature = "activity"  // steps and exercise the day before
+
+	// nightly vitals, flagged when off the user's baseline
+	FeatureSpO2          Feature = "spo2"
+	FeatureSkinTemp      Feature = "skin_temp"
+	FeatureBreathingRate Feature = "breathing_rate"
+)
+
+// featureScopes lists the scopes each feature needs. Only the scopes of the
+// enabled features are requested when linking an account.
+var featureScopes = map[Feature][]string{
+	FeatureSleep:     {"sleep"},
+	FeatureHeartRate: {"heartrate"},
+	FeatureActivity:  {"activity"},
+
+	FeatureSpO2:          {"oxygen_saturation"},
+	FeatureSkinTemp:      {"temperature"},
+	FeatureBreathingRate: {"respiratory_rate"},
+}
+
+// ErrScopeNotGranted means the account didn't grant a scope a feature needs,
+// either found before calling Fitbit or from a 403 insufficient_scope.
+var ErrScopeNotGranted = errors.New("scope not granted")
+
+// requiredScopes is the sorted set of scopes the features need.
+func requiredScopes(features []Feature) []string {
+	seen := make(map[string]bool)
+	var scopes []string
+	for _, f := range features {
+		for _, scope := range featureScopes[f] {
+			if !seen[scope] {
+				seen[scope] = true
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	sort.Strings(scopes)
+	return scopes
+}
+
+// missingScopes returns the scopes of f the client's token doesn't have.
+// Tokens saved before scopes were tracked have no Scope and are assumed to
+// have everything, since the old setup asked for every scope.
+func missingScopes(client *FitbitClient, f Feature) []string {
+	client.mu.Lock()
+	granted := client.Scope
+	client.mu.Unlock()
+
+	if granted == "" {
+		return nil
+	}
+
+	have := make(map[string]bool)
+	for _, scope := range strings.Fields(granted) {
+		have[scope] = true
+	}
+
+	var missing []string
+	for _, scope := range featureScopes[f] {
+		if !have[scope] {
+			missing = append(missing, scope)
+		}
+	}
+	return missing
+}
+
+// checkFeature returns an error wrapping ErrScopeNotGranted if the account
+// can't be used for f.
+func checkFeature(client *FitbitClient, f Feature) error {
+	if missing := missingScopes(client, f); len(missing) > 0 {
+		return fmt.Errorf("%s needs the %s scope: %w", f, strings.Join(missing, ", "), ErrScopeNotGranted)
+	}
+	return nil
+}
+
+// featureOn reports whether f is enabled and the client's account granted
+// what it needs.
+func featureOn(cfg *Config, client *FitbitClient, f Feature) bool {
+	return slices.Contains(cfg.EnabledFeatures(), f) && checkFeature(client, f) == nil
+}
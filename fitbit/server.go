@@ -0,0 +1,182 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// serve runs the bot loops and the web server side by side: /login and
+// /callback to link accounts, /webhook for Fitbit's notifications, /healthz
+// and /status to watch the bot.
+func serve(cfg *Config) {
+	registry, err := openRegistry(cfg)
+	if err != nil {
+		log.Fatal("Error loading users: ", err)
+	}
+
+	ledger, err := openLedger(cfg)
+	if err != nil {
+		log.Fatal("Error loading sent reports: ", err)
+	}
+
+	history, err := openHistory(cfg)
+	if err != nil {
+		log.Fatal("Error opening the sleep history: ", err)
+	}
+	defer history.Close()
+
+	llm, err := newLLM(cfg)
+	if err != nil {
+		log.Fatal("Error setting up the AI provider: ", err)
+	}
+
+	guardrails, err := loadGuardrails(cfg)
+	if err != nil {
+		log.Fatal("Error loading the guardrails: ", err)
+	}
+
+	secret := *newSecretClient(cfg)
+
+	bot := newBot(cfg, registry, ledger, history, llm, guardrails, secret, realClock{}, false)
+	// accounts can be linked again from /login, so this isn't fatal here
+	if err := bot.StartAll(); err != nil {
+		log.Println("No Fitbit account could start, link them again:", err)
+	}
+
+	s := newServer(cfg, registry, secret, bot)
+
+	log.Println("Link a Fitbit account at", s.loginPageURL())
+
+	log.Fatal(http.ListenAndServe(":"+cfg.Server.Port, s.routes()))
+}
+
+// server hosts the account linking pages, plus the status page when it runs
+// alongside the bot.
+type server struct {
+	cfg      *Config
+	registry *Registry
+	secret   SecretClient
+	logins   *pendingLogins
+	bot      *Bot // nil in setup mode
+}
+
+func newServer(cfg *Config, registry *Registry, secret SecretClient, bot *Bot) *server {
+	return &server{
+		cfg:      cfg,
+		registry: registry,
+		secret:   secret,
+		logins:   newPendingLogins(),
+		bot:      bot,
+	}
+}
+
+func (s *server) routes() http.Handler {
+	r := chi.NewRouter()
+
+	r.Get("/login", s.handleLogin)
+	r.Get("/callback", s.handleCallback)
+	r.Get("/webhook", s.handleWebhookVerify)
+	r.Post("/webhook", s.handleWebhook)
+	r.Get("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	})
+	if s.bot != nil {
+		r.Get("/status", s.handleStatus)
+	}
+
+	return r
+}
+
+func (s *server) loginPageURL() string {
+	return strings.TrimSuffix(s.secret.CallbackURL, "/callback") + "/login"
+}
+
+// handleLogin starts a linking attempt with its own state and PKCE verifier.
+func (s *server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	loginURL, err := s.logins.Begin(s.secret)
+	if err != nil {
+		log.Println("Error starting login:", err)
+		writeErrorPage(w, http.StatusInternalServerError, "Couldn't start linking, try again in a bit.")
+		return
+	}
+
+	http.Redirect(w, r, loginURL, http.StatusFound)
+}
+
+func (s *server) handleCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	// the user declined, or Fitbit rejected the request
+	if errCode := query.Get("error"); errCode != "" {
+		log.Printf("Fitbit authorization failed: %s (%s)", errCode, query.Get("error_description"))
+		writeErrorPage(w, http.StatusBadRequest, "Fitbit didn't authorize the app, nothing was linked.")
+		return
+	}
+
+	verifier, ok := s.logins.Finish(query.Get("state"))
+	if !ok {
+		writeErrorPage(w, http.StatusBadRequest, "This link is unknown or expired, start again from /login.")
+		return
+	}
+
+	secret := s.secret
+	secret.CodeVerifier = verifier
+
+	// only used for the token exchange, the account gets its own store
+	client := newFitbitClient(s.cfg, secret, NewMemoryTokenStore(nil))
+
+	if user := HandleFitbitCallback(w, r, client, s.registry); user != nil && s.bot != nil {
+		s.bot.Start(user)
+	}
+}
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"when": func(t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return t.Format("2006-01-02 15:04 MST")
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>sleep bot status</title>
+</head>
+<body>
+    <h1>sleep bot status</h1>
+    <table border="1" cellpadding="4">
+        <tr><th>user</th><th>channel</th><th>last fetch</th><th>last post</th><th>token expiry</th><th>next run</th></tr>
+        {{range .}}
+        <tr>
+            <td>{{.User.ID}}</td>
+            <td>{{.User.Channel}}</td>
+            {{if .Running}}
+            <td>{{when .Status.LastFetch}}{{with .Status.LastFetchErr}}<br>error: {{.}}{{end}}</td>
+            <td>{{when .Status.LastPost}}{{with .Status.LastPostErr}}<br>error: {{.}}{{end}}</td>
+            <td>{{when .TokenExpiry}}</td>
+            <td>{{when .Status.NextRun}}</td>
+            {{else}}
+            <td colspan="4">not running, check the logs</td>
+            {{end}}
+        </tr>
+        {{else}}
+        <tr><td colspan="6">no accounts linked yet, go to <a href="/login">/login</a></td></tr>
+        {{end}}
+    </table>
+</body>
+</html>
+`))
+
+func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusTemplate.Execute(w, s.bot.Snapshot()); err != nil {
+		log.Println("Error rendering status page:", err)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+const fitbitTimeLayout = "2006-01-02T15:04:05.000"
+
+// Night is every sleep session Fitbit filed under one date: the main sleep
+// and any naps or split sleep around it.
+type Night struct {
+	Date string
+	// Main is the session Fitbit flagged with isMainSleep, or the longest
+	// one if none was.
+	Main *SleepLog
+	// Naps are the other sessions, in start order.
+	Naps []SleepLog
+
+	// Start and End span every session, from the first one falling asleep
+	// to the last one waking up.
+	Start, End time.Time
+	// TotalMillis is the duration of every session added up.
+	TotalMillis int64
+	// MinutesAsleep is the time asleep across every session.
+	MinutesAsleep int
+}
+
+// newNight groups the sessions of a date. It returns nil without sessions.
+func newNight(date string, logs []SleepLog) *Night {
+	if len(logs) == 0 {
+		return nil
+	}
+
+	sessions := append([]SleepLog(nil), logs...)
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].StartTime < sessions[j].StartTime })
+
+	mainSleep := 0
+	for i, s := range sessions {
+		if s.IsMainSleep {
+			mainSleep = i
+			break
+		}
+		if s.Duration > sessions[mainSleep].Duration {
+			mainSleep = i
+		}
+	}
+
+	night := &Night{Date: date}
+	for i := range sessions {
+		s := &sessions[i]
+		if i == mainSleep {
+			night.Main = s
+		} else {
+			night.Naps = append(night.Naps, *s)
+		}
+
+		night.TotalMillis += s.Duration
+		night.MinutesAsleep += s.MinutesAsleep
+
+		if start := s.Start(); night.Start.IsZero() || start.Before(night.Start) {
+			night.Start = start
+		}
+		if end := s.End(); end.After(night.End) {
+			night.End = end
+		}
+	}
+
+	return night
+}
+
+// nightsByDate groups a sleep range response by dateOfSleep.
+func nightsByDate(resp *FitbitSleepResponse) map[string]*Night {
+	byDate := make(map[string][]SleepLog)
+	if resp != nil {
+		for _, s := range resp.Sleep {
+			byDate[s.DateOfSleep] = append(byDate[s.DateOfSleep], s)
+		}
+	}
+
+	nights := make(map[string]*Night, len(byDate))
+	for date, logs := range byDate {
+		nights[date] = newNight(date, logs)
+	}
+	return nights
+}
+
+// Sessions is every session of the night, main sleep included, in start
+// order.
+func (n *Night) Sessions() []SleepLog {
+	sessions := append([]SleepLog{*n.Main}, n.Naps...)
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].StartTime < sessions[j].StartTime })
+	return sessions
+}
+
+func (n *Night) TotalHours() float64 {
+	return float64(n.TotalMillis) / (1000 * 60 * 60)
+}
+
+// Start is when the session began. Fitbit times are in the user's local
+// time without an offset, so they are kept as wall clock times.
+func (s *SleepLog) Start() time.Time {
+	t, _ := time.Parse(fitbitTimeLayout, s.StartTime)
+	return t
+}
+
+func (s *SleepLog) End() time.Time {
+	t, _ := time.Parse(fitbitTimeLayout, s.EndTime)
+	return t
+}
+
+func (s *SleepLog) Hours() float64 {
+	return float64(s.Duration) / (1000 * 60 * 60)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// session is a sleep log filed under 2026-10-14, from start for minutes.
+func session(logID int64, start string, minutes int, isMain bool) SleepLog {
+	begin, _ := time.Parse("2006-01-02 15:04", start)
+	return SleepLog{
+		LogID:         logID,
+		DateOfSleep:   "2026-10-14",
+		StartTime:     begin.Format(fitbitTimeLayout),
+		EndTime:       begin.Add(time.Duration(minutes) * time.Minute).Format(fitbitTimeLayout),
+		Duration:      (time.Duration(minutes) * time.Minute).Milliseconds(),
+		IsMainSleep:   isMain,
+		MinutesAsleep: minutes - 10,
+	}
+}
+
+func TestNewNight(t *testing.T) {
+	night := session(1, "2026-10-13 23:10", 472, true)
+	nap := session(2, "2026-10-14 14:00", 25, false)
+	longNap := session(3, "2026-10-14 13:00", 600, false)
+	early := session(4, "2026-10-13 21:00", 60, false)
+
+	tests := []struct {
+		name     string
+		logs     []SleepLog
+		wantMain int64
+		wantNaps []int64
+	}{
+		{"main sleep only", []SleepLog{night}, 1, nil},
+		{"main and a nap", []SleepLog{nap, night}, 1, []int64{2}},
+		// isMainSleep wins over a longer session, before or after it
+		{"nap longer than the main sleep", []SleepLog{longNap, night}, 1, []int64{3}},
+		{"longer nap after the main sleep", []SleepLog{early, night, longNap}, 1, []int64{4, 3}},
+		// without it, the longest one is the main sleep
+		{"no main sleep", []SleepLog{session(1, "2026-10-13 23:10", 472, false), nap, early}, 1, []int64{4, 2}},
+		{"no main sleep, longest last", []SleepLog{early, nap, longNap}, 3, []int64{4, 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n := newNight("2026-10-14", tt.logs)
+			if n == nil {
+				t.Fatal("newNight() = nil")
+			}
+			if n.Main.LogID != tt.wantMain {
+				t.Errorf("main sleep is %d, want %d", n.Main.LogID, tt.wantMain)
+			}
+			var naps []int64
+			for _, s := range n.Naps {
+				naps = append(naps, s.LogID)
+			}
+			if len(naps) != len(tt.wantNaps) {
+				t.Fatalf("naps = %v, want %v", naps, tt.wantNaps)
+			}
+			for i := range naps {
+				if naps[i] != tt.wantNaps[i] {
+					t.Errorf("naps = %v, want %v in start order", naps, tt.wantNaps)
+				}
+			}
+
+			var total int64
+			asleep := 0
+			for _, s := range tt.logs {
+				total += s.Duration
+				asleep += s.MinutesAsleep
+			}
+			if n.TotalMillis != total || n.MinutesAsleep != asleep {
+				t.Errorf("total %d ms and %d minutes asleep, want %d and %d", n.TotalMillis, n.MinutesAsleep, total, asleep)
+			}
+		})
+	}
+
+	if n := newNight("2026-10-14", nil); n != nil {
+		t.Errorf("newNight() without sessions = %+v, want nil", n)
+	}
+}
+
+func TestNightSpan(t *testing.T) {
+	n := newNight("2026-10-14", []SleepLog{
+		session(2, "2026-10-14 14:00", 25, false),
+		session(1, "2026-10-13 23:10", 472, true),
+	})
+	wantStart := time.Date(2026, 10, 13, 23, 10, 0, 0, time.UTC)
+	wantEnd := time.Date(2026, 10, 14, 14, 25, 0, 0, time.UTC)
+	if !n.Start.Equal(wantStart) || !n.End.Equal(wantEnd) {
+		t.Errorf("night spans %s to %s, want %s to %s", n.Start, n.End, wantStart, wantEnd)
+	}
+}
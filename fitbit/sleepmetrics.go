@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"fitbit-workflow/metrics"
+)
+
+// Metrics is the night as the metrics package sees it.
+func (n *Night) Metrics() metrics.Night {
+	m := metrics.Night{
+		Date:          n.Date,
+		Start:         n.Main.Start(),
+		End:           n.Main.End(),
+		MinutesAsleep: n.MinutesAsleep,
+	}
+
+	for _, s := range n.Sessions() {
+		session := metrics.Session{Start: s.Start(), End: s.End()}
+		for _, d := range s.Levels.Data {
+			start, _ := time.Parse(fitbitTimeLayout, d.DateTime)
+			session.Segments = append(session.Segments, metrics.Segment{Start: start, Duration: time.Duration(d.Seconds) * time.Second, Level: d.Level})
+		}
+		for _, d := range s.Levels.ShortData {
+			start, _ := time.Parse(fitbitTimeLayout, d.DateTime)
+			session.Segments = append(session.Segments, metrics.Segment{Start: start, Duration: time.Duration(d.Seconds) * time.Second, Level: d.Level})
+		}
+		m.Sessions = append(m.Sessions, session)
+	}
+
+	return m
+}
+
+// metricsNights converts nights for the metrics package, oldest first.
+func metricsNights(nights map[string]*Night) []metrics.Night {
+	var converted []metrics.Night
+	for _, n := range nights {
+		converted = append(converted, n.Metrics())
+	}
+	sort.Slice(converted, func(i, j int) bool { return converted[i].Date < converted[j].Date })
+	return converted
+}
+
+// sleepMetrics works out the metrics of night and the nights before it.
+func sleepMetrics(night *Night, earlier *FitbitSleepResponse, goalHours float64) metrics.Summary {
+	nights := nightsByDate(earlier)
+	nights[night.Date] = night
+
+	goal := time.Duration(goalHours * float64(time.Hour))
+	return metrics.Compute(metricsNights(nights), goal)
+}
+
+// metricsSummary is the compact line for the Slack message.
+func metricsSummary(m metrics.Summary) string {
+	parts := []string{
+		fmt.Sprintf("sleep debt %.1fh over %d nights", m.SleepDebt.Hours(), m.Nights),
+		fmt.Sprintf("bedtime %s ± %.0f min", m.Bedtime.Clock(), m.Bedtime.StdDev.Minutes()),
+	}
+	if m.RegularityPairs > 0 {
+		parts = append(parts, fmt.Sprintf("regularity %.0f/100", m.Regularity))
+	}
+	if m.HasSocialJetlag {
+		direction := "later"
+		if m.SocialJetlag < 0 {
+			direction = "earlier"
+		}
+		parts = append(parts, fmt.Sprintf("weekends %.1fh %s", m.SocialJetlag.Abs().Hours(), direction))
+	}
+	return joinSummary(parts)
+}
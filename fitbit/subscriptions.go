@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// FitbitNotification is one entry of a Subscriptions API webhook, sent when
+// a user's tracker syncs new data for a collection.
+type FitbitNotification struct {
+	CollectionType string `json:"collectionType"`
+	Date           string `json:"date"`
+	OwnerID        string `json:"ownerId"`
+	OwnerType      string `json:"ownerType"`
+	SubscriptionID string `json:"subscriptionId"`
+}
+
+// sleepSubscriptionID names a user's sleep subscription. It has to be
+// unique across the app's users.
+func sleepSubscriptionID(userID string) string {
+	return userID + "-sleep"
+}
+
+// subscribeSleep asks Fitbit to notify the app whenever the user syncs
+// sleep. Subscribing again is harmless. subscriberID picks the subscriber
+// endpoint set up in the dev console, empty means the default one.
+func subscribeSleep(client *FitbitClient, subscriberID string) error {
+	header := make(http.Header)
+	if subscriberID != "" {
+		header.Set("X-Fitbit-Subscriber-Id", subscriberID)
+	}
+
+	_, err := fitbitRequest(client, "POST", "/1/user/-/sleep/apiSubscriptions/"+sleepSubscriptionID(client.UserID)+".json", header)
+	if err != nil {
+		return fmt.Errorf("fitbit subscriptions endpoint: %w", err)
+	}
+	return nil
+}
+
+// subscribeCommand implements `subscribe`: it registers the sleep
+// subscription of every linked user.
+func subscribeCommand(cfg *Config) error {
+	registry, err := openRegistry(cfg)
+	if err != nil {
+		return err
+	}
+
+	secret := *newSecretClient(cfg)
+
+	var errs []error
+	for _, u := range registry.Users() {
+		client, err := registry.Client(u, secret)
+		if err == nil {
+			err = subscribeSleep(client, cfg.Fitbit.SubscriberID)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", u.ID, err))
+			continue
+		}
+		log.Printf("[%s] Subscribed to sleep notifications", u.ID)
+	}
+
+	return errors.Join(errs...)
+}
+
+// validFitbitSignature checks X-Fitbit-Signature, the base64 HMAC-SHA1 of
+// the body keyed with the client secret and a trailing "&".
+func validFitbitSignature(body []byte, signature, clientSecret string) bool {
+	got, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha1.New, []byte(clientSecret+"&"))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
+
+// handleWebhookVerify answers the challenge Fitbit sends when a subscriber
+// endpoint is added or checked: 204 for the right code, 404 otherwise.
+func (s *server) handleWebhookVerify(w http.ResponseWriter, r *http.Request) {
+	code := s.cfg.Fitbit.VerificationCode
+	if code == "" || !hmac.Equal([]byte(r.URL.Query().Get("verify")), []byte(code)) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleWebhook takes Fitbit's notifications and wakes the loops of the
+// users who synced sleep. Fitbit wants an answer within a few seconds, so
+// the reports themselves are left to the loops.
+func (s *server) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// Fitbit's docs ask for a 404 when the signature doesn't match
+	if !validFitbitSignature(body, r.Header.Get("X-Fitbit-Signature"), s.secret.Secret) {
+		log.Println("Ignoring Fitbit notification with a bad signature")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	var notifications []FitbitNotification
+	if err := json.Unmarshal(body, &notifications); err != nil {
+		log.Println("Error parsing Fitbit notification:", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	if s.bot == nil {
+		return
+	}
+	for _, n := range notifications {
+		if n.CollectionType == "sleep" {
+			s.bot.Notify(n.OwnerID, n.Date)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"fitbit-workflow/fakefitbit"
+
+	"github.com/espcaa/random-workflows-that-actually-are-bots/slack"
+)
+
+const (
+	testNotification = `[{"collectionType":"sleep","date":"2026-10-14","ownerId":"FAKEUSER","ownerType":"user","subscriptionId":"FAKEUSER-sleep"}]`
+	// the HMAC-SHA1 of testNotification keyed with "secret&"
+	testSignature = "VmlfvHN/BcBze4/UpAijud7Xsik="
+)
+
+func TestValidFitbitSignature(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		signature string
+		want      bool
+	}{
+		{"valid", testNotification, testSignature, true},
+		{"keyed without the &", testNotification, "k8wtRX9bEUK2yurRHScL/GNGlIo=", false},
+		{"other body", strings.Replace(testNotification, "2026-10-14", "2026-10-15", 1), testSignature, false},
+		{"not base64", testNotification, "not base64!", false},
+		{"missing", testNotification, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := validFitbitSignature([]byte(tt.body), tt.signature, "secret"); got != tt.want {
+				t.Errorf("validFitbitSignature() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhookVerify(t *testing.T) {
+	tests := []struct {
+		name   string
+		code   string
+		verify string
+		want   int
+	}{
+		{"right code", "c0ffee", "c0ffee", http.StatusNoContent},
+		{"wrong code", "c0ffee", "nope", http.StatusNotFound},
+		{"no code sent", "c0ffee", "", http.StatusNotFound},
+		{"no code set up", "", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig(t, "http://localhost")
+			cfg.Fitbit.VerificationCode = tt.code
+			s := newServer(cfg, nil, *newSecretClient(cfg), nil)
+
+			w := httptest.NewRecorder()
+			s.routes().ServeHTTP(w, httptest.NewRequest("GET", "/webhook?verify="+tt.verify, nil))
+			if w.Code != tt.want {
+				t.Errorf("GET /webhook?verify=%s answered %d, want %d", tt.verify, w.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		signature string
+		want      int
+		notified  string
+	}{
+		{"sleep synced", testNotification, testSignature, http.StatusNoContent, "2026-10-14"},
+		{"bad signature", testNotification, "k8wtRX9bEUK2yurRHScL/GNGlIo=", http.StatusNotFound, ""},
+		{"no signature", testNotification, "", http.StatusNotFound, ""},
+		{"not json", "nope", "rncDSub3MFRQA5KYR6lE54UKcKQ=", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := testBot(t, "http://localhost", &FakeLLM{})
+			notify := make(chan string, 1)
+			b.notify[fakefitbit.UserID] = notify
+			s := newServer(b.cfg, b.registry, b.secret, b)
+
+			r := httptest.NewRequest("POST", "/webhook", strings.NewReader(tt.body))
+			if tt.signature != "" {
+				r.Header.Set("X-Fitbit-Signature", tt.signature)
+			}
+			w := httptest.NewRecorder()
+			s.routes().ServeHTTP(w, r)
+			if w.Code != tt.want {
+				t.Errorf("POST /webhook answered %d, want %d", w.Code, tt.want)
+			}
+
+			var notified string
+			select {
+			case notified = <-notify:
+			default:
+			}
+			if notified != tt.notified {
+				t.Errorf("the loop was notified of %q, want %q", notified, tt.notified)
+			}
+		})
+	}
+}
+
+func TestSubscribeSleep(t *testing.T) {
+	fake, srv := fakefitbit.NewServer()
+	defer srv.Close()
+
+	c := fakeClient(t, testConfig(t, srv.URL))
+	// subscribing twice is harmless
+	for range 2 {
+		if err := subscribeSleep(c, "1"); err != nil {
+			t.Fatalf("subscribeSleep() error = %v", err)
+		}
+	}
+
+	subscriptions := fake.Subscriptions()
+	if len(subscriptions) != 1 || subscriptions[fakefitbit.UserID+"-sleep"] != "sleep" {
+		t.Errorf("subscriptions = %v, want the user's sleep", subscriptions)
+	}
+}
+
+// TestNotifyOutsideWindow checks a night synced before the window opens is
+// reported when the notification comes in, not at the window.
+func TestNotifyOutsideWindow(t *testing.T) {
+	_, srv := fakefitbit.NewServer()
+	defer srv.Close()
+
+	slackAPI := &fakeSlack{}
+	slackSrv := httptest.NewServer(slackAPI)
+	defer slackSrv.Close()
+
+	cfg := testConfig(t, srv.URL)
+	cfg.Schedule.Cron = "0 7 * * *"
+	cfg.Schedule.Window = time.Hour
+
+	ledger, err := openLedger(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history, err := OpenHistory(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer history.Close()
+
+	slackClient := slack.New("xoxb-test")
+	slackClient.BaseURL = slackSrv.URL
+
+	u := &User{ID: fakefitbit.UserID, Timezone: "UTC", Channel: "C123"}
+	clock := newFakeClock(time.Date(2026, 10, 14, 5, 30, 0, 0, time.UTC))
+	notify := make(chan string, 1)
+
+	go runUserBot(cfg, u, fakeClient(t, cfg), slackClient, ledger, history, &FakeLLM{}, testGuardrails(t), &UserStatus{}, notify, clock, false)
+
+	// the waits handed out, leaving the token refresh alone
+	nextWait := func() time.Duration {
+		t.Helper()
+		for {
+			select {
+			case w := <-clock.waits:
+				if w.d != tokenRefreshInterval {
+					return w.d
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("the loop stopped waiting")
+			}
+		}
+	}
+
+	if got := nextWait(); got != 90*time.Minute {
+		t.Fatalf("first wait = %s, want until the window at 7:00", got)
+	}
+
+	// last week's night synced late, today's early
+	notify <- "2026-10-07"
+	if got := nextWait(); got != 90*time.Minute {
+		t.Fatalf("wait after an old night = %s, want still until the window", got)
+	}
+	if calls := slackAPI.calls(); len(calls) > 0 {
+		t.Fatalf("Slack calls = %v after an old night, want none", calls)
+	}
+
+	notify <- "2026-10-14"
+	if got := nextWait(); got != 25*time.Hour+30*time.Minute {
+		t.Errorf("wait after the report = %s, want until tomorrow's window", got)
+	}
+	if !ledger.Posted(u.ID, "2026-10-14") {
+		t.Error("the synced night isn't in the ledger")
+	}
+	if calls := slackAPI.calls(); len(calls) != 1 || calls[0] != "chat.postMessage" {
+		t.Errorf("Slack calls = %v, want one chat.postMessage", calls)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/espcaa/random-workflows-that-actually-are-bots/tokenfile"
+)
+
+// ErrNoToken is returned by a TokenStore that has nothing saved yet.
+var ErrNoToken = errors.New("no token stored")
+
+// TokenStore persists the Fitbit token between runs. Fitbit refresh tokens
+// are single-use, so every successful refresh must be saved before the old
+// token is forgotten.
+type TokenStore interface {
+	Load() (*FitbitTokenResponse, error)
+	Save(token *FitbitTokenResponse) error
+}
+
+// FileTokenStore keeps the token as JSON on disk, encrypted when Key is set.
+// Writes go to a temp file that is synced and renamed over the old one, so a
+// crash leaves either the old or the new token, never half of one. The
+// previous token is kept next to it with a .bak suffix.
+type FileTokenStore struct {
+	Path string
+	Key  []byte
+}
+
+func NewFileTokenStore(path string, key []byte) *FileTokenStore {
+	return &FileTokenStore{Path: path, Key: key}
+}
+
+func (s *FileTokenStore) backupPath() string {
+	return s.Path + ".bak"
+}
+
+func (s *FileTokenStore) Load() (*FitbitTokenResponse, error) {
+	data, err := tokenfile.Read(s.Path, s.Key)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%s: %w", s.Path, ErrNoToken)
+	}
+	if errors.Is(err, tokenfile.ErrNoKey) || errors.Is(err, tokenfile.ErrBadKey) {
+		return nil, fmt.Errorf("%s: %w", s.Path, err)
+	}
+	if err != nil {
+		return nil, s.corrupted(err)
+	}
+
+	var token FitbitTokenResponse
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, s.corrupted(err)
+	}
+	if token.AccessToken == "" || token.RefreshToken == "" {
+		return nil, s.corrupted(errors.New("access or refresh token missing"))
+	}
+
+	return &token, nil
+}
+
+func (s *FileTokenStore) corrupted(err error) error {
+	return fmt.Errorf("%s is corrupted (%v), restore it from %s or run setup again", s.Path, err, s.backupPath())
+}
+
+func (s *FileTokenStore) Save(token *FitbitTokenResponse) error {
+	data, err := json.MarshalIndent(token, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := s.backup(); err != nil {
+		return fmt.Errorf("backing up %s: %w", s.Path, err)
+	}
+
+	return tokenfile.Write(s.Path, s.Key, data)
+}
+
+// backup copies the current token file to the .bak path, if there is one,
+// as is (so an encrypted token stays encrypted).
+func (s *FileTokenStore) backup() error {
+	current, err := os.Open(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer current.Close()
+
+	data, err := io.ReadAll(current)
+	if err != nil {
+		return err
+	}
+
+	return tokenfile.WriteAtomic(s.backupPath(), data)
+}
+
+// tokensCommand implements `tokens`. Without a file, encrypt and decrypt go
+// over every user's token file and its backup, so no plaintext copy is left
+// behind.
+func tokensCommand(cfg *Config, args []string) error {
+	if len(args) != 1 || args[0] == "keygen" {
+		return tokenfile.Command(args)
+	}
+
+	registry, err := openRegistry(cfg)
+	if err != nil {
+		return err
+	}
+	return tokenfile.Command(args, registry.TokenFiles()...)
+}
+
+// MemoryTokenStore keeps the token in memory, for tests and dry runs.
+type MemoryTokenStore struct {
+	mu    sync.Mutex
+	token *FitbitTokenResponse
+}
+
+func NewMemoryTokenStore(token *FitbitTokenResponse) *MemoryTokenStore {
+	return &MemoryTokenStore{token: token}
+}
+
+func (s *MemoryTokenStore) Load() (*FitbitTokenResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token == nil {
+		return nil, ErrNoToken
+	}
+	token := *s.token
+	return &token, nil
+}
+
+func (s *MemoryTokenStore) Save(token *FitbitTokenResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	saved := *token
+	s.token = &saved
+	return nil
+}
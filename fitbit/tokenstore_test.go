@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/espcaa/random-workflows-that-actually-are-bots/tokenfile"
+)
+
+func testToken(access string) *FitbitTokenResponse {
+	return &FitbitTokenResponse{
+		AccessToken:  access + "-access",
+		RefreshToken: access + "-refresh",
+		ExpiresIn:    28800,
+		UserID:       "TESTUSER",
+		Scope:        "sleep",
+	}
+}
+
+func TestFileTokenStore(t *testing.T) {
+	dir := t.TempDir()
+	store := NewFileTokenStore(filepath.Join(dir, "tokens.json"), nil)
+
+	if _, err := store.Load(); !errors.Is(err, ErrNoToken) {
+		t.Fatalf("Load() before any Save, error = %v, want ErrNoToken", err)
+	}
+
+	if err := store.Save(testToken("first")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(store.backupPath()); !os.IsNotExist(err) {
+		t.Error("the first Save made a backup of nothing")
+	}
+
+	if err := store.Save(testToken("second")); err != nil {
+		t.Fatal(err)
+	}
+	token, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "second-access" || token.RefreshToken != "second-refresh" || token.Scope != "sleep" {
+		t.Errorf("Load() = %+v, want the second token", token)
+	}
+
+	// the backup is the token before
+	backup, err := NewFileTokenStore(store.backupPath(), nil).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backup.RefreshToken != "first-refresh" {
+		t.Errorf("backup has %s, want the first token", backup.RefreshToken)
+	}
+
+	info, err := os.Stat(store.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("token file has mode %o, want 600", perm)
+	}
+
+	// the atomic writes leave no temp file behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name() != "tokens.json" && e.Name() != "tokens.json.bak" {
+			t.Errorf("left %s behind", e.Name())
+		}
+	}
+}
+
+func TestFileTokenStoreEncrypted(t *testing.T) {
+	encoded, err := tokenfile.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TOKENS_ENCRYPTION_KEY", encoded)
+	t.Setenv("TOKENS_ENCRYPTION_KEY_FILE", "")
+	key, err := tokenfile.LoadKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	store := NewFileTokenStore(path, key)
+	for _, access := range []string{"first", "second"} {
+		if err := store.Save(testToken(access)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the token and its backup
+	for _, p := range []string{path, store.backupPath()} {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !tokenfile.IsEncrypted(data) {
+			t.Errorf("%s is in plaintext", filepath.Base(p))
+		}
+	}
+
+	if token, err := store.Load(); err != nil || token.AccessToken != "second-access" {
+		t.Errorf("Load() = %+v, %v, want the second token", token, err)
+	}
+
+	// a wrong or missing key isn't a corrupted file
+	otherKey, _ := tokenfile.GenerateKey()
+	t.Setenv("TOKENS_ENCRYPTION_KEY", otherKey)
+	wrongKey, err := tokenfile.LoadKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileTokenStore(path, wrongKey).Load(); !errors.Is(err, tokenfile.ErrBadKey) {
+		t.Errorf("Load() with the wrong key, error = %v, want ErrBadKey", err)
+	}
+	if _, err := NewFileTokenStore(path, nil).Load(); !errors.Is(err, tokenfile.ErrNoKey) {
+		t.Errorf("Load() without a key, error = %v, want ErrNoKey", err)
+	}
+}
+
+func TestFileTokenStoreCorrupted(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"cut short", `{"access_token":"a","refresh_tok`},
+		{"not json", "<html>"},
+		{"no refresh token", `{"access_token":"a"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tokens.json")
+			if err := os.WriteFile(path, []byte(tt.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := NewFileTokenStore(path, nil).Load()
+			if err == nil {
+				t.Fatal("Load() error = nil")
+			}
+			// where it is, and how to get out of it
+			for _, want := range []string{path + " is corrupted", path + ".bak", "run setup again"} {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Load() error = %q, want it to mention %q", err, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Vital is one nightly measurement next to the user's own baseline, the
+// nights of the month before.
+type Vital struct {
+	Name  string
+	Unit  string
+	Value float64
+
+	Baseline float64 // mean of the baseline nights
+	StdDev   float64
+	Nights   int // nights in the baseline, too few and nothing is flagged
+
+	// Flagged is set when the night is notably off the baseline.
+	Flagged bool
+
+	format string
+}
+
+// Deviation is how far the night is from the baseline.
+func (v Vital) Deviation() float64 {
+	return v.Value - v.Baseline
+}
+
+// vitalSpec describes one of Fitbit's nightly vitals endpoints.
+type vitalSpec struct {
+	feature Feature
+	name    string
+	unit    string
+	format  string
+	// endpoint is the range path, under /1/user/<id>/
+	endpoint string
+	// minDelta keeps tiny but consistent baselines from flagging noise
+	minDelta float64
+	parse    func(body []byte) (map[string]float64, error)
+}
+
+// minBaselineNights is how many nights a baseline needs before anything is
+// flagged.
+const minBaselineNights = 7
+
+var vitalSpecs = []vitalSpec{
+	{
+		feature:  FeatureSpO2,
+		name:     "SpO2",
+		unit:     "%",
+		format:   "%.1f",
+		endpoint: "spo2/date",
+		minDelta: 1.5,
+		parse: func(body []byte) (map[string]float64, error) {
+			var days []struct {
+				DateTime string `json:"dateTime"`
+				Value    struct {
+					Avg float64 `json:"avg"`
+				} `json:"value"`
+			}
+			if err := json.Unmarshal(body, &days); err != nil {
+				return nil, err
+			}
+			values := make(map[string]float64)
+			for _, d := range days {
+				values[d.DateTime] = d.Value.Avg
+			}
+			return values, nil
+		},
+	},
+	{
+		feature:  FeatureSkinTemp,
+		name:     "skin temperature",
+		unit:     "°C",
+		format:   "%+.1f",
+		endpoint: "temp/skin/date",
+		minDelta: 0.5,
+		parse: func(body []byte) (map[string]float64, error) {
+			var resp struct {
+				TempSkin []struct {
+					DateTime string `json:"dateTime"`
+					Value    struct {
+						NightlyRelative float64 `json:"nightlyRelative"`
+					} `json:"value"`
+				} `json:"tempSkin"`
+			}
+			if err := json.Unmarshal(body, &resp); err != nil {
+				return nil, err
+			}
+			values := make(map[string]float64)
+			for _, d := range resp.TempSkin {
+				values[d.DateTime] = d.Value.NightlyRelative
+			}
+			return values, nil
+		},
+	},
+	{
+		feature:  FeatureBreathingRate,
+		name:     "breathing rate",
+		unit:     "/min",
+		format:   "%.1f",
+		endpoint: "br/date",
+		minDelta: 1.5,
+		parse: func(body []byte) (map[string]float64, error) {
+			var resp struct {
+				BR []struct {
+					DateTime string `json:"dateTime"`
+					Value    struct {
+						BreathingRate float64 `json:"breathingRate"`
+					} `json:"value"`
+				} `json:"br"`
+			}
+			if err := json.Unmarshal(body, &resp); err != nil {
+				return nil, err
+			}
+			values := make(map[string]float64)
+			for _, d := range resp.BR {
+				values[d.DateTime] = d.Value.BreathingRate
+			}
+			return values, nil
+		},
+	},
+}
+
+// getVitalRange returns the spec's nightly values by date. Fitbit caps these
+// ranges at 30 days.
+func getVitalRange(client *FitbitClient, spec vitalSpec, startDate, endDate string) (map[string]float64, error) {
+	body, err := fitbitGet(client, "/1/user/"+client.UserID+"/"+spec.endpoint+"/"+startDate+"/"+endDate+".json")
+	if err != nil {
+		return nil, fmt.Errorf("fitbit %s endpoint: %w", spec.name, err)
+	}
+	return spec.parse(body)
+}
+
+// getVital returns a night's value of spec with its baseline, or nil if
+// the tracker didn't record it that night.
+func getVital(client *FitbitClient, spec vitalSpec, date string) (*Vital, error) {
+	day, err := time.Parse(dateLayout, date)
+	if err != nil {
+		return nil, err
+	}
+
+	night, err := getVitalRange(client, spec, date, date)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := night[date]
+	if !ok {
+		return nil, nil
+	}
+
+	month, err := getVitalRange(client, spec, day.AddDate(0, 0, -30).Format(dateLayout), day.AddDate(0, 0, -1).Format(dateLayout))
+	if err != nil {
+		return nil, err
+	}
+	var baseline []float64
+	for _, v := range month {
+		baseline = append(baseline, v)
+	}
+
+	vital := &Vital{
+		Name:     spec.name,
+		Unit:     spec.unit,
+		Value:    value,
+		Baseline: mean(baseline),
+		StdDev:   stdDev(baseline),
+		Nights:   len(baseline),
+		format:   spec.format,
+	}
+	// two standard deviations out, and more than the spec's minimum
+	vital.Flagged = vital.Nights >= minBaselineNights &&
+		math.Abs(vital.Deviation()) > max(2*vital.StdDev, spec.minDelta)
+
+	return vital, nil
+}
+
+// getVitals gathers the vitals of every enabled and granted feature. Like
+// getHeartData, the error lists what failed and the rest is still returned.
+func getVitals(cfg *Config, client *FitbitClient, date string) ([]Vital, error) {
+	var vitals []Vital
+	var errs []error
+	for _, spec := range vitalSpecs {
+		if !featureOn(cfg, client, spec.feature) {
+			continue
+		}
+		vital, err := getVital(client, spec, date)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if vital != nil {
+			vitals = append(vitals, *vital)
+		}
+	}
+	return vitals, errors.Join(errs...)
+}
+
+// String is the vital as shown to people, e.g. "SpO2 93.1%".
+func (v Vital) String() string {
+	return v.Name + " " + v.FormattedValue()
+}
+
+func (v Vital) formatValue(value float64) string {
+	// no "-0.0"
+	if math.Abs(value) < 0.05 {
+		value = 0
+	}
+	return fmt.Sprintf(v.format, value) + v.Unit
+}
+
+// FormattedValue and FormattedBaseline are the numbers as shown to people.
+func (v Vital) FormattedValue() string {
+	return v.formatValue(v.Value)
+}
+
+func (v Vital) FormattedBaseline() string {
+	return v.formatValue(v.Baseline)
+}
+
+// vitalsSummary is the Slack line for the flagged vitals, empty if none is.
+func vitalsSummary(vitals []Vital) string {
+	var parts []string
+	for _, v := range vitals {
+		if v.Flagged {
+			parts = append(parts, fmt.Sprintf("%s (usually %s)", v.String(), v.FormattedBaseline()))
+		}
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "Off from the usual: " + strings.Join(parts, ", ")
+}
+
+func stdDev(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	avg := mean(values)
+	var sum float64
+	for _, v := range values {
+		sum += (v - avg) * (v - avg)
+	}
+	return math.Sqrt(sum / float64(len(values)-1))
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"fitbit-workflow/fakefitbit"
+)
+
+func vitalSpecOf(t *testing.T, f Feature) vitalSpec {
+	t.Helper()
+
+	for _, spec := range vitalSpecs {
+		if spec.feature == f {
+			return spec
+		}
+	}
+	t.Fatalf("no vital for %s", f)
+	return vitalSpec{}
+}
+
+func TestVitalsParse(t *testing.T) {
+	tests := []struct {
+		feature Feature
+		body    string
+		want    map[string]float64
+	}{
+		{FeatureSpO2, `[{"dateTime":"2026-10-13","value":{"avg":96.4,"min":94.1,"max":99.0}},{"dateTime":"2026-10-14","value":{"avg":95.8,"min":92.0,"max":98.7}}]`, map[string]float64{"2026-10-13": 96.4, "2026-10-14": 95.8}},
+		{FeatureSkinTemp, `{"tempSkin":[{"dateTime":"2026-10-14","value":{"nightlyRelative":-0.7},"logType":"dedicated_temp_sensor"}]}`, map[string]float64{"2026-10-14": -0.7}},
+		{FeatureBreathingRate, `{"br":[{"dateTime":"2026-10-14","value":{"breathingRate":15.2}}]}`, map[string]float64{"2026-10-14": 15.2}},
+
+		// what Fitbit answers for nights without a reading
+		{FeatureSpO2, `[]`, map[string]float64{}},
+		{FeatureSkinTemp, `{"tempSkin":[]}`, map[string]float64{}},
+		{FeatureBreathingRate, `{"br":[]}`, map[string]float64{}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.feature)+" "+tt.body, func(t *testing.T) {
+			got, err := vitalSpecOf(t, tt.feature).parse([]byte(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("parse() = %v, want %v", got, tt.want)
+			}
+			for date, v := range tt.want {
+				if got[date] != v {
+					t.Errorf("parse() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	for _, spec := range vitalSpecs {
+		if _, err := spec.parse([]byte(`{"errors":[`)); err == nil {
+			t.Errorf("%s parse() of broken JSON, error = nil", spec.name)
+		}
+	}
+}
+
+// vitalsConfig is a config with every vital on.
+func vitalsConfig(t *testing.T, apiURL string) *Config {
+	cfg := testConfig(t, apiURL)
+	cfg.Fitbit.Features = []Feature{FeatureSpO2, FeatureSkinTemp, FeatureBreathingRate}
+	return cfg
+}
+
+func vitalsByName(vitals []Vital) map[string]Vital {
+	byName := make(map[string]Vital)
+	for _, v := range vitals {
+		byName[v.Name] = v
+	}
+	return byName
+}
+
+func TestGetVitals(t *testing.T) {
+	fake, srv := fakefitbit.NewServer()
+	defer srv.Close()
+
+	cfg := vitalsConfig(t, srv.URL)
+	c := fakeClient(t, cfg)
+
+	vitals, err := getVitals(cfg, c, testNightDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName := vitalsByName(vitals)
+	// the fake's usual night
+	for name, want := range map[string]string{"SpO2": "96.6%", "skin temperature": "-0.3°C", "breathing rate": "15.5/min"} {
+		v, ok := byName[name]
+		if !ok {
+			t.Errorf("no %s in %v", name, vitals)
+			continue
+		}
+		if v.FormattedValue() != want || v.Nights != 30 || v.Flagged {
+			t.Errorf("%s = %s over %d nights, flagged %t, want %s over 30 and not flagged", name, v.FormattedValue(), v.Nights, v.Flagged, want)
+		}
+	}
+	if line := vitalsSummary(vitals); line != "" {
+		t.Errorf("vitalsSummary() of a usual night = %q", line)
+	}
+
+	// a feverish night with a dip in SpO2
+	fake.SetVitals(testNightDate, fakefitbit.Vitals{SpO2: 91, SkinTemp: 1.2, BreathingRate: 15})
+	vitals, err = getVitals(cfg, c, testNightDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byName = vitalsByName(vitals)
+	if !byName["SpO2"].Flagged || !byName["skin temperature"].Flagged || byName["breathing rate"].Flagged {
+		t.Errorf("vitals = %+v, want SpO2 and skin temperature flagged", vitals)
+	}
+	if want := "Off from the usual: SpO2 91.0% (usually 96.6%), skin temperature +1.2°C (usually +0.0°C)"; vitalsSummary(vitals) != want {
+		t.Errorf("vitalsSummary() = %q, want %q", vitalsSummary(vitals), want)
+	}
+
+	// not enough nights to know what's usual
+	day, _ := time.Parse(dateLayout, testNightDate)
+	var away []string
+	for i := 1; i <= 30-minBaselineNights+1; i++ {
+		away = append(away, day.AddDate(0, 0, -i).Format(dateLayout))
+	}
+	fake.SetNotWorn(away...)
+	vitals, err = getVitals(cfg, c, testNightDate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range vitals {
+		if v.Nights != minBaselineNights-1 || v.Flagged {
+			t.Errorf("%s over %d nights, flagged %t, want %d nights and nothing flagged", v.Name, v.Nights, v.Flagged, minBaselineNights-1)
+		}
+	}
+}
+
+func TestGetVitalsNotWorn(t *testing.T) {
+	fake, srv := fakefitbit.NewServer()
+	defer srv.Close()
+
+	cfg := vitalsConfig(t, srv.URL)
+	c := fakeClient(t, cfg)
+
+	fake.SetNotWorn(testNightDate)
+	vitals, err := getVitals(cfg, c, testNightDate)
+	if err != nil || len(vitals) != 0 {
+		t.Errorf("getVitals() of a night off the wrist = %v, %v, want none and no error", vitals, err)
+	}
+}
+
+func TestGetVitalsFeatures(t *testing.T) {
+	fake, srv := fakefitbit.NewServer()
+	defer srv.Close()
+
+	// only what's enabled is asked for
+	cfg := testConfig(t, srv.URL)
+	cfg.Fitbit.Features = []Feature{FeatureBreathingRate}
+	c := fakeClient(t, cfg)
+	vitals, err := getVitals(cfg, c, testNightDate)
+	if err != nil || len(vitals) != 1 || vitals[0].Name != "breathing rate" {
+		t.Errorf("getVitals() with breathing_rate only = %v, %v", vitals, err)
+	}
+
+	// a failing one doesn't take the others down
+	cfg = vitalsConfig(t, srv.URL)
+	fake.RevokeScope("oxygen_saturation")
+	vitals, err = getVitals(cfg, c, testNightDate)
+	if !errors.Is(err, ErrScopeNotGranted) {
+		t.Errorf("getVitals() without oxygen_saturation, error = %v, want ErrScopeNotGranted", err)
+	}
+	if byName := vitalsByName(vitals); len(vitals) != 2 || byName["SpO2"].Name != "" {
+		t.Errorf("getVitals() without oxygen_saturation = %v, want the other two", vitals)
+	}
+}
+
+func TestVitalFormat(t *testing.T) {
+	tests := []struct {
+		vital Vital
+		want  string
+	}{
+		{Vital{Name: "SpO2", Unit: "%", Value: 95.96, format: "%.1f"}, "SpO2 96.0%"},
+		{Vital{Name: "skin temperature", Unit: "°C", Value: 0.74, format: "%+.1f"}, "skin temperature +0.7°C"},
+		{Vital{Name: "skin temperature", Unit: "°C", Value: -0.74, format: "%+.1f"}, "skin temperature -0.7°C"},
+		// no "-0.0"
+		{Vital{Name: "skin temperature", Unit: "°C", Value: -0.04, format: "%+.1f"}, "skin temperature +0.0°C"},
+		{Vital{Name: "breathing rate", Unit: "/min", Value: 14.25, format: "%.1f"}, "breathing rate 14.2/min"},
+	}
+	for _, tt := range tests {
+		if got := tt.vital.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestReportVitalsLine(t *testing.T) {
+	fake, srv := fakefitbit.NewServer()
+	defer srv.Close()
+
+	if text := reportText(t, vitalsConfig(t, srv.URL), testNightDate); strings.Contains(text, "Off from the usual") {
+		t.Errorf("report of a usual night = %q, want no vitals line", text)
+	}
+
+	fake.SetVitals(testNightDate, fakefitbit.Vitals{SpO2: 96.6, SkinTemp: 1.2, BreathingRate: 19})
+	text := reportText(t, vitalsConfig(t, srv.URL), testNightDate)
+	if !strings.Contains(text, "\nOff from the usual: skin temperature +1.2°C (usually +0.0°C), breathing rate 19.0/min (usually ") {
+		t.Errorf("report = %q, want the flagged vitals", text)
+	}
+}
@@ -3,15 +3,21 @@ module skolengo-bot
 go 1.24.0
 
 require (
+	github.com/espcaa/random-workflows-that-actually-are-bots/slack v0.0.0
+	github.com/espcaa/random-workflows-that-actually-are-bots/tokenfile v0.0.0
 	github.com/espcaa/skolen-go v0.1.6
 	github.com/joho/godotenv v1.5.1
+	github.com/robfig/cron/v3 v3.0.0
 )
 
 require (
 	github.com/coreos/go-oidc/v3 v3.15.0 // indirect
 	github.com/go-jose/go-jose/v4 v4.1.2 // indirect
 	github.com/golang-jwt/jwt/v4 v4.5.2 // indirect
-	github.com/robfig/cron/v3 v3.0.0 // indirect
 	golang.org/x/crypto v0.42.0 // indirect
 	golang.org/x/oauth2 v0.31.0 // indirect
 )
+
+replace github.com/espcaa/random-workflows-that-actually-are-bots/slack => ../slack
+
+replace github.com/espcaa/random-workflows-that-actually-are-bots/tokenfile => ../tokenfile
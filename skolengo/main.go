@@ -1,30 +1,45 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"os"
-	"strings"
 	"time"
 
+	"github.com/espcaa/random-workflows-that-actually-are-bots/slack"
+	"github.com/espcaa/random-workflows-that-actually-are-bots/tokenfile"
 	skolengo "github.com/espcaa/skolen-go"
 	"github.com/joho/godotenv"
 	"github.com/robfig/cron/v3"
 )
 
+var slackClient *slack.Client
+
 func main() {
 	godotenv.Load()
 
-	file, err := os.Open("tokens.json")
+	tokensPath := os.Getenv("TOKENS_FILE")
+	if tokensPath == "" {
+		tokensPath = "tokens.json"
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "tokens" {
+		if err := tokenfile.Command(os.Args[2:], tokensPath); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	slackClient = slack.NewFromEnv("SLACK_TOKEN")
+
+	key, err := tokenfile.LoadKey()
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer file.Close()
 
-	data, err := io.ReadAll(file)
+	data, err := tokenfile.Read(tokensPath, key)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -34,10 +49,21 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// loading may already have refreshed an expired token
+	if err := saveTokens(tokensPath, key, client); err != nil {
+		log.Println("Error saving tokens:", err)
+	}
+
 	c := cron.New()
 	c.AddFunc("@every 1h", func() {
 		if client.TokenSet.ExpiresAt.Sub(time.Now()) < 30*time.Minute {
-			skolengo.RefreshAccessToken(client)
+			if err := skolengo.RefreshAccessToken(client); err != nil {
+				log.Println("Error refreshing token:", err)
+				return
+			}
+			if err := saveTokens(tokensPath, key, client); err != nil {
+				log.Println("Error saving tokens:", err)
+			}
 		}
 	})
 	c.AddFunc("0 7 * * *", func() {
@@ -49,6 +75,20 @@ func main() {
 
 }
 
+// saveTokens writes the client's current token set back to the token file,
+// so a rotated refresh token survives a restart.
+func saveTokens(path string, key []byte, client *skolengo.Client) error {
+	// skolen-go only fills ExpiresAt, the raw field is what gets serialized
+	client.TokenSet.RawExpiresAt = client.TokenSet.ExpiresAt.Unix()
+
+	data, err := json.MarshalIndent(client, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return tokenfile.Write(path, key, data)
+}
+
 func setupDay(client *skolengo.Client) {
 
 	now := time.Now()
@@ -118,38 +158,15 @@ func scheduleMessage(t time.Time, msg string) {
 }
 
 func sendSlackMessage(message string) error {
-	token, channel := os.Getenv("SLACK_TOKEN"), os.Getenv("SLACK_CHANNEL_ID")
-	if token == "" || channel == "" {
-		return fmt.Errorf("Slack token or channel not set")
-	}
-
-	body := fmt.Sprintf(`{"channel":"%s","text":"%s"}`, channel, message)
-	req, err := http.NewRequest("POST", "https://slack.com/api/chat.postMessage", strings.NewReader(body))
-	if err != nil {
-		return err
-	}
-
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return err
+	channel := os.Getenv("SLACK_CHANNEL_ID")
+	if channel == "" {
+		return fmt.Errorf("Slack channel not set")
 	}
-	defer resp.Body.Close()
 
-	var result struct {
-		OK    bool   `json:"ok"`
-		Error string `json:"error,omitempty"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if _, err := slackClient.PostMessage(context.Background(), slack.Message{Channel: channel, Text: message}); err != nil {
 		return err
 	}
 
-	if !result.OK {
-		return fmt.Errorf("Slack API error: %s", result.Error)
-	}
-
 	log.Println("Slack message sent:", message)
 	return nil
 }
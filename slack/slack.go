@@ -0,0 +1,260 @@
+// Package slack is the small Slack Web API client shared by the bots in this
+// repo. It only covers what the bots need (posting messages) but does it
+// properly: JSON encoding, typed errors, rate-limit handling and retries.
+package slack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const DefaultBaseURL = "https://slack.com/api"
+
+// ErrNoToken is returned when the client has no bot token configured.
+var ErrNoToken = errors.New("slack: bot token not set")
+
+type Client struct {
+	Token      string
+	BaseURL    string
+	HTTPClient *http.Client
+
+	// MaxRetries is how many times a call is retried after a rate limit,
+	// a 5xx or a network error. Zero disables retries. Methods that write,
+	// like chat.postMessage, are only retried when Slack can't have acted
+	// on the request: after a rate limit or a failure to connect.
+	MaxRetries int
+	// Backoff is the wait before the first retry, doubled on every attempt.
+	// Rate-limited calls wait for Retry-After instead.
+	Backoff time.Duration
+	// MaxBackoff caps the doubling of Backoff. A Retry-After longer than
+	// that isn't waited for, the call fails with the RateLimitError
+	// instead. Zero means no cap.
+	MaxBackoff time.Duration
+}
+
+// idempotentMethods are the methods that only read, so sending one twice is
+// harmless. Any other method is taken to have side effects.
+var idempotentMethods = map[string]bool{
+	"auth.test":             true,
+	"conversations.history": true,
+	"conversations.info":    true,
+	"conversations.replies": true,
+	"users.info":            true,
+}
+
+type Message struct {
+	Channel  string `json:"channel"`
+	Text     string `json:"text"`
+	ThreadTS string `json:"thread_ts,omitempty"`
+}
+
+type PostMessageResponse struct {
+	Channel string `json:"channel"`
+	TS      string `json:"ts"`
+}
+
+// APIError is an `"ok": false` reply from Slack, e.g. channel_not_found.
+type APIError struct {
+	Method string
+	Code   string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("slack %s: %s", e.Method, e.Code)
+}
+
+// RateLimitError is returned when Slack answered 429 and we ran out of retries.
+type RateLimitError struct {
+	Method     string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("slack %s: rate limited, retry after %s", e.Method, e.RetryAfter)
+}
+
+// StatusError is a non-200 HTTP response that isn't a rate limit.
+type StatusError struct {
+	Method     string
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("slack %s returned %d: %s", e.Method, e.StatusCode, e.Body)
+}
+
+func New(token string) *Client {
+	return &Client{
+		Token:      token,
+		BaseURL:    DefaultBaseURL,
+		HTTPClient: &http.Client{Timeout: 15 * time.Second},
+		MaxRetries: 3,
+		Backoff:    time.Second,
+		MaxBackoff: 30 * time.Second,
+	}
+}
+
+// NewFromEnv builds a client with the token from tokenVar. SLACK_API_URL
+// overrides the base URL so a bot can be pointed at a local fake.
+func NewFromEnv(tokenVar string) *Client {
+	c := New(os.Getenv(tokenVar))
+	if u := os.Getenv("SLACK_API_URL"); u != "" {
+		c.BaseURL = u
+	}
+	return c
+}
+
+func (c *Client) PostMessage(ctx context.Context, msg Message) (*PostMessageResponse, error) {
+	var resp PostMessageResponse
+	if err := c.Call(ctx, "chat.postMessage", msg, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Call invokes a Web API method with a JSON payload and decodes the reply
+// into out (which may be nil), retrying transient failures.
+func (c *Client) Call(ctx context.Context, method string, payload, out any) error {
+	if c.Token == "" {
+		return ErrNoToken
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := c.do(ctx, method, body, out)
+		if err == nil {
+			return nil
+		}
+
+		wait, ok := c.retryDelay(method, err, attempt)
+		if !ok || attempt >= c.MaxRetries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (c *Client) do(ctx context.Context, method string, body []byte, out any) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", c.BaseURL+"/"+method, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return &RateLimitError{Method: method, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{Method: method, StatusCode: resp.StatusCode, Body: string(raw)}
+	}
+
+	var envelope struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error,omitempty"`
+	}
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		return fmt.Errorf("slack %s: decoding response: %w", method, err)
+	}
+	if !envelope.OK {
+		if envelope.Error == "ratelimited" {
+			return &RateLimitError{Method: method, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
+		}
+		return &APIError{Method: method, Code: envelope.Error}
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(raw, out); err != nil {
+			return fmt.Errorf("slack %s: decoding response: %w", method, err)
+		}
+	}
+	return nil
+}
+
+// retryDelay reports whether err is worth retrying for method and how long
+// to wait.
+func (c *Client) retryDelay(method string, err error, attempt int) (time.Duration, bool) {
+	var rateLimit *RateLimitError
+	if errors.As(err, &rateLimit) {
+		if c.MaxBackoff > 0 && rateLimit.RetryAfter > c.MaxBackoff {
+			return 0, false
+		}
+		return rateLimit.RetryAfter, true
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return 0, false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode < 500 {
+		return 0, false
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return 0, false
+	}
+
+	// a 5xx or a network error, after which a write may or may not have
+	// gone through, unless the connection was never made
+	if !idempotentMethods[method] && !notSent(err) {
+		return 0, false
+	}
+
+	wait := c.Backoff << attempt
+	if c.MaxBackoff > 0 && (wait > c.MaxBackoff || wait <= 0) {
+		wait = c.MaxBackoff
+	}
+	return wait, true
+}
+
+// notSent reports whether err happened before the request reached Slack,
+// like a DNS failure or a refused connection.
+func notSent(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func parseRetryAfter(v string) time.Duration {
+	secs, err := strconv.Atoi(v)
+	if err != nil || secs < 0 {
+		return time.Second
+	}
+	return time.Duration(secs) * time.Second
+}
@@ -0,0 +1,287 @@
+package slack
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeSlack answers every call with handler and counts the calls.
+func fakeSlack(t *testing.T, handler http.HandlerFunc) (*Client, *atomic.Int32) {
+	t.Helper()
+
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if got := r.Header.Get("Authorization"); got != "Bearer xoxb-test" {
+			t.Errorf("Authorization = %q", got)
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	c := New("xoxb-test")
+	c.BaseURL = srv.URL
+	c.Backoff = time.Millisecond
+	return c, &calls
+}
+
+func reply(status int, header http.Header, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for name, values := range header {
+			w.Header()[name] = values
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+func TestPostMessage(t *testing.T) {
+	c, calls := fakeSlack(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/chat.postMessage" {
+			t.Errorf("path = %s", r.URL.Path)
+		}
+		reply(http.StatusOK, nil, `{"ok":true,"channel":"C123","ts":"1700000000.000100"}`)(w, r)
+	})
+
+	resp, err := c.PostMessage(context.Background(), Message{Channel: "C123", Text: "hi"})
+	if err != nil {
+		t.Fatalf("PostMessage() error = %v", err)
+	}
+	if resp.Channel != "C123" || resp.TS != "1700000000.000100" {
+		t.Errorf("PostMessage() = %+v", resp)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("%d calls, want 1", n)
+	}
+}
+
+func TestNoToken(t *testing.T) {
+	c := New("")
+	if _, err := c.PostMessage(context.Background(), Message{}); !errors.Is(err, ErrNoToken) {
+		t.Errorf("PostMessage() error = %v, want ErrNoToken", err)
+	}
+}
+
+func TestCallErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		handler http.HandlerFunc
+		check   func(t *testing.T, err error)
+		calls   int32
+	}{
+		{
+			name:    "ok false",
+			method:  "chat.postMessage",
+			handler: reply(http.StatusOK, nil, `{"ok":false,"error":"channel_not_found"}`),
+			check: func(t *testing.T, err error) {
+				var apiErr *APIError
+				if !errors.As(err, &apiErr) || apiErr.Method != "chat.postMessage" || apiErr.Code != "channel_not_found" {
+					t.Errorf("error = %#v, want an APIError of channel_not_found", err)
+				}
+			},
+			calls: 1,
+		},
+		{
+			name:    "ok false, rate limited",
+			method:  "chat.postMessage",
+			handler: reply(http.StatusOK, http.Header{"Retry-After": {"0"}}, `{"ok":false,"error":"ratelimited"}`),
+			check: func(t *testing.T, err error) {
+				var rateLimit *RateLimitError
+				if !errors.As(err, &rateLimit) || rateLimit.RetryAfter != 0 {
+					t.Errorf("error = %#v, want a RateLimitError", err)
+				}
+			},
+			calls: 4,
+		},
+		{
+			name:    "429, retried even for a write",
+			method:  "chat.postMessage",
+			handler: reply(http.StatusTooManyRequests, http.Header{"Retry-After": {"0"}}, ``),
+			check: func(t *testing.T, err error) {
+				var rateLimit *RateLimitError
+				if !errors.As(err, &rateLimit) {
+					t.Errorf("error = %#v, want a RateLimitError", err)
+				}
+			},
+			calls: 4,
+		},
+		{
+			name:    "Retry-After past the max backoff",
+			method:  "auth.test",
+			handler: reply(http.StatusTooManyRequests, http.Header{"Retry-After": {"120"}}, ``),
+			check: func(t *testing.T, err error) {
+				var rateLimit *RateLimitError
+				if !errors.As(err, &rateLimit) || rateLimit.RetryAfter != 2*time.Minute {
+					t.Errorf("error = %#v, want a RateLimitError of 2m", err)
+				}
+			},
+			calls: 1,
+		},
+		{
+			name:    "5xx, not retried for a write",
+			method:  "chat.postMessage",
+			handler: reply(http.StatusInternalServerError, nil, `oops`),
+			check: func(t *testing.T, err error) {
+				var statusErr *StatusError
+				if !errors.As(err, &statusErr) || statusErr.StatusCode != 500 || statusErr.Body != "oops" {
+					t.Errorf("error = %#v, want a StatusError of 500", err)
+				}
+			},
+			calls: 1,
+		},
+		{
+			name:    "5xx, retried for a read",
+			method:  "auth.test",
+			handler: reply(http.StatusBadGateway, nil, `bad gateway`),
+			check: func(t *testing.T, err error) {
+				var statusErr *StatusError
+				if !errors.As(err, &statusErr) || statusErr.StatusCode != 502 {
+					t.Errorf("error = %#v, want a StatusError of 502", err)
+				}
+			},
+			calls: 4,
+		},
+		{
+			name:    "4xx",
+			method:  "auth.test",
+			handler: reply(http.StatusNotFound, nil, `not found`),
+			check: func(t *testing.T, err error) {
+				var statusErr *StatusError
+				if !errors.As(err, &statusErr) || statusErr.StatusCode != 404 {
+					t.Errorf("error = %#v, want a StatusError of 404", err)
+				}
+			},
+			calls: 1,
+		},
+		{
+			name:    "not json",
+			method:  "chat.postMessage",
+			handler: reply(http.StatusOK, nil, `<html>`),
+			check: func(t *testing.T, err error) {
+				var apiErr *APIError
+				if errors.As(err, &apiErr) {
+					t.Errorf("error = %#v, want a decoding error", err)
+				}
+			},
+			calls: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, calls := fakeSlack(t, tt.handler)
+
+			err := c.Call(context.Background(), tt.method, map[string]string{"channel": "C123"}, nil)
+			if err == nil {
+				t.Fatal("Call() error = nil")
+			}
+			tt.check(t, err)
+			if n := calls.Load(); n != tt.calls {
+				t.Errorf("%d calls, want %d", n, tt.calls)
+			}
+		})
+	}
+}
+
+func TestRetryAfterThenSuccess(t *testing.T) {
+	var calls atomic.Int32
+	c, _ := fakeSlack(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			reply(http.StatusTooManyRequests, http.Header{"Retry-After": {"1"}}, ``)(w, r)
+			return
+		}
+		reply(http.StatusOK, nil, `{"ok":true,"channel":"C123","ts":"1.2"}`)(w, r)
+	})
+
+	start := time.Now()
+	if _, err := c.PostMessage(context.Background(), Message{Channel: "C123", Text: "hi"}); err != nil {
+		t.Fatalf("PostMessage() error = %v", err)
+	}
+	if waited := time.Since(start); waited < time.Second {
+		t.Errorf("retried after %s, want the second of Retry-After", waited)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	c := New("xoxb-test")
+	c.Backoff = time.Second
+	c.MaxBackoff = 5 * time.Second
+
+	dialErr := &url.Error{Op: "Post", URL: "https://slack.com/api", Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}
+	readErr := &url.Error{Op: "Post", URL: "https://slack.com/api", Err: &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}}
+	serverErr := &StatusError{Method: "auth.test", StatusCode: 503}
+
+	tests := []struct {
+		name    string
+		method  string
+		err     error
+		attempt int
+		want    time.Duration
+		ok      bool
+	}{
+		{"Retry-After", "chat.postMessage", &RateLimitError{RetryAfter: 3 * time.Second}, 0, 3 * time.Second, true},
+		{"Retry-After at the max", "chat.postMessage", &RateLimitError{RetryAfter: 5 * time.Second}, 0, 5 * time.Second, true},
+		{"Retry-After past the max", "chat.postMessage", &RateLimitError{RetryAfter: 6 * time.Second}, 0, 0, false},
+		{"first backoff", "auth.test", serverErr, 0, time.Second, true},
+		{"doubled", "auth.test", serverErr, 1, 2 * time.Second, true},
+		{"doubled again", "auth.test", serverErr, 2, 4 * time.Second, true},
+		{"capped", "auth.test", serverErr, 3, 5 * time.Second, true},
+		{"still capped", "auth.test", serverErr, 70, 5 * time.Second, true},
+		{"write after a 5xx", "chat.postMessage", serverErr, 0, 0, false},
+		{"write that never connected", "chat.postMessage", dialErr, 1, 2 * time.Second, true},
+		{"write cut off after sending", "chat.postMessage", readErr, 0, 0, false},
+		{"read cut off after sending", "conversations.history", readErr, 0, time.Second, true},
+		{"api error", "auth.test", &APIError{Code: "invalid_auth"}, 0, 0, false},
+		{"canceled", "auth.test", context.Canceled, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := c.retryDelay(tt.method, tt.err, tt.attempt)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("retryDelay() = %s, %t, want %s, %t", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestRefusedConnection(t *testing.T) {
+	// a port nothing listens on any more
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	c := New("xoxb-test")
+	c.BaseURL = srv.URL
+	c.Backoff = time.Millisecond
+
+	_, err := c.PostMessage(context.Background(), Message{Channel: "C123", Text: "hi"})
+	if !notSent(err) {
+		t.Errorf("PostMessage() error = %v, want a failure to connect", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{"30", 30 * time.Second},
+		{"0", 0},
+		{"", time.Second},
+		{"-1", time.Second},
+		{"Wed, 21 Oct 2015 07:28:00 GMT", time.Second},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.header); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package tokenfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+const usage = "usage: tokens <encrypt|decrypt|keygen> [file]"
+
+// Command implements the `tokens` subcommand the bots share, used to migrate
+// existing token files to or from encryption in place:
+//
+//	tokens encrypt [file]
+//	tokens decrypt [file]
+//	tokens keygen
+//
+// Without a file it goes over defaultPaths, skipping those that don't
+// exist, like a backup that was never made.
+func Command(args []string, defaultPaths ...string) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	var convert func(path string, key []byte) error
+	switch args[0] {
+	case "keygen":
+		key, err := GenerateKey()
+		if err != nil {
+			return err
+		}
+		fmt.Println(key)
+		return nil
+	case "encrypt":
+		convert = encryptFile
+	case "decrypt":
+		convert = decryptFile
+	default:
+		return errors.New(usage)
+	}
+
+	key, err := LoadKey()
+	if err != nil {
+		return err
+	}
+	if key == nil && args[0] == "encrypt" {
+		return errors.New("set TOKENS_ENCRYPTION_KEY or TOKENS_ENCRYPTION_KEY_FILE first (tokens keygen makes one)")
+	}
+
+	if len(args) > 1 {
+		return convert(args[1], key)
+	}
+
+	var errs []error
+	found := false
+	for _, path := range defaultPaths {
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		found = true
+		if err := convert(path, key); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+		}
+	}
+	if !found {
+		return errors.New("no token file found, pass one as an argument")
+	}
+	return errors.Join(errs...)
+}
+
+func encryptFile(path string, key []byte) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if IsEncrypted(data) {
+		fmt.Println(path, "is already encrypted")
+		return nil
+	}
+
+	if err := Write(path, key, data); err != nil {
+		return err
+	}
+	fmt.Println("Encrypted", path)
+	return nil
+}
+
+func decryptFile(path string, key []byte) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !IsEncrypted(data) {
+		fmt.Println(path, "is not encrypted")
+		return nil
+	}
+
+	plaintext, err := Decrypt(key, data)
+	if err != nil {
+		return err
+	}
+	if err := WriteAtomic(path, plaintext); err != nil {
+		return err
+	}
+	fmt.Println("Decrypted", path)
+	return nil
+}
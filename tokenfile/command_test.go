@@ -0,0 +1,179 @@
+package tokenfile
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runCommand runs Command and returns what it printed.
+func runCommand(t *testing.T, args []string, defaultPaths ...string) (string, error) {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	cmdErr := Command(args, defaultPaths...)
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out), cmdErr
+}
+
+func TestCommandKeygen(t *testing.T) {
+	out, err := runCommand(t, []string{"keygen"})
+	if err != nil {
+		t.Fatalf("tokens keygen: %v", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(out))
+	if err != nil || len(key) != keySize {
+		t.Errorf("tokens keygen printed %q, want a base64 key of %d bytes", out, keySize)
+	}
+}
+
+func TestCommandEncryptDecrypt(t *testing.T) {
+	t.Setenv("TOKENS_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString(testKey(t)))
+	t.Setenv("TOKENS_ENCRYPTION_KEY_FILE", "")
+
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	if err := os.WriteFile(path, []byte(testTokens), 0600); err != nil {
+		t.Fatal(err)
+	}
+	read := func() []byte {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	if _, err := runCommand(t, []string{"encrypt"}, path); err != nil {
+		t.Fatalf("tokens encrypt: %v", err)
+	}
+	encrypted := read()
+	if !IsEncrypted(encrypted) {
+		t.Fatalf("tokens encrypt left %q", encrypted)
+	}
+
+	// encrypting twice doesn't wrap it again
+	out, err := runCommand(t, []string{"encrypt"}, path)
+	if err != nil || !strings.Contains(out, "already encrypted") {
+		t.Errorf("tokens encrypt again = %q, %v, want already encrypted", out, err)
+	}
+	if !bytes.Equal(read(), encrypted) {
+		t.Error("tokens encrypt again changed the file")
+	}
+
+	// the path argument wins over the default
+	if _, err := runCommand(t, []string{"decrypt", path}, filepath.Join(t.TempDir(), "other.json")); err != nil {
+		t.Fatalf("tokens decrypt: %v", err)
+	}
+	if got := read(); string(got) != testTokens {
+		t.Errorf("tokens decrypt left %q, want the tokens", got)
+	}
+
+	out, err = runCommand(t, []string{"decrypt"}, path)
+	if err != nil || !strings.Contains(out, "not encrypted") {
+		t.Errorf("tokens decrypt again = %q, %v, want not encrypted", out, err)
+	}
+}
+
+func TestCommandDefaultPaths(t *testing.T) {
+	t.Setenv("TOKENS_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString(testKey(t)))
+	t.Setenv("TOKENS_ENCRYPTION_KEY_FILE", "")
+
+	dir := t.TempDir()
+	var paths []string
+	for _, name := range []string{"a.json", "a.json.bak", "b.json"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(testTokens), 0600); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	// b was never backed up
+	defaults := append(paths, filepath.Join(dir, "b.json.bak"))
+
+	if _, err := runCommand(t, []string{"encrypt"}, defaults...); err != nil {
+		t.Fatalf("tokens encrypt: %v", err)
+	}
+	for _, path := range paths {
+		if data, err := os.ReadFile(path); err != nil || !IsEncrypted(data) {
+			t.Errorf("%s isn't encrypted", filepath.Base(path))
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.json.bak")); !os.IsNotExist(err) {
+		t.Error("tokens encrypt made up a missing backup")
+	}
+
+	if _, err := runCommand(t, []string{"decrypt"}, defaults...); err != nil {
+		t.Fatalf("tokens decrypt: %v", err)
+	}
+	for _, path := range paths {
+		if data, err := os.ReadFile(path); err != nil || string(data) != testTokens {
+			t.Errorf("%s isn't decrypted", filepath.Base(path))
+		}
+	}
+
+	if _, err := runCommand(t, []string{"encrypt"}, filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("tokens encrypt without any file, error = nil")
+	}
+}
+
+func TestCommandErrors(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	data, err := Encrypt(testKey(t), []byte(testTokens))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	plain := filepath.Join(t.TempDir(), "plain.json")
+	if err := os.WriteFile(plain, []byte(testTokens), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		key  string
+		args []string
+	}{
+		{"no subcommand", "", nil},
+		{"unknown subcommand", "", []string{"rotate"}},
+		{"encrypt without a key", "", []string{"encrypt", plain}},
+		{"encrypt with a bad key", "nope", []string{"encrypt", plain}},
+		{"decrypt without a key", "", []string{"decrypt"}},
+		{"decrypt with the wrong key", base64.StdEncoding.EncodeToString(testKey(t)), []string{"decrypt"}},
+		{"missing file", base64.StdEncoding.EncodeToString(testKey(t)), []string{"encrypt", filepath.Join(t.TempDir(), "missing.json")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TOKENS_ENCRYPTION_KEY", tt.key)
+			t.Setenv("TOKENS_ENCRYPTION_KEY_FILE", "")
+
+			if _, err := runCommand(t, tt.args, path); err == nil {
+				t.Error("error = nil")
+			}
+		})
+	}
+
+	// failures leave the files alone
+	if got, err := os.ReadFile(path); err != nil || !bytes.Equal(got, data) {
+		t.Error("a failed command changed the encrypted file")
+	}
+	if got, err := os.ReadFile(plain); err != nil || string(got) != testTokens {
+		t.Error("a failed command changed the plaintext file")
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/espcaa/random-workflows-that-actually-are-bots/tokenfile
+
+go 1.24.0
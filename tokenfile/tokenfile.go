@@ -0,0 +1,195 @@
+// Package tokenfile reads and writes the bots' token files. Files can
+// optionally be encrypted at rest with AES-GCM, using a key from
+// TOKENS_ENCRYPTION_KEY or TOKENS_ENCRYPTION_KEY_FILE. Encrypted and
+// plaintext files are told apart by a header, so a bot keeps working while
+// its tokens.json is being migrated.
+package tokenfile
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// header prefixes every encrypted file and is bound to the ciphertext as
+// additional data.
+const header = "tokenfile:v1:"
+
+const keySize = 32
+
+var (
+	// ErrNoKey is returned when an encrypted file is read without a key.
+	ErrNoKey = errors.New("token file is encrypted but neither TOKENS_ENCRYPTION_KEY nor TOKENS_ENCRYPTION_KEY_FILE is set")
+	// ErrBadKey is returned when decryption fails, most likely a wrong key.
+	ErrBadKey = errors.New("token file could not be decrypted, wrong key?")
+)
+
+// LoadKey returns the encryption key from TOKENS_ENCRYPTION_KEY (base64) or
+// the file named by TOKENS_ENCRYPTION_KEY_FILE (base64 or 32 raw bytes). It
+// returns nil when neither is set, meaning files are stored in plaintext.
+func LoadKey() ([]byte, error) {
+	if v := os.Getenv("TOKENS_ENCRYPTION_KEY"); v != "" {
+		return parseKey([]byte(v))
+	}
+
+	if path := os.Getenv("TOKENS_ENCRYPTION_KEY_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading key file: %w", err)
+		}
+		if len(data) == keySize {
+			return data, nil
+		}
+		return parseKey(data)
+	}
+
+	return nil, nil
+}
+
+func parseKey(data []byte) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("encryption key is not valid base64: %w", err)
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("encryption key must be %d bytes, got %d", keySize, len(key))
+	}
+	return key, nil
+}
+
+// GenerateKey returns a fresh base64 key for TOKENS_ENCRYPTION_KEY.
+func GenerateKey() (string, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(header))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func Encrypt(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, plaintext, []byte(header))
+	return []byte(header + base64.StdEncoding.EncodeToString(sealed) + "\n"), nil
+}
+
+func Decrypt(key, data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, errors.New("token file is not encrypted")
+	}
+	if key == nil {
+		return nil, ErrNoKey
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data[len(header):])))
+	if err != nil {
+		return nil, fmt.Errorf("token file is damaged: %w", err)
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("token file is damaged: too short")
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, []byte(header))
+	if err != nil {
+		return nil, ErrBadKey
+	}
+	return plaintext, nil
+}
+
+// Read returns the contents of path, decrypting it if it is encrypted.
+func Read(path string, key []byte) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !IsEncrypted(data) {
+		return data, nil
+	}
+	return Decrypt(key, data)
+}
+
+// Write stores plaintext at path, encrypted when key is non-nil.
+func Write(path string, key, plaintext []byte) error {
+	data := plaintext
+	if key != nil {
+		var err error
+		if data, err = Encrypt(key, plaintext); err != nil {
+			return err
+		}
+	}
+	return WriteAtomic(path, data)
+}
+
+// WriteAtomic writes data to a temp file in the same directory, fsyncs it
+// and renames it over path, so a crash leaves either the old or the new
+// contents, never half of them.
+func WriteAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	// make the rename itself durable
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+
+	return nil
+}
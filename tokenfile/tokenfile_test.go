@@ -0,0 +1,204 @@
+package tokenfile
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testTokens = `{"access_token":"a","refresh_token":"r","expires_in":28800}`
+
+func testKey(t *testing.T) []byte {
+	t.Helper()
+
+	encoded, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := parseKey([]byte(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key := testKey(t)
+
+	data, err := Encrypt(key, []byte(testTokens))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if !IsEncrypted(data) || !strings.HasPrefix(string(data), "tokenfile:v1:") {
+		t.Fatalf("Encrypt() = %q, want the tokenfile:v1: header", data)
+	}
+	if bytes.Contains(data, []byte("refresh_token")) {
+		t.Fatal("the encrypted file has the plaintext in it")
+	}
+
+	// a fresh nonce each time
+	again, err := Encrypt(key, []byte(testTokens))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(data, again) {
+		t.Error("Encrypt() twice gave the same ciphertext")
+	}
+
+	plaintext, err := Decrypt(key, data)
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if string(plaintext) != testTokens {
+		t.Errorf("Decrypt() = %q, want %q", plaintext, testTokens)
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	key := testKey(t)
+	data, err := Encrypt(key, []byte(testTokens))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one bit of the ciphertext flipped, the header left alone
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data[len(header):])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	tampered := []byte(header + base64.StdEncoding.EncodeToString(sealed) + "\n")
+
+	tests := []struct {
+		name string
+		key  []byte
+		data []byte
+		want error // nil for any error
+	}{
+		{"wrong key", testKey(t), data, ErrBadKey},
+		{"no key", nil, data, ErrNoKey},
+		{"tampered", key, tampered, ErrBadKey},
+		{"not base64", key, []byte(header + "!!!\n"), nil},
+		{"too short", key, []byte(header + "AAAA\n"), nil},
+		{"plaintext", key, []byte(testTokens), nil},
+		{"short key", key[:10], data, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plaintext, err := Decrypt(tt.key, tt.data)
+			if err == nil {
+				t.Fatalf("Decrypt() = %q, want an error", plaintext)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("Decrypt() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	dir := t.TempDir()
+	key := testKey(t)
+
+	plain := filepath.Join(dir, "plain.json")
+	if err := Write(plain, nil, []byte(testTokens)); err != nil {
+		t.Fatal(err)
+	}
+	encrypted := filepath.Join(dir, "encrypted.json")
+	if err := Write(encrypted, key, []byte(testTokens)); err != nil {
+		t.Fatal(err)
+	}
+
+	// a plaintext file reads the same with or without a key
+	for _, k := range [][]byte{nil, key} {
+		got, err := Read(plain, k)
+		if err != nil || string(got) != testTokens {
+			t.Errorf("Read(plaintext) = %q, %v, want the tokens", got, err)
+		}
+	}
+
+	got, err := Read(encrypted, key)
+	if err != nil || string(got) != testTokens {
+		t.Errorf("Read(encrypted) = %q, %v, want the tokens", got, err)
+	}
+	if _, err := Read(encrypted, nil); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Read(encrypted) without a key, error = %v, want ErrNoKey", err)
+	}
+
+	for _, path := range []string{plain, encrypted} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if perm := info.Mode().Perm(); perm != 0600 {
+			t.Errorf("%s has mode %o, want 600", filepath.Base(path), perm)
+		}
+	}
+
+	// no temp file left behind
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("files left = %v, want only the two token files", names)
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	key := testKey(t)
+	encoded := base64.StdEncoding.EncodeToString(key)
+
+	dir := t.TempDir()
+	writeKeyFile := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	rawFile := writeKeyFile("raw.key", key)
+	base64File := writeKeyFile("base64.key", []byte(encoded+"\n"))
+	shortFile := writeKeyFile("short.key", []byte("c2hvcnQ=\n"))
+
+	tests := []struct {
+		name    string
+		env     string
+		file    string
+		want    []byte
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "env", env: encoded, want: key},
+		{name: "env with a newline", env: encoded + "\n", want: key},
+		{name: "env not base64", env: "not a key!", wantErr: true},
+		{name: "env too short", env: "c2hvcnQ=", wantErr: true},
+		{name: "env wins over the file", env: encoded, file: shortFile, want: key},
+		{name: "raw file", file: rawFile, want: key},
+		{name: "base64 file", file: base64File, want: key},
+		{name: "file too short", file: shortFile, wantErr: true},
+		{name: "missing file", file: filepath.Join(dir, "missing.key"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TOKENS_ENCRYPTION_KEY", tt.env)
+			t.Setenv("TOKENS_ENCRYPTION_KEY_FILE", tt.file)
+
+			got, err := LoadKey()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadKey() error = %v, want an error: %t", err, tt.wantErr)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("LoadKey() = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,3 +1,7 @@
 module wake-sleep
 
 go 1.24.0
+
+require github.com/espcaa/random-workflows-that-actually-are-bots/slack v0.0.0
+
+replace github.com/espcaa/random-workflows-that-actually-are-bots/slack => ../slack
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"os"
-	"os/exec"
+
+	"github.com/espcaa/random-workflows-that-actually-are-bots/slack"
 )
 
 var channelID string = "C080SMXTRS8"
@@ -36,17 +38,16 @@ func main() {
 }
 
 func sendMessageToSlack(message string) {
-	// send a message to slack using curl
-	slackbottoken := os.Getenv("SLACK_WORKFLOW_BOT_TOKEN")
-	if slackbottoken == "" {
+	client := slack.NewFromEnv("SLACK_WORKFLOW_BOT_TOKEN")
+	if client.Token == "" {
 		println("SLACK_WORKFLOW_BOT_TOKEN is not set")
 		return
 	}
 
-	resp, err := exec.Command("curl", "-X", "POST", "https://slack.com/api/chat.postMessage", "-H", "Authorization: Bearer "+slackbottoken, "-H", "Content-type: application/json", "--data", `{"channel":"`+channelID+`","text":"`+message+`"}`).Output()
+	resp, err := client.PostMessage(context.Background(), slack.Message{Channel: channelID, Text: message})
 	if err != nil {
 		println("Error sending message to Slack:", err.Error())
 		return
 	}
-	println("Response from Slack:", string(resp))
+	println("Message sent to Slack:", resp.TS)
 }